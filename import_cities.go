@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/shaybix/weather-monster/importer"
+	"github.com/shaybix/weather-monster/model"
+)
+
+// importCities implements the import-cities command, upserting the cities of a GeoNames dump
+// or CSV file and printing a report of the rows that were skipped
+func importCities(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("import-cities", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s import-cities [flags] <file|->\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	format := fs.String("format", "geonames", "format of the file, either geonames or csv")
+	admin1 := fs.String("admin1-codes", "", "GeoNames admin1CodesASCII.txt to resolve administrative region names")
+	columns := fs.String("columns", "", "CSV column mapping as field=column pairs, e.g. name=City,latitude=Lat")
+	delimiter := fs.String("delimiter", ",", "CSV field delimiter")
+	batchSize := fs.Int("batch-size", importer.DefaultBatchSize, "number of cities imported per transaction")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without changing the database")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected a single file to import")
+	}
+
+	var in io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var src importer.Reader
+	switch *format {
+	case "geonames":
+		var codes map[string]string
+		if *admin1 != "" {
+			f, err := os.Open(*admin1)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			codes, err = importer.ReadAdmin1Codes(f)
+			if err != nil {
+				return err
+			}
+		}
+
+		src = importer.NewGeoNamesReader(in, codes)
+	case "csv":
+		mapping, err := importer.ParseColumnMapping(*columns)
+		if err != nil {
+			return err
+		}
+
+		delim, size := utf8.DecodeRuneInString(*delimiter)
+		if size != len(*delimiter) || delim == utf8.RuneError {
+			return fmt.Errorf("delimiter must be a single character, got %q", *delimiter)
+		}
+
+		src, err = importer.NewCSVReader(in, delim, mapping)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q, expected geonames or csv", *format)
+	}
+
+	im := &importer.Importer{
+		CM:        model.NewCityManager(db),
+		BatchSize: *batchSize,
+		DryRun:    *dryRun,
+	}
+
+	report, runErr := im.Run(src)
+	printImportReport(os.Stdout, report, *dryRun)
+
+	return runErr
+}
+
+// printImportReport prints the skipped rows followed by a summary of an import
+func printImportReport(w io.Writer, report *importer.Report, dryRun bool) {
+	for _, issue := range report.Invalid {
+		fmt.Fprintf(w, "line %d: invalid: %s\n", issue.Line, issue.Reason)
+	}
+
+	for _, issue := range report.Duplicates {
+		fmt.Fprintf(w, "line %d: skipped: %s\n", issue.Line, issue.Reason)
+	}
+
+	if dryRun {
+		fmt.Fprintln(w, "dry run, no changes were made")
+	}
+
+	fmt.Fprintf(w, "%d inserted, %d updated, %d unchanged, %d invalid, %d duplicates\n",
+		report.Inserted, report.Updated, report.Unchanged, len(report.Invalid), len(report.Duplicates))
+}
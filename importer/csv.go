@@ -0,0 +1,145 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/shaybix/weather-monster/model"
+)
+
+// the fields of a city that columns of a CSV file can be mapped to
+const (
+	FieldName        = "name"
+	FieldLatitude    = "latitude"
+	FieldLongitude   = "longitude"
+	FieldCountryCode = "country_code"
+	FieldAdminRegion = "admin_region"
+	FieldTimeZone    = "time_zone"
+)
+
+// ColumnMapping maps fields of a city to the header of the CSV column holding them.
+// Fields that are not mapped are read from the column named like the field.
+type ColumnMapping map[string]string
+
+// ParseColumnMapping parses a mapping given as field=column pairs separated by commas,
+// e.g. "name=City,latitude=Lat,longitude=Lng"
+func ParseColumnMapping(s string) (ColumnMapping, error) {
+	mapping := ColumnMapping{}
+	if s == "" {
+		return mapping, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid column mapping %q, expected field=column", pair)
+		}
+
+		switch kv[0] {
+		case FieldName, FieldLatitude, FieldLongitude, FieldCountryCode, FieldAdminRegion, FieldTimeZone:
+			mapping[kv[0]] = kv[1]
+		default:
+			return nil, fmt.Errorf("unknown field %q in column mapping", kv[0])
+		}
+	}
+
+	return mapping, nil
+}
+
+// CSVReader reads cities from a CSV file whose first line is a header
+type CSVReader struct {
+	r       *csv.Reader
+	line    int
+	columns map[string]int
+}
+
+// NewCSVReader returns a reader of the CSV file r, reading its header to locate the columns of
+// the mapping. The name, latitude and longitude columns are required, the others optional.
+func NewCSVReader(r io.Reader, delimiter rune, mapping ColumnMapping) (*CSVReader, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = delimiter
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %v", err)
+	}
+
+	index := make(map[string]int, len(header))
+	for i, h := range header {
+		index[strings.TrimSpace(h)] = i
+	}
+
+	columns := make(map[string]int)
+	for _, field := range []string{FieldName, FieldLatitude, FieldLongitude, FieldCountryCode, FieldAdminRegion, FieldTimeZone} {
+		col := field
+		if mapped, ok := mapping[field]; ok {
+			col = mapped
+		}
+
+		i, ok := index[col]
+		if !ok {
+			switch field {
+			case FieldName, FieldLatitude, FieldLongitude:
+				return nil, fmt.Errorf("header has no column %q for the %s", col, field)
+			}
+			continue
+		}
+		columns[field] = i
+	}
+
+	return &CSVReader{
+		r:       cr,
+		line:    1,
+		columns: columns,
+	}, nil
+}
+
+// Next returns the city of the next line of the file
+func (cr *CSVReader) Next() (*Row, error) {
+	record, err := cr.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	cr.line++
+	if err != nil {
+		if perr, ok := err.(*csv.ParseError); ok {
+			return nil, &RowError{Line: cr.line, Reason: perr.Err.Error()}
+		}
+		return nil, err
+	}
+
+	value := func(field string) string {
+		i, ok := cr.columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	lat, err := strconv.ParseFloat(value(FieldLatitude), 64)
+	if err != nil {
+		return nil, &RowError{Line: cr.line, Reason: fmt.Sprintf("invalid latitude %q", value(FieldLatitude))}
+	}
+
+	lon, err := strconv.ParseFloat(value(FieldLongitude), 64)
+	if err != nil {
+		return nil, &RowError{Line: cr.line, Reason: fmt.Sprintf("invalid longitude %q", value(FieldLongitude))}
+	}
+
+	return &Row{
+		Line: cr.line,
+		City: &model.NewCity{
+			Name:        value(FieldName),
+			Latitude:    lat,
+			Longitude:   lon,
+			CountryCode: strings.ToUpper(value(FieldCountryCode)),
+			AdminRegion: value(FieldAdminRegion),
+			TimeZone:    value(FieldTimeZone),
+		},
+	}, nil
+}
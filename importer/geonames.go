@@ -0,0 +1,112 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/shaybix/weather-monster/model"
+)
+
+// the columns of a GeoNames dump, see http://download.geonames.org/export/dump/readme.txt
+const (
+	geoNamesName        = 1
+	geoNamesLatitude    = 4
+	geoNamesLongitude   = 5
+	geoNamesCountryCode = 8
+	geoNamesAdmin1Code  = 10
+	geoNamesTimeZone    = 17
+	geoNamesColumns     = 19
+)
+
+// maxGeoNamesLine is the size of the longest line expected in a GeoNames dump,
+// whose list of alternate names can be several kilobytes long
+const maxGeoNamesLine = 1 << 20
+
+// GeoNamesReader reads cities from a GeoNames cities*.txt dump
+type GeoNamesReader struct {
+	scanner *bufio.Scanner
+	line    int
+	admin1  map[string]string
+}
+
+// NewGeoNamesReader returns a reader of the GeoNames dump r. If admin1 is not nil, the
+// administrative region of a city is looked up by its "<country>.<admin1 code>" key as in
+// admin1CodesASCII.txt, otherwise the bare admin1 code is used as its region.
+func NewGeoNamesReader(r io.Reader, admin1 map[string]string) *GeoNamesReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxGeoNamesLine)
+
+	return &GeoNamesReader{
+		scanner: scanner,
+		admin1:  admin1,
+	}
+}
+
+// Next returns the city of the next line of the dump
+func (gr *GeoNamesReader) Next() (*Row, error) {
+	if !gr.scanner.Scan() {
+		if err := gr.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	gr.line++
+
+	fields := strings.Split(gr.scanner.Text(), "\t")
+	if len(fields) != geoNamesColumns {
+		return nil, &RowError{Line: gr.line, Reason: fmt.Sprintf("expected %d columns, got %d", geoNamesColumns, len(fields))}
+	}
+
+	lat, err := strconv.ParseFloat(fields[geoNamesLatitude], 64)
+	if err != nil {
+		return nil, &RowError{Line: gr.line, Reason: fmt.Sprintf("invalid latitude %q", fields[geoNamesLatitude])}
+	}
+
+	lon, err := strconv.ParseFloat(fields[geoNamesLongitude], 64)
+	if err != nil {
+		return nil, &RowError{Line: gr.line, Reason: fmt.Sprintf("invalid longitude %q", fields[geoNamesLongitude])}
+	}
+
+	country := fields[geoNamesCountryCode]
+	region := fields[geoNamesAdmin1Code]
+	if gr.admin1 != nil && region != "" {
+		region = gr.admin1[country+"."+region]
+	}
+
+	return &Row{
+		Line: gr.line,
+		City: &model.NewCity{
+			Name:        fields[geoNamesName],
+			Latitude:    lat,
+			Longitude:   lon,
+			CountryCode: country,
+			AdminRegion: region,
+			TimeZone:    fields[geoNamesTimeZone],
+		},
+	}, nil
+}
+
+// ReadAdmin1Codes reads the names of administrative regions from a GeoNames admin1CodesASCII.txt
+// file, keyed by "<country>.<admin1 code>"
+func ReadAdmin1Codes(r io.Reader) (map[string]string, error) {
+	codes := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 2 {
+			continue
+		}
+
+		codes[fields[0]] = fields[1]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,137 @@
+// Package importer imports cities in bulk from GeoNames dumps and CSV files, reporting the
+// rows that are invalid or duplicated within the source.
+package importer
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/shaybix/weather-monster/model"
+)
+
+// DefaultBatchSize is the number of cities imported within a single transaction if none is given
+const DefaultBatchSize = 500
+
+// Row describes a city read from a line of a source
+type Row struct {
+	Line int
+	City *model.NewCity
+}
+
+// RowError describes a line of a source that cannot be read as a city
+type RowError struct {
+	Line   int
+	Reason string
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// Reader reads the cities of a source one row at a time. Next returns a *RowError for a line
+// that cannot be read, after which reading continues, and io.EOF once the source is exhausted.
+type Reader interface {
+	Next() (*Row, error)
+}
+
+// Issue describes a line of a source that was not imported and why
+type Issue struct {
+	Line   int
+	Reason string
+}
+
+// Report describes the outcome of an import
+type Report struct {
+	Inserted   int
+	Updated    int
+	Unchanged  int
+	Invalid    []*Issue
+	Duplicates []*Issue
+}
+
+// Importer imports cities in batches, each within its own transaction
+type Importer struct {
+	CM        *model.CityManager
+	BatchSize int
+	DryRun    bool
+}
+
+// Run imports all cities read from src. Should a batch fail, the batches imported before it
+// remain in the database and the report up to that point is returned along with the error.
+func (im *Importer) Run(src Reader) (*Report, error) {
+	size := im.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+
+	report := &Report{}
+	seen := make(map[string]int)
+	batch := make([]*model.NewCity, 0, size)
+
+	for {
+		row, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if rerr, ok := err.(*RowError); ok {
+				report.Invalid = append(report.Invalid, &Issue{Line: rerr.Line, Reason: rerr.Reason})
+				continue
+			}
+			return report, err
+		}
+
+		if err := row.City.Validate(); err != nil {
+			report.Invalid = append(report.Invalid, &Issue{Line: row.Line, Reason: err.Error()})
+			continue
+		}
+
+		key := strings.Join([]string{row.City.Name, row.City.CountryCode, row.City.AdminRegion}, "\x00")
+		if first, ok := seen[key]; ok {
+			report.Duplicates = append(report.Duplicates, &Issue{
+				Line:   row.Line,
+				Reason: fmt.Sprintf("duplicate of line %d", first),
+			})
+			continue
+		}
+		seen[key] = row.Line
+
+		batch = append(batch, row.City)
+		if len(batch) == size {
+			if err := im.flush(batch, report); err != nil {
+				return report, err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := im.flush(batch, report); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// flush imports a batch of cities and counts the outcome in the report
+func (im *Importer) flush(batch []*model.NewCity, report *Report) error {
+	results, err := im.CM.Import(batch, im.DryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, res := range results {
+		switch res.Status {
+		case model.Inserted:
+			report.Inserted++
+		case model.Updated:
+			report.Updated++
+		case model.Unchanged:
+			report.Unchanged++
+		}
+	}
+
+	return nil
+}
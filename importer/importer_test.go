@@ -0,0 +1,103 @@
+package importer
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shaybix/weather-monster/model"
+	"github.com/stretchr/testify/require"
+)
+
+const geoNamesDump = "2950159\tBerlin\tBerlin\tBerlino\t52.52437\t13.41053\tP\tPPLC\tDE\t\t16\t00\t11000\t11000000\t3426354\t\t74\tEurope/Berlin\t2019-09-05\n" +
+	"2988507\tParis\tParis\t\t48.85341\t2.3488\tP\tPPLC\tFR\t\t11\t75\t751\t75056\t2138551\t\t42\tEurope/Paris\t2020-05-26\n" +
+	"2950159\tBerlin\tBerlin\tBerlino\t52.52437\t13.41053\tP\tPPLC\tDE\t\t16\t00\t11000\t11000000\t3426354\t\t74\tEurope/Berlin\t2019-09-05\n" +
+	"4717560\tParis\tParis\t\tnorth\t-95.55551\tP\tPPLA2\tUS\t\tTX\t277\t\t\t24782\t\t180\tAmerica/Chicago\t2017-03-09\n"
+
+func Test_CanReadGeoNamesDump(t *testing.T) {
+	r := require.New(t)
+
+	admin1 := map[string]string{"DE.16": "Berlin", "FR.11": "Île-de-France"}
+	gr := NewGeoNamesReader(strings.NewReader(geoNamesDump), admin1)
+
+	row, err := gr.Next()
+	r.NoError(err)
+	r.Equal(1, row.Line)
+	r.Equal(&model.NewCity{
+		Name:        "Berlin",
+		Latitude:    52.52437,
+		Longitude:   13.41053,
+		CountryCode: "DE",
+		AdminRegion: "Berlin",
+		TimeZone:    "Europe/Berlin",
+	}, row.City)
+
+	row, err = gr.Next()
+	r.NoError(err)
+	r.Equal("Île-de-France", row.City.AdminRegion)
+
+	_, err = gr.Next()
+	r.NoError(err)
+
+	_, err = gr.Next()
+	r.IsType(&RowError{}, err)
+}
+
+func Test_CanReadCSVWithColumnMapping(t *testing.T) {
+	r := require.New(t)
+
+	mapping, err := ParseColumnMapping("name=City,latitude=Lat,longitude=Lng,country_code=Country")
+	r.NoError(err)
+
+	csv := "City;Lat;Lng;Country\nPotsdam;52.39;13.06;de\n"
+	cr, err := NewCSVReader(strings.NewReader(csv), ';', mapping)
+	r.NoError(err)
+
+	row, err := cr.Next()
+	r.NoError(err)
+	r.Equal(2, row.Line)
+	r.Equal("Potsdam", row.City.Name)
+	r.Equal("DE", row.City.CountryCode)
+}
+
+func Test_CannotReadCSVWithoutRequiredColumns(t *testing.T) {
+	r := require.New(t)
+
+	_, err := NewCSVReader(strings.NewReader("name,lat\nPotsdam,52.39\n"), ',', ColumnMapping{})
+	r.Error(err)
+}
+
+func Test_CanReportInvalidAndDuplicateRowsOnDryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	r := require.New(t)
+
+	expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels", "inserted"}
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO cities").WillReturnRows(
+		sqlmock.NewRows(expectedRows).
+			AddRow(1, "Berlin", 52.52437, 13.41053, "version-1", "DE", "16", "Europe/Berlin", `{}`, true),
+	)
+	mock.ExpectQuery("INSERT INTO cities").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	im := &Importer{
+		CM:     model.NewCityManager(db),
+		DryRun: true,
+	}
+
+	report, err := im.Run(NewGeoNamesReader(strings.NewReader(geoNamesDump), nil))
+	r.NoError(err)
+	r.Equal(1, report.Inserted)
+	r.Equal(1, report.Unchanged)
+	r.Len(report.Duplicates, 1)
+	r.Equal(3, report.Duplicates[0].Line)
+	r.Len(report.Invalid, 1)
+	r.Equal(4, report.Invalid[0].Line)
+	r.NoError(mock.ExpectationsWereMet())
+}
@@ -6,14 +6,24 @@ import (
 
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
 	"github.com/shaybix/weather-monster/service"
 )
 
+// defaultDatabaseURL is the postgres database connected to unless DATABASE_URL is set
+const defaultDatabaseURL = "postgresql://postgres:secret@db:5432/weather?sslmode=disable"
+
 func main() {
-	// FIXME: one should be taking the values environment variables
-	connStr := "postgresql://postgres:secret@db:5432/weather?sslmode=disable"
+	connStr := os.Getenv("DATABASE_URL")
+	if connStr == "" {
+		connStr = defaultDatabaseURL
+	}
+
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		log.Fatalf("error connecting to postgres: %v", err)
@@ -24,20 +34,143 @@ func main() {
 		}
 	}()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import-cities":
+			if err := importCities(db, os.Args[2:]); err != nil {
+				log.Fatalf("error importing cities: %v", err)
+			}
+		case "purge-cities":
+			if err := purgeCities(db, os.Args[2:]); err != nil {
+				log.Fatalf("error purging cities: %v", err)
+			}
+		default:
+			log.Fatalf("unknown command %q, expected import-cities, purge-cities or no command to serve the API", os.Args[1])
+		}
+		return
+	}
+
+	serve(db)
+}
+
+// serve serves the API on port 3000
+func serve(db *sql.DB) {
 	mgr := service.NewServiceManager(db)
 
+	if v := os.Getenv("IDEMPOTENCY_WINDOW"); v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			log.Fatalf("IDEMPOTENCY_WINDOW must be a positive duration, got %q", v)
+		}
+		mgr.IdempotencyWindow = window
+	}
+	go purgeIdempotencyKeys(mgr)
+
+	if v := os.Getenv("FORECAST_DECIMALS"); v != "" {
+		decimals, err := strconv.Atoi(v)
+		if err != nil || decimals < 0 || decimals > model.TemperatureDecimals {
+			log.Fatalf("FORECAST_DECIMALS must be between 0 and %d, got %q", model.TemperatureDecimals, v)
+		}
+		mgr.ForecastDecimals = decimals
+	}
+
+	if v := os.Getenv("OUTLIER_ACTION"); v != "" {
+		switch action := model.OutlierAction(v); action {
+		case model.OutlierAccept, model.OutlierFlag, model.OutlierReject:
+			mgr.TM.OutlierAction = action
+		default:
+			log.Fatalf("OUTLIER_ACTION must be one of accept, flag or reject, got %q", v)
+		}
+	}
+
+	mgr.AdminToken = os.Getenv("ADMIN_TOKEN")
+	if mgr.AdminToken == "" {
+		log.Println("ADMIN_TOKEN is not set, so stations cannot be created")
+	}
+
+	if v := os.Getenv("REQUIRE_STATION"); v != "" {
+		require, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("REQUIRE_STATION must be true or false, got %q", v)
+		}
+		mgr.RequireStation = require
+	}
+
+	for env, retention := range map[string]*time.Duration{
+		"RETENTION_RAW":    &mgr.Retention.Raw,
+		"RETENTION_HOURLY": &mgr.Retention.Hourly,
+		"RETENTION_DAILY":  &mgr.Retention.Daily,
+	} {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				log.Fatalf("%s must be a duration, got %q", env, v)
+			}
+			*retention = d
+		}
+	}
+	if err := mgr.Retention.Validate(); err != nil {
+		log.Fatalf("invalid retention: %v", err)
+	}
+	go downsampleTemperatures(mgr)
+
 	r := mux.NewRouter()
+	r.Use(mgr.Idempotent)
 
 	// cities API endpoints
+	r.HandleFunc("/cities", mgr.ListCitiesHandler).Methods("GET")
 	r.HandleFunc("/cities", mgr.CreateCityHandler).Methods("POST")
+	// registered ahead of /cities/{id} so that "nearby" and "search" are not taken for an ID
+	r.HandleFunc("/cities/nearby", mgr.NearbyCitiesHandler).Methods("GET")
+	r.HandleFunc("/cities/search", mgr.SearchCitiesHandler).Methods("POST")
+	r.HandleFunc("/cities/{id}", mgr.GetCityHandler).Methods("GET")
 	r.HandleFunc("/cities/{id}", mgr.UpdateCityHandler).Methods("PATCH")
 	r.HandleFunc("/cities/{id}", mgr.DeleteCityHandler).Methods("DELETE")
+	r.HandleFunc("/cities/{id}/restore", mgr.RestoreCityHandler).Methods("POST")
+	r.HandleFunc("/cities/{id}/history", mgr.GetCityHistoryHandler).Methods("GET")
+	r.HandleFunc("/cities/{id}/history/{version}", mgr.GetCityRevisionHandler).Methods("GET")
+	r.HandleFunc("/cities/{id}/temperatures", mgr.ListTemperaturesHandler).Methods("GET")
+	r.HandleFunc("/cities/{id}/temperatures/history", mgr.GetTemperatureHistoryHandler).Methods("GET")
+	r.HandleFunc("/cities/{id}/observations", mgr.ListObservationsHandler).Methods("GET")
+	r.HandleFunc("/cities/{id}/stations", mgr.ListCityStationsHandler).Methods("GET")
+	r.HandleFunc("/cities/{id}/outlier_policy", mgr.GetOutlierPolicyHandler).Methods("GET")
+	r.HandleFunc("/cities/{id}/outlier_policy", mgr.SetOutlierPolicyHandler).Methods("PUT")
+	r.HandleFunc("/cities/{id}/retention_policy", mgr.GetRetentionPolicyHandler).Methods("GET")
+	r.HandleFunc("/cities/{id}/retention_policy", mgr.SetRetentionPolicyHandler).Methods("PUT")
+	r.HandleFunc("/cities/{id}/retention_policy", mgr.ResetRetentionPolicyHandler).Methods("DELETE")
 
-	// temperatures API endpoint
+	// temperatures API endpoints
 	r.HandleFunc("/temperatures", mgr.CreateTemperatureHandler).Methods("POST")
+	r.HandleFunc("/temperatures/batch", mgr.CreateTemperatureBatchHandler).Methods("POST")
+	r.HandleFunc("/temperatures/flagged", mgr.ListFlaggedTemperaturesHandler).Methods("GET")
+	r.HandleFunc("/temperatures/{id}", mgr.UpdateTemperatureHandler).Methods("PUT")
+	r.HandleFunc("/temperatures/{id}", mgr.DeleteTemperatureHandler).Methods("DELETE")
+	r.HandleFunc("/temperatures/{id}/corrections", mgr.ListTemperatureCorrectionsHandler).Methods("GET")
+
+	// stations API endpoints
+	r.HandleFunc("/stations", mgr.CreateStationHandler).Methods("POST")
+	r.HandleFunc("/stations/{id}", mgr.GetStationHandler).Methods("GET")
+	r.HandleFunc("/stations/{id}", mgr.DeleteStationHandler).Methods("DELETE")
+	r.HandleFunc("/stations/{id}/token", mgr.RotateStationTokenHandler).Methods("POST")
+
+	// observations API endpoints
+	r.HandleFunc("/observations", mgr.CreateObservationHandler).Methods("POST")
 
 	// forecasts API endpoint
+	r.HandleFunc("/forecasts", mgr.GetSelectorForecastHandler).Methods("GET")
 	r.HandleFunc("/forecasts/{id}", mgr.GetForecastHandler).Methods("GET")
+	r.HandleFunc("/forecasts/{id}/daily", mgr.GetDailyForecastHandler).Methods("GET")
+	r.HandleFunc("/forecasts/{id}/metrics", mgr.GetMetricsForecastHandler).Methods("GET")
+
+	// regions API endpoints
+	r.HandleFunc("/regions", mgr.ListRegionsHandler).Methods("GET")
+	r.HandleFunc("/regions", mgr.CreateRegionHandler).Methods("POST")
+	r.HandleFunc("/regions/{id}", mgr.GetRegionHandler).Methods("GET")
+	r.HandleFunc("/regions/{id}", mgr.UpdateRegionHandler).Methods("PATCH")
+	r.HandleFunc("/regions/{id}", mgr.DeleteRegionHandler).Methods("DELETE")
+	r.HandleFunc("/regions/{id}/cities/{city_id}", mgr.AddRegionCityHandler).Methods("PUT")
+	r.HandleFunc("/regions/{id}/cities/{city_id}", mgr.RemoveRegionCityHandler).Methods("DELETE")
+	r.HandleFunc("/regions/{id}/forecast", mgr.GetRegionForecastHandler).Methods("GET")
 
 	// webhooks API endpoint
 	r.HandleFunc("/webhooks", mgr.CreateWebhookHandler).Methods("POST")
@@ -45,3 +178,27 @@ func main() {
 
 	log.Fatal(http.ListenAndServe(":3000", r))
 }
+
+// purgeIdempotencyKeys deletes the idempotency keys that have outlived their window every hour
+func purgeIdempotencyKeys(mgr *service.Manager) {
+	for range time.Tick(time.Hour) {
+		if _, err := mgr.IM.Purge(mgr.IdempotencyWindow); err != nil {
+			log.Printf("error purging idempotency keys: %v", err)
+		}
+	}
+}
+
+// downsampleTemperatures rolls up the temperatures that have outlived their retention every hour
+func downsampleTemperatures(mgr *service.Manager) {
+	for range time.Tick(time.Hour) {
+		ds, err := mgr.TM.Downsample(mgr.Retention, time.Now())
+		if err != nil {
+			log.Printf("error downsampling temperatures: %v", err)
+			continue
+		}
+
+		if ds.Raw > 0 || ds.Hourly > 0 || ds.Daily > 0 {
+			log.Printf("downsampled %d temperatures and %d hourly aggregates, deleted %d daily aggregates", ds.Raw, ds.Hourly, ds.Daily)
+		}
+	}
+}
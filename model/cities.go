@@ -2,34 +2,148 @@ package model
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/gobuffalo/uuid"
 	"github.com/lib/pq"
+	"github.com/shaybix/weather-monster/timezone"
 )
 
-// City describes a city in the world, e.g. Berlin
+// MaxCityNameLength is the maximum number of characters of a city name
+const MaxCityNameLength = 50
+
+const (
+	// DefaultCityLimit is the number of cities returned in a listing if no limit is given
+	DefaultCityLimit = 20
+	// MaxCityLimit is the maximum number of cities returned in a single listing
+	MaxCityLimit = 100
+)
+
+// cityColumns are the columns selected whenever a city is read from the database
+const cityColumns = `ID, name, latitude, longitude, version, country_code, admin_region, time_zone, labels`
+
+// City describes a city in the world, e.g. Berlin. Cities of the same name are told apart by
+// the ISO 3166-1 alpha-2 code of their country and the administrative region they lie in.
+// TimeZone is the IANA time zone whose midnight starts a day in the city. Labels tag the city
+// for selecting it, e.g. customer=acme.
 type City struct {
-	ID        int64
-	Name      string
-	Latitude  float64
-	Longitude float64
-	Version   string
+	ID          int64
+	Name        string
+	Latitude    float64
+	Longitude   float64
+	Version     string
+	CountryCode string
+	AdminRegion string
+	TimeZone    string
+	Labels      Labels
 }
 
-// NewCity describes the form values of a new city
+// NewCity describes the form values of a new city.
+// An empty TimeZone is looked up from the coordinates of the city.
 type NewCity struct {
-	Name      string
-	Latitude  float64
-	Longitude float64
+	Name        string
+	Latitude    float64
+	Longitude   float64
+	CountryCode string
+	AdminRegion string
+	TimeZone    string
+	Labels      Labels
 }
 
-// CityUpdate describes the form values of a city to be updated
+// CityUpdate describes the fields of a city to be updated. Fields that are nil are left unchanged.
+// Labels replaces all labels of the city, after which SetLabels are added or changed and
+// RemoveLabels are removed.
 type CityUpdate struct {
-	ID        int64
-	Name      string
-	Latitude  float64
-	Longitude float64
-	Version   string
+	ID           int64
+	Name         *string
+	Latitude     *float64
+	Longitude    *float64
+	Version      string
+	CountryCode  *string
+	AdminRegion  *string
+	TimeZone     *string
+	Labels       Labels
+	SetLabels    Labels
+	RemoveLabels []string
+}
+
+// NearbyCity describes a city and its great-circle distance in kilometres to a location
+type NearbyCity struct {
+	City       *City
+	DistanceKm float64
+}
+
+// CityTemperature describes a city and its most recent temperature, if any has been recorded
+type CityTemperature struct {
+	City   *City
+	Latest *Temperature
+}
+
+// CityTemperaturePage describes a page of the cities within an area along with their most recent
+// temperature, and the cursor to retrieve the next page
+type CityTemperaturePage struct {
+	Cities     []*CityTemperature
+	NextCursor string
+}
+
+// ImportStatus describes what importing a city did to the database
+type ImportStatus string
+
+const (
+	// Inserted describes an imported city that did not exist before
+	Inserted ImportStatus = "inserted"
+	// Updated describes an imported city whose location or time zone changed
+	Updated ImportStatus = "updated"
+	// Unchanged describes an imported city that already existed as is
+	Unchanged ImportStatus = "unchanged"
+)
+
+// ImportResult describes the outcome of importing a single city. City is nil if it was unchanged.
+type ImportResult struct {
+	City   *City
+	Status ImportStatus
+}
+
+// CitySort describes the order in which cities are listed
+type CitySort string
+
+const (
+	// SortByID lists cities by ascending ID
+	SortByID CitySort = "id"
+	// SortByIDDesc lists cities by descending ID
+	SortByIDDesc CitySort = "-id"
+	// SortByName lists cities by ascending name
+	SortByName CitySort = "name"
+	// SortByNameDesc lists cities by descending name
+	SortByNameDesc CitySort = "-name"
+)
+
+// CityQuery describes the filters and pagination of a city listing
+type CityQuery struct {
+	NamePrefix  string
+	Name        string
+	CountryCode string
+	AdminRegion string
+	Selector    Selector
+	Sort        CitySort
+	Limit       int
+	Cursor      string
+}
+
+// CityPage describes a page of listed cities and the cursor of the page following it
+type CityPage struct {
+	Cities     []*City
+	NextCursor string
+}
+
+// cityCursor describes the position of the last city of a listed page
+type cityCursor struct {
+	Sort CitySort `json:"s"`
+	ID   int64    `json:"i"`
+	Name string   `json:"n,omitempty"`
 }
 
 // CityManager describes a city model mannager
@@ -37,23 +151,47 @@ type CityManager struct {
 	db *sql.DB
 }
 
-// Create creates a new non-existing entry of a city in the database
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCity scans a row of cityColumns, followed by any extra columns into dest
+func scanCity(row rowScanner, dest ...interface{}) (*City, error) {
+	var city City
+	cols := []interface{}{&city.ID, &city.Name, &city.Latitude, &city.Longitude, &city.Version, &city.CountryCode, &city.AdminRegion, &city.TimeZone, &city.Labels}
+	if err := row.Scan(append(cols, dest...)...); err != nil {
+		return nil, err
+	}
+
+	return &city, nil
+}
+
+// Create creates a new non-existing entry of a city in the database.
+// A *ValidationError is returned if any field of the city is invalid.
 func (cm *CityManager) Create(nc *NewCity) (*City, error) {
+	if err := nc.Validate(); err != nil {
+		return nil, err
+	}
+
 	version, err := uuid.NewV4()
 	if err != nil {
 		return nil, err
 	}
 
+	tz := nc.TimeZone
+	if tz == "" {
+		tz = timezone.Lookup(nc.Latitude, nc.Longitude)
+	}
+
 	sqlStmt := `
 	INSERT INTO cities
-	(name, latitude, longitude, version) 
-	VALUES($1,$2,$3,$4)
-	RETURNING ID, name, latitude, longitude, version;
+	(name, latitude, longitude, version, country_code, admin_region, time_zone, labels) 
+	VALUES($1,$2,$3,$4,$5,$6,$7,$8)
+	RETURNING ` + cityColumns + `;
 	`
 
-	var city City
-	if err := cm.db.QueryRow(sqlStmt, nc.Name, nc.Latitude, nc.Longitude, version.String()).
-		Scan(&city.ID, &city.Name, &city.Latitude, &city.Longitude, &city.Version); err != nil {
+	city, err := scanCity(cm.db.QueryRow(sqlStmt, nc.Name, nc.Latitude, nc.Longitude, version.String(), nc.CountryCode, nc.AdminRegion, tz, nc.Labels))
+	if err != nil {
 		if pgerr, ok := err.(*pq.Error); ok {
 			if pgerr.Code == "23505" {
 				return nil, ErrAlreadyExists
@@ -62,57 +200,582 @@ func (cm *CityManager) Create(nc *NewCity) (*City, error) {
 		return nil, err
 	}
 
-	return &city, nil
+	return city, nil
+}
+
+// Import upserts cities within a single transaction: cities that do not exist yet are created,
+// while existing cities of the same name, country and region get their location and time zone
+// updated. On a dry run the transaction is rolled back, leaving the database untouched while
+// still reporting what the import would do.
+func (cm *CityManager) Import(ncs []*NewCity, dryRun bool) ([]*ImportResult, error) {
+	tx, err := cm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	// the update is skipped for cities that would not change, so that their version is kept
+	sqlStmt := `
+	INSERT INTO cities
+	(name, latitude, longitude, version, country_code, admin_region, time_zone)
+	VALUES($1,$2,$3,$4,$5,$6,$7)
+	ON CONFLICT (name, country_code, admin_region) WHERE deleted_at IS NULL DO UPDATE
+	SET latitude = EXCLUDED.latitude, longitude = EXCLUDED.longitude,
+		time_zone = EXCLUDED.time_zone, version = EXCLUDED.version
+	WHERE (cities.latitude, cities.longitude, cities.time_zone)
+		IS DISTINCT FROM (EXCLUDED.latitude, EXCLUDED.longitude, EXCLUDED.time_zone)
+	RETURNING ` + cityColumns + `, (xmax = 0) AS inserted;
+	`
+
+	results := make([]*ImportResult, 0, len(ncs))
+	for _, nc := range ncs {
+		version, err := uuid.NewV4()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		tz := nc.TimeZone
+		if tz == "" {
+			tz = timezone.Lookup(nc.Latitude, nc.Longitude)
+		}
+
+		var inserted bool
+		city, err := scanCity(tx.QueryRow(sqlStmt, nc.Name, nc.Latitude, nc.Longitude, version.String(), nc.CountryCode, nc.AdminRegion, tz), &inserted)
+		switch {
+		case err == sql.ErrNoRows:
+			results = append(results, &ImportResult{Status: Unchanged})
+		case err != nil:
+			tx.Rollback()
+			return nil, err
+		case inserted:
+			results = append(results, &ImportResult{City: city, Status: Inserted})
+		default:
+			results = append(results, &ImportResult{City: city, Status: Updated})
+		}
+	}
+
+	if dryRun {
+		if err := tx.Rollback(); err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Get returns an existing city from the database
+func (cm *CityManager) Get(id int64) (*City, error) {
+	sqlStmt := `
+	SELECT ` + cityColumns + ` FROM cities
+	WHERE ID = $1 AND deleted_at IS NULL;
+	`
+
+	city, err := scanCity(cm.db.QueryRow(sqlStmt, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return city, nil
+}
+
+// List returns a page of cities matching the given query
+func (cm *CityManager) List(cq *CityQuery) (*CityPage, error) {
+	if err := cq.Validate(); err != nil {
+		return nil, err
+	}
+
+	sort := cq.Sort
+	if sort == "" {
+		sort = SortByID
+	}
+
+	limit := cq.Limit
+	if limit <= 0 {
+		limit = DefaultCityLimit
+	}
+	if limit > MaxCityLimit {
+		limit = MaxCityLimit
+	}
+
+	var q query
+	q.where("deleted_at IS NULL")
+	if cq.NamePrefix != "" {
+		q.where("name ILIKE " + q.arg(escapeLike(cq.NamePrefix)+"%"))
+	}
+	if cq.Name != "" {
+		q.where("name = " + q.arg(cq.Name))
+	}
+	if cq.CountryCode != "" {
+		q.where("country_code = " + q.arg(cq.CountryCode))
+	}
+	if cq.AdminRegion != "" {
+		q.where("admin_region = " + q.arg(cq.AdminRegion))
+	}
+	cq.Selector.where(&q, "labels")
+
+	var orderBy string
+	switch sort {
+	case SortByID:
+		orderBy = "ID ASC"
+	case SortByIDDesc:
+		orderBy = "ID DESC"
+	case SortByName:
+		orderBy = "name ASC, ID ASC"
+	case SortByNameDesc:
+		orderBy = "name DESC, ID DESC"
+	default:
+		return nil, ErrInvalidSort
+	}
+
+	if cq.Cursor != "" {
+		cur, err := decodeCityCursor(cq.Cursor)
+		if err != nil || cur.Sort != sort {
+			return nil, ErrInvalidCursor
+		}
+
+		switch sort {
+		case SortByID:
+			q.where("ID > " + q.arg(cur.ID))
+		case SortByIDDesc:
+			q.where("ID < " + q.arg(cur.ID))
+		case SortByName:
+			q.where(fmt.Sprintf("(name, ID) > (%s, %s)", q.arg(cur.Name), q.arg(cur.ID)))
+		case SortByNameDesc:
+			q.where(fmt.Sprintf("(name, ID) < (%s, %s)", q.arg(cur.Name), q.arg(cur.ID)))
+		}
+	}
+
+	sqlStmt := `
+	SELECT ` + cityColumns + ` FROM cities
+	` + q.whereClause() + `
+	ORDER BY ` + orderBy + `
+	LIMIT ` + q.arg(limit+1) + `;
+	`
+
+	rows, err := cm.db.Query(sqlStmt, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &CityPage{
+		Cities: []*City{},
+	}
+	for rows.Next() {
+		city, err := scanCity(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Cities = append(page.Cities, city)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Cities) > limit {
+		page.Cities = page.Cities[:limit]
+
+		last := page.Cities[limit-1]
+		page.NextCursor, err = encodeCityCursor(&cityCursor{
+			Sort: sort,
+			ID:   last.ID,
+			Name: last.Name,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// Nearby returns up to limit cities within radiusKm kilometres of the given location,
+// ordered by their great-circle distance to it
+func (cm *CityManager) Nearby(lat, lon, radiusKm float64, limit int) ([]*NearbyCity, error) {
+	if limit <= 0 {
+		limit = DefaultCityLimit
+	}
+	if limit > MaxCityLimit {
+		limit = MaxCityLimit
+	}
+
+	// earth_box narrows the search down to a cube that can be looked up in cities_location_idx,
+	// which still contains cities in its corners that are farther away than the radius
+	sqlStmt := `
+	SELECT ` + cityColumns + `, distance FROM (
+		SELECT ` + cityColumns + `, earth_distance(ll_to_earth($1, $2), ll_to_earth(latitude, longitude)) / 1000 AS distance
+		FROM cities
+		WHERE earth_box(ll_to_earth($1, $2), $3::float8 * 1000) @> ll_to_earth(latitude, longitude)
+		AND deleted_at IS NULL
+	) AS candidates
+	WHERE distance <= $3
+	ORDER BY distance ASC, ID ASC
+	LIMIT $4;
+	`
+
+	rows, err := cm.db.Query(sqlStmt, lat, lon, radiusKm, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cities := []*NearbyCity{}
+	for rows.Next() {
+		var nc NearbyCity
+		nc.City, err = scanCity(rows, &nc.DistanceKm)
+		if err != nil {
+			return nil, err
+		}
+
+		cities = append(cities, &nc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cities, nil
 }
 
-// Update updates an existing city  in the database
+// WithinBoundingBox returns a page of up to limit cities located inside the bounding box, ordered
+// by ID, along with their most recent temperature. The cursor is that of the page before, or
+// empty for the first page; ErrInvalidCursor is returned if it is malformed.
+func (cm *CityManager) WithinBoundingBox(bb *BoundingBox, limit int, cursor string) (*CityTemperaturePage, error) {
+	if limit <= 0 {
+		limit = DefaultCityLimit
+	}
+	if limit > MaxCityLimit {
+		limit = MaxCityLimit
+	}
+
+	return cm.withinArea(bb, nil, limit, cursor)
+}
+
+// WithinPolygon returns a page of up to limit cities located inside the polygon, ordered by ID,
+// along with their most recent temperature. The cursor is that of the page before, or empty for
+// the first page; ErrInvalidCursor is returned if it is malformed.
+func (cm *CityManager) WithinPolygon(p Polygon, limit int, cursor string) (*CityTemperaturePage, error) {
+	if limit <= 0 {
+		limit = DefaultCityLimit
+	}
+	if limit > MaxCityLimit {
+		limit = MaxCityLimit
+	}
+
+	bb := p.Bounds()
+	if bb == nil {
+		return &CityTemperaturePage{Cities: []*CityTemperature{}}, nil
+	}
+
+	return cm.withinArea(bb, p, limit, cursor)
+}
+
+// withinArea returns a page of up to limit cities inside the bounding box, and the polygon unless
+// it is nil, with their most recent temperature
+func (cm *CityManager) withinArea(bb *BoundingBox, p Polygon, limit int, cursor string) (*CityTemperaturePage, error) {
+	var q query
+	q.where("deleted_at IS NULL")
+	q.where(fmt.Sprintf("latitude BETWEEN %s AND %s", q.arg(bb.MinLat), q.arg(bb.MaxLat)))
+	if bb.MinLon <= bb.MaxLon {
+		q.where(fmt.Sprintf("longitude BETWEEN %s AND %s", q.arg(bb.MinLon), q.arg(bb.MaxLon)))
+	} else {
+		// the box is split at the antimeridian into its eastern and western halves
+		q.where(fmt.Sprintf("(longitude BETWEEN %s AND 180 OR longitude BETWEEN -180 AND %s)", q.arg(bb.MinLon), q.arg(bb.MaxLon)))
+	}
+
+	// the bounding box narrows the search down to cities_location_idx, the polygon to the cities
+	// inside it, testing the longitudes of a polygon crossing the antimeridian as it is unwrapped
+	if len(p) > 0 {
+		point := "point(longitude, latitude)"
+		if p.CrossesAntimeridian() {
+			point = "point(CASE WHEN longitude < 0 THEN longitude + 360 ELSE longitude END, latitude)"
+		}
+
+		u := p.unwrapped()
+		q.where(fmt.Sprintf("%s::polygon @> %s", q.arg(ringLiteral(u[0])), point))
+		for _, hole := range u[1:] {
+			q.where(fmt.Sprintf("NOT %s::polygon @> %s", q.arg(ringLiteral(hole)), point))
+		}
+	}
+
+	if cursor != "" {
+		cur, err := decodeCityCursor(cursor)
+		if err != nil || cur.Sort != SortByID {
+			return nil, ErrInvalidCursor
+		}
+
+		q.where("ID > " + q.arg(cur.ID))
+	}
+
+	// the cities are limited before looking up their most recent temperature
+	sqlStmt := `
+	SELECT ` + cityColumns + `, temperature_id, min, max, timestamp, received_at
+	FROM (
+		SELECT ` + cityColumns + ` FROM cities
+		` + q.whereClause() + `
+		ORDER BY ID ASC
+		LIMIT ` + q.arg(limit+1) + `
+	) AS cities
+	LEFT JOIN LATERAL (
+		SELECT ID AS temperature_id, min, max, timestamp, received_at FROM temperatures
+		WHERE city_id = cities.ID AND NOT flagged
+		ORDER BY timestamp DESC, ID DESC
+		LIMIT 1
+	) AS latest ON true
+	ORDER BY ID ASC;
+	`
+
+	rows, err := cm.db.Query(sqlStmt, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cities := []*CityTemperature{}
+	for rows.Next() {
+		var tid, timestamp, receivedAt sql.NullInt64
+		var min, max sql.NullFloat64
+		city, err := scanCity(rows, &tid, &min, &max, &timestamp, &receivedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		ct := &CityTemperature{City: city}
+		if tid.Valid {
+			ct.Latest = &Temperature{
+				ID:         tid.Int64,
+				CityID:     city.ID,
+				Min:        min.Float64,
+				Max:        max.Float64,
+				Timestamp:  timestamp.Int64,
+				ReceivedAt: receivedAt.Int64,
+			}
+		}
+
+		cities = append(cities, ct)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := &CityTemperaturePage{Cities: cities}
+	if len(page.Cities) > limit {
+		page.Cities = page.Cities[:limit]
+
+		page.NextCursor, err = encodeCityCursor(&cityCursor{
+			Sort: SortByID,
+			ID:   page.Cities[limit-1].City.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// Update updates an existing city in the database. Unless the version of the update is
+// empty, the city is only updated if it is still at that version; otherwise
+// ErrVersionConflict is returned. The previous state is kept as a revision, see History.
+// A *ValidationError is returned if any field given in the update is invalid.
 func (cm *CityManager) Update(cu *CityUpdate) (*City, error) {
+	if err := cu.Validate(); err != nil {
+		return nil, err
+	}
+
 	newVersion, err := uuid.NewV4()
 	if err != nil {
 		return nil, err
 	}
 
+	var q query
+	set := "version = " + q.arg(newVersion.String())
+	if cu.Name != nil {
+		set += ", name = " + q.arg(*cu.Name)
+	}
+	if cu.Latitude != nil {
+		set += ", latitude = " + q.arg(*cu.Latitude)
+	}
+	if cu.Longitude != nil {
+		set += ", longitude = " + q.arg(*cu.Longitude)
+	}
+	if cu.CountryCode != nil {
+		set += ", country_code = " + q.arg(*cu.CountryCode)
+	}
+	if cu.AdminRegion != nil {
+		set += ", admin_region = " + q.arg(*cu.AdminRegion)
+	}
+	if cu.TimeZone != nil {
+		set += ", time_zone = " + q.arg(*cu.TimeZone)
+	}
+	if cu.Labels != nil || len(cu.SetLabels) > 0 || len(cu.RemoveLabels) > 0 {
+		labels := "labels"
+		if cu.Labels != nil {
+			labels = q.arg(cu.Labels) + "::jsonb"
+		}
+		if len(cu.SetLabels) > 0 {
+			labels = "(" + labels + " || " + q.arg(cu.SetLabels) + "::jsonb)"
+		}
+		if len(cu.RemoveLabels) > 0 {
+			labels = "(" + labels + " - " + q.arg(pq.StringArray(cu.RemoveLabels)) + "::text[])"
+		}
+		set += ", labels = " + labels
+	}
+
+	q.where("ID = " + q.arg(cu.ID))
+	q.where("deleted_at IS NULL")
+	if cu.Version != "" {
+		q.where("version = " + q.arg(cu.Version))
+	}
+
 	sqlStmt := `
 	UPDATE cities
-	SET name = $1, latitude = $2, longitude = $3, version = $4
-	WHERE ID = $5 AND version = $6
-	RETURNING ID, name, latitude, longitude, version;
+	SET ` + set + `
+	` + q.whereClause() + `
+	RETURNING ` + cityColumns + `;
 	`
 
-	var city City
-	if err := cm.db.QueryRow(sqlStmt, cu.Name, cu.Latitude, cu.Longitude, newVersion.String(), cu.ID, cu.Version).
-		Scan(&city.ID, &city.Name, &city.Latitude, &city.Longitude, &city.Version); err != nil {
-		if err, ok := err.(*pq.Error); ok {
-			if err.Code == "20000" {
-				return nil, ErrNotFound
+	city, err := scanCity(cm.db.QueryRow(sqlStmt, q.args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, cm.conflictOrNotFound(cu.ID)
+		}
+		if pgerr, ok := err.(*pq.Error); ok {
+			if pgerr.Code == "23505" {
+				return nil, ErrAlreadyExists
 			}
 		}
 		return nil, err
 	}
 
-	return &city, nil
+	return city, nil
 }
 
-// Delete deletes an existing city in the database
-func (cm *CityManager) Delete(id int64) (*City, error) {
+// Delete deletes an existing city by marking it as deleted, hiding it and its temperatures
+// until it is restored or purged. Unless the given version is empty, the city is only deleted
+// if it is still at that version; otherwise ErrVersionConflict is returned.
+func (cm *CityManager) Delete(id int64, version string) (*City, error) {
+	var q query
+	q.where("ID = " + q.arg(id))
+	q.where("deleted_at IS NULL")
+	if version != "" {
+		q.where("version = " + q.arg(version))
+	}
+
 	sqlStmt := `
-	DELETE FROM cities
-	WHERE ID = $1
-	RETURNING ID, name, latitude, longitude, version;
+	UPDATE cities
+	SET deleted_at = now()
+	` + q.whereClause() + `
+	RETURNING ` + cityColumns + `;
 	`
 
-	var city City
-	if err := cm.db.QueryRow(sqlStmt, id).
-		Scan(&city.ID, &city.Name, &city.Latitude, &city.Longitude, &city.Version); err != nil {
-		if err, ok := err.(*pq.Error); ok {
-			if err.Code == "20000" {
-				return nil, ErrNotFound
+	city, err := scanCity(cm.db.QueryRow(sqlStmt, q.args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, cm.conflictOrNotFound(id)
+		}
+		return nil, err
+	}
+
+	return city, nil
+}
+
+// Restore restores a deleted city under a new version. Restoring a city that is not deleted
+// returns it as is. ErrAlreadyExists is returned if another city of the same name, country and
+// region was created since it was deleted.
+func (cm *CityManager) Restore(id int64) (*City, error) {
+	newVersion, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlStmt := `
+	UPDATE cities
+	SET deleted_at = NULL, version = $1
+	WHERE ID = $2 AND deleted_at IS NOT NULL
+	RETURNING ` + cityColumns + `;
+	`
+
+	city, err := scanCity(cm.db.QueryRow(sqlStmt, newVersion.String(), id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return cm.Get(id)
+		}
+		if pgerr, ok := err.(*pq.Error); ok {
+			if pgerr.Code == "23505" {
+				return nil, ErrAlreadyExists
 			}
 		}
 		return nil, err
 	}
 
-	return &city, nil
+	return city, nil
+}
+
+// Purge permanently deletes the cities that were deleted longer than retention ago,
+// along with all their temperatures and webhooks. It returns the number of purged cities.
+func (cm *CityManager) Purge(retention time.Duration) (int64, error) {
+	sqlStmt := `
+	DELETE FROM cities
+	WHERE deleted_at < now() - $1::float8 * interval '1 second';
+	`
+
+	res, err := cm.db.Exec(sqlStmt, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// conflictOrNotFound tells apart why a conditional write of a city did not affect any row:
+// ErrVersionConflict if the city exists at another version, ErrNotFound if it does not exist.
+func (cm *CityManager) conflictOrNotFound(id int64) error {
+	sqlStmt := `
+	SELECT version FROM cities
+	WHERE ID = $1 AND deleted_at IS NULL;
+	`
+
+	var version string
+	if err := cm.db.QueryRow(sqlStmt, id).Scan(&version); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return ErrVersionConflict
+}
+
+func encodeCityCursor(cur *cityCursor) (string, error) {
+	b, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCityCursor(s string) (*cityCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cur cityCursor
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, err
+	}
+
+	return &cur, nil
 }
 
 // NewCityManager returns a new CityManager
@@ -3,8 +3,10 @@ package model
 import (
 	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
 )
 
@@ -19,7 +21,7 @@ func Test_CanCreateCity(t *testing.T) {
 			Longitude: 42.4532,
 		}
 
-		expectedRows := []string{"ID", "name", "latitude", "longitude", "version"}
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
 		mock.ExpectQuery("INSERT INTO").WillReturnRows(
 			sqlmock.NewRows(expectedRows).AddRow(
 				1,
@@ -27,6 +29,10 @@ func Test_CanCreateCity(t *testing.T) {
 				nc.Latitude,
 				nc.Longitude,
 				"random-version-string",
+				"DE",
+				"Berlin",
+				"Europe/Berlin",
+				`{}`,
 			),
 		)
 
@@ -64,25 +70,30 @@ func Test_CanUpdateCity(t *testing.T) {
 	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
 		r := require.New(t)
 
+		name, latitude, longitude := "NewCity", 23.131, 42.12131
 		cu := &CityUpdate{
 			ID:        1,
-			Name:      "NewCity",
-			Latitude:  23.131,
-			Longitude: 42.12131,
+			Name:      &name,
+			Latitude:  &latitude,
+			Longitude: &longitude,
 			Version:   "randomstring",
 		}
 
 		cm := NewCityManager(db)
 
 		changedName := "updated-city"
-		expectedRows := []string{"ID", "name", "latitude", "longitude", "version"}
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
 		mock.ExpectQuery("UPDATE").WillReturnRows(
 			sqlmock.NewRows(expectedRows).AddRow(
 				cu.ID,
 				changedName,
-				cu.Latitude,
-				cu.Longitude,
+				*cu.Latitude,
+				*cu.Longitude,
 				cu.Version,
+				"DE",
+				"Berlin",
+				"Europe/Berlin",
+				`{}`,
 			),
 		)
 
@@ -91,22 +102,47 @@ func Test_CanUpdateCity(t *testing.T) {
 		r.NotNil(city)
 		r.Equal(cu.ID, city.ID)
 		r.Equal(changedName, city.Name)
-		r.Equal(cu.Latitude, city.Latitude)
+		r.Equal(*cu.Latitude, city.Latitude)
 		r.Equal(cu.Version, city.Version)
 	}, t)
 }
 
+func Test_CanUpdateSingleFieldOfCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		latitude := 52.52
+		cu := &CityUpdate{ID: 1, Latitude: &latitude, Version: "current-version"}
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("UPDATE cities SET version = \\$1, latitude = \\$2 WHERE ID = \\$3 AND deleted_at IS NULL AND version = \\$4").
+			WithArgs(sqlmock.AnyArg(), latitude, 1, "current-version").
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", latitude, 13.405, "new-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+			)
+
+		city, err := cm.Update(cu)
+		r.NoError(err)
+		r.Equal("Berlin", city.Name)
+		r.Equal(latitude, city.Latitude)
+	}, t)
+}
+
 func Test_CannotUpdatedNonExistentCity(t *testing.T) {
 	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
 		r := require.New(t)
 
 		cm := NewCityManager(db)
 
+		name, latitude, longitude := "NewCity", 23.131, 42.12131
 		cu := &CityUpdate{
 			ID:        1,
-			Name:      "NewCity",
-			Latitude:  23.131,
-			Longitude: 42.12131,
+			Name:      &name,
+			Latitude:  &latitude,
+			Longitude: &longitude,
 			Version:   "randomstring",
 		}
 
@@ -125,18 +161,22 @@ func Test_CanDeleteCity(t *testing.T) {
 
 		cm := NewCityManager(db)
 
-		expectedRows := []string{"ID", "name", "latitude", "longitude", "version"}
-		mock.ExpectQuery("DELETE FROM cities").WillReturnRows(
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("UPDATE cities SET deleted_at").WillReturnRows(
 			sqlmock.NewRows(expectedRows).AddRow(
 				1,
 				"Berlin",
 				13.121431,
 				44.3421,
 				"random-version-string",
+				"DE",
+				"Berlin",
+				"Europe/Berlin",
+				`{}`,
 			),
 		)
 
-		city, err := cm.Delete(1)
+		city, err := cm.Delete(1, "")
 		r.NoError(err)
 		r.NotNil(city)
 	}, t)
@@ -148,11 +188,364 @@ func Test_CannotDeleteNonExistentCity(t *testing.T) {
 
 		cm := NewCityManager(db)
 
-		mock.ExpectQuery("DELETE FROM cities").WillReturnError(ErrNotFound)
+		mock.ExpectQuery("UPDATE cities SET deleted_at").WillReturnError(ErrNotFound)
 
-		city, err := cm.Delete(1)
+		city, err := cm.Delete(1, "")
 		r.Error(err)
 		r.Nil(city)
 		r.Equal(err, ErrNotFound)
 	}, t)
 }
+
+func Test_CanRestoreCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("UPDATE cities SET deleted_at = NULL").
+			WithArgs(sqlmock.AnyArg(), 1).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.405, "new-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+			)
+
+		city, err := cm.Restore(1)
+		r.NoError(err)
+		r.Equal("new-version", city.Version)
+	}, t)
+}
+
+func Test_CannotRestoreNonExistentCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		mock.ExpectQuery("UPDATE cities SET deleted_at = NULL").WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT (.+) FROM cities").WillReturnError(sql.ErrNoRows)
+
+		city, err := cm.Restore(1)
+		r.Nil(city)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
+
+func Test_CannotRestoreCityTakenByAnother(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		mock.ExpectQuery("UPDATE cities SET deleted_at = NULL").WillReturnError(&pq.Error{Code: "23505"})
+
+		city, err := cm.Restore(1)
+		r.Nil(city)
+		r.Equal(ErrAlreadyExists, err)
+	}, t)
+}
+
+func Test_CanPurgeCities(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		mock.ExpectExec("DELETE FROM cities WHERE deleted_at <").
+			WithArgs(float64(86400)).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+
+		n, err := cm.Purge(24 * time.Hour)
+		r.NoError(err)
+		r.Equal(int64(3), n)
+	}, t)
+}
+
+func Test_CanListCities(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").
+			WithArgs(`Ber%`, 3).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.40, "version-1", "DE", "Berlin", "Europe/Berlin", `{}`).
+					AddRow(2, "Bern", 46.94, 7.44, "version-2", "CH", "Bern", "Europe/Zurich", `{}`).
+					AddRow(3, "Bergen", 60.39, 5.32, "version-3", "NO", "Vestland", "Europe/Oslo", `{}`),
+			)
+
+		page, err := cm.List(&CityQuery{NamePrefix: "Ber", Limit: 2})
+		r.NoError(err)
+		r.Len(page.Cities, 2)
+		r.NotEmpty(page.NextCursor)
+
+		mock.ExpectQuery("SELECT (.+) FROM cities").
+			WithArgs(`Ber%`, int64(2), 3).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(3, "Bergen", 60.39, 5.32, "version-3", "NO", "Vestland", "Europe/Oslo", `{}`),
+			)
+
+		page, err = cm.List(&CityQuery{NamePrefix: "Ber", Limit: 2, Cursor: page.NextCursor})
+		r.NoError(err)
+		r.Len(page.Cities, 1)
+		r.Empty(page.NextCursor)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CannotListCitiesWithCursorOfAnotherSortOrder(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		cursor, err := encodeCityCursor(&cityCursor{Sort: SortByID, ID: 2})
+		r.NoError(err)
+
+		page, err := cm.List(&CityQuery{Sort: SortByName, Cursor: cursor})
+		r.Nil(page)
+		r.Equal(ErrInvalidCursor, err)
+	}, t)
+}
+
+func Test_CanGetCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(expectedRows).AddRow(1, "Berlin", 52.52, 13.40, "random-version-string", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+
+		city, err := cm.Get(1)
+		r.NoError(err)
+		r.NotNil(city)
+		r.Equal("Berlin", city.Name)
+		r.Equal("random-version-string", city.Version)
+	}, t)
+}
+
+func Test_CannotGetNonExistentCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnError(sql.ErrNoRows)
+
+		city, err := cm.Get(1)
+		r.Nil(city)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
+
+func Test_CannotUpdateCityWithOutdatedVersion(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		name, latitude, longitude := "NewCity", 23.131, 42.12131
+		cu := &CityUpdate{
+			ID:        1,
+			Name:      &name,
+			Latitude:  &latitude,
+			Longitude: &longitude,
+			Version:   "outdated-version",
+		}
+
+		mock.ExpectQuery("UPDATE cities").WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT version FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"version"}).AddRow("current-version"),
+		)
+
+		city, err := cm.Update(cu)
+		r.Nil(city)
+		r.Equal(ErrVersionConflict, err)
+	}, t)
+}
+
+func Test_CannotDeleteNonExistentCityWithVersion(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		mock.ExpectQuery("UPDATE cities SET deleted_at").WithArgs(1, "some-version").WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT version FROM cities").WithArgs(1).WillReturnError(sql.ErrNoRows)
+
+		city, err := cm.Delete(1, "some-version")
+		r.Nil(city)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
+
+func Test_CanFindNearbyCities(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels", "distance"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").
+			WithArgs(52.4, 13.1, 50.0, 5).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(2, "Potsdam", 52.39, 13.06, "version-2", "DE", "Brandenburg", "Europe/Berlin", `{}`, 3.1).
+					AddRow(1, "Berlin", 52.52, 13.40, "version-1", "DE", "Berlin", "Europe/Berlin", `{}`, 23.7),
+			)
+
+		cities, err := cm.Nearby(52.4, 13.1, 50, 5)
+		r.NoError(err)
+		r.Len(cities, 2)
+		r.Equal("Potsdam", cities[0].City.Name)
+		r.Equal(3.1, cities[0].DistanceKm)
+	}, t)
+}
+
+func Test_CanFindCitiesWithinPolygon(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels", "temperature_id", "min", "max", "timestamp", "received_at"}
+		mock.ExpectQuery(`SELECT (.+) FROM \( SELECT (.+) FROM cities WHERE (.+) AND \$5::polygon @> point\(longitude, latitude\) ORDER BY ID ASC LIMIT \$6 \) AS cities LEFT JOIN LATERAL`).
+			WithArgs(0.0, 10.0, 0.0, 10.0, "((0,0),(10,0),(0,10),(0,0))", 11).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Inside", 1.0, 1.0, "version-1", "", "", "UTC", `{}`, 7, 12, 19, 1579000000, 1579000060),
+			)
+
+		triangle := Polygon{{{0, 0}, {10, 0}, {0, 10}, {0, 0}}}
+
+		page, err := cm.WithinPolygon(triangle, 10, "")
+		r.NoError(err)
+		r.Len(page.Cities, 1)
+		r.Empty(page.NextCursor)
+		r.Equal("Inside", page.Cities[0].City.Name)
+		r.NotNil(page.Cities[0].Latest)
+		r.Equal(19.0, page.Cities[0].Latest.Max)
+	}, t)
+}
+
+func Test_CanFindCitiesWithinPolygonCrossingAntimeridian(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels", "temperature_id", "min", "max", "timestamp", "received_at"}
+		mock.ExpectQuery(`SELECT (.+) FROM cities WHERE (.+) AND \(longitude BETWEEN \$3 AND 180 OR longitude BETWEEN -180 AND \$4\) AND \$5::polygon @> point\(CASE WHEN longitude < 0 THEN longitude \+ 360 ELSE longitude END, latitude\)`).
+			WithArgs(-20.0, -15.0, 177.0, -178.0, "((177,-20),(182,-20),(182,-15),(177,-15),(177,-20))", DefaultCityLimit+1).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Suva", -18.14, 178.44, "version-1", "FJ", "Central", "Pacific/Fiji", `{}`, nil, nil, nil, nil, nil),
+			)
+
+		fiji := Polygon{{{177, -20}, {-178, -20}, {-178, -15}, {177, -15}, {177, -20}}}
+
+		page, err := cm.WithinPolygon(fiji, 0, "")
+		r.NoError(err)
+		r.Len(page.Cities, 1)
+		r.Equal("Suva", page.Cities[0].City.Name)
+	}, t)
+}
+
+func Test_CanPageCitiesWithinBoundingBox(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+		bb := &BoundingBox{MinLon: 12.9, MinLat: 52.3, MaxLon: 13.8, MaxLat: 52.7}
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels", "temperature_id", "min", "max", "timestamp", "received_at"}
+		mock.ExpectQuery(`SELECT (.+) FROM cities WHERE (.+) ORDER BY ID ASC LIMIT \$5 \)`).
+			WithArgs(52.3, 52.7, 12.9, 13.8, 2).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.40, "version-1", "DE", "Berlin", "Europe/Berlin", `{}`, nil, nil, nil, nil, nil).
+					AddRow(2, "Potsdam", 52.39, 13.06, "version-1", "DE", "Brandenburg", "Europe/Berlin", `{}`, nil, nil, nil, nil, nil),
+			)
+
+		page, err := cm.WithinBoundingBox(bb, 1, "")
+		r.NoError(err)
+		r.Len(page.Cities, 1)
+		r.NotEmpty(page.NextCursor)
+
+		// the next page starts after the last city of the page before
+		mock.ExpectQuery(`SELECT (.+) FROM cities WHERE (.+) AND ID > \$5 ORDER BY ID ASC LIMIT \$6 \)`).
+			WithArgs(52.3, 52.7, 12.9, 13.8, 1, 2).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(2, "Potsdam", 52.39, 13.06, "version-1", "DE", "Brandenburg", "Europe/Berlin", `{}`, nil, nil, nil, nil, nil),
+			)
+
+		page, err = cm.WithinBoundingBox(bb, 1, page.NextCursor)
+		r.NoError(err)
+		r.Len(page.Cities, 1)
+		r.Equal("Potsdam", page.Cities[0].City.Name)
+		r.Empty(page.NextCursor)
+
+		_, err = cm.WithinBoundingBox(bb, 1, "not-a-cursor")
+		r.Equal(ErrInvalidCursor, err)
+	}, t)
+}
+
+func Test_CanListCitiesByNameAndCountry(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities WHERE deleted_at IS NULL AND name = (.+) AND country_code = ").
+			WithArgs("Paris", "US", DefaultCityLimit+1).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(7, "Paris", 33.66, -95.55, "version-7", "US", "Texas", "America/Chicago", `{}`),
+			)
+
+		page, err := cm.List(&CityQuery{Name: "Paris", CountryCode: "US"})
+		r.NoError(err)
+		r.Len(page.Cities, 1)
+		r.Equal("Texas", page.Cities[0].AdminRegion)
+	}, t)
+}
+
+func Test_CanImportCities(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels", "inserted"}
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO cities (.+) ON CONFLICT").WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "Berlin", 52.52, 13.40, "version-1", "DE", "Berlin", "Europe/Berlin", `{}`, true),
+		)
+		mock.ExpectQuery("INSERT INTO cities (.+) ON CONFLICT").WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(2, "Paris", 48.85, 2.35, "version-2", "FR", "Île-de-France", "Europe/Paris", `{}`, false),
+		)
+		mock.ExpectCommit()
+
+		results, err := cm.Import([]*NewCity{
+			{Name: "Berlin", Latitude: 52.52, Longitude: 13.40, CountryCode: "DE", AdminRegion: "Berlin"},
+			{Name: "Paris", Latitude: 48.85, Longitude: 2.35, CountryCode: "FR", AdminRegion: "Île-de-France"},
+		}, false)
+		r.NoError(err)
+		r.Len(results, 2)
+		r.Equal(Inserted, results[0].Status)
+		r.Equal(Updated, results[1].Status)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
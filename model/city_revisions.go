@@ -0,0 +1,92 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// cityRevisionColumns are the columns selected whenever a city revision is read from the database
+const cityRevisionColumns = `r.city_id, r.version, r.previous_version, r.revised_at,
+	r.name, r.latitude, r.longitude, r.country_code, r.admin_region, r.time_zone,
+	r.previous_name, r.previous_latitude, r.previous_longitude, r.previous_country_code, r.previous_admin_region, r.previous_time_zone`
+
+// CityRevision describes a change of a city from one version to the next.
+// Revisions are recorded by the database whenever the version of a city changes.
+type CityRevision struct {
+	CityID    int64
+	RevisedAt time.Time
+	Previous  *City
+	Current   *City
+}
+
+// scanCityRevision scans a row of cityRevisionColumns
+func scanCityRevision(row rowScanner) (*CityRevision, error) {
+	var cr CityRevision
+	var prev, cur City
+	err := row.Scan(&cr.CityID, &cur.Version, &prev.Version, &cr.RevisedAt,
+		&cur.Name, &cur.Latitude, &cur.Longitude, &cur.CountryCode, &cur.AdminRegion, &cur.TimeZone,
+		&prev.Name, &prev.Latitude, &prev.Longitude, &prev.CountryCode, &prev.AdminRegion, &prev.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	prev.ID, cur.ID = cr.CityID, cr.CityID
+	cr.Previous, cr.Current = &prev, &cur
+
+	return &cr, nil
+}
+
+// History returns the revisions of an existing city, most recent first
+func (cm *CityManager) History(id int64) ([]*CityRevision, error) {
+	if _, err := cm.Get(id); err != nil {
+		return nil, err
+	}
+
+	sqlStmt := `
+	SELECT ` + cityRevisionColumns + `
+	FROM city_revisions r
+	WHERE r.city_id = $1
+	ORDER BY r.ID DESC;
+	`
+
+	rows, err := cm.db.Query(sqlStmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*CityRevision{}
+	for rows.Next() {
+		cr, err := scanCityRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, cr)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// Revision returns the revision that changed an existing city to the given version
+func (cm *CityManager) Revision(id int64, version string) (*CityRevision, error) {
+	sqlStmt := `
+	SELECT ` + cityRevisionColumns + `
+	FROM city_revisions r
+	JOIN cities c ON c.ID = r.city_id AND c.deleted_at IS NULL
+	WHERE r.city_id = $1 AND r.version = $2;
+	`
+
+	cr, err := scanCityRevision(cm.db.QueryRow(sqlStmt, id, version))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return cr, nil
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var cityRevisionRows = []string{"city_id", "version", "previous_version", "revised_at",
+	"name", "latitude", "longitude", "country_code", "admin_region", "time_zone",
+	"previous_name", "previous_latitude", "previous_longitude", "previous_country_code", "previous_admin_region", "previous_time_zone"}
+
+func Test_CanGetCityHistory(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		revisedAt := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}).
+				AddRow(1, "Berlin", 52.52, 13.405, "version-3", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM city_revisions r WHERE r.city_id = (.+) ORDER BY r.ID DESC").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(cityRevisionRows).
+				AddRow(1, "version-3", "version-2", revisedAt, "Berlin", 52.52, 13.405, "DE", "Berlin", "Europe/Berlin", "Berlin", 52.5, 13.4, "DE", "Berlin", "Europe/Berlin").
+				AddRow(1, "version-2", "version-1", revisedAt.Add(-time.Hour), "Berlin", 52.5, 13.4, "DE", "Berlin", "Europe/Berlin", "Berlinn", 52.5, 13.4, "DE", "Berlin", "Europe/Berlin"),
+		)
+
+		revisions, err := cm.History(1)
+		r.NoError(err)
+		r.Len(revisions, 2)
+		r.Equal("version-3", revisions[0].Current.Version)
+		r.Equal("version-2", revisions[0].Previous.Version)
+		r.Equal(52.5, revisions[0].Previous.Latitude)
+		r.Equal("Berlinn", revisions[1].Previous.Name)
+		r.Equal(int64(1), revisions[1].Current.ID)
+	}, t)
+}
+
+func Test_CannotGetHistoryOfNonExistentCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnError(sql.ErrNoRows)
+
+		revisions, err := cm.History(1)
+		r.Nil(revisions)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
+
+func Test_CanGetCityRevision(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM city_revisions r JOIN cities c (.+) WHERE r.city_id = (.+) AND r.version = ").
+			WithArgs(1, "version-2").
+			WillReturnRows(
+				sqlmock.NewRows(cityRevisionRows).
+					AddRow(1, "version-2", "version-1", time.Now(), "Berlin", 52.5, 13.4, "DE", "Berlin", "Europe/Berlin", "Berlinn", 52.5, 13.4, "DE", "Berlin", "Europe/Berlin"),
+			)
+
+		cr, err := cm.Revision(1, "version-2")
+		r.NoError(err)
+		r.Equal("Berlin", cr.Current.Name)
+		r.Equal("Berlinn", cr.Previous.Name)
+	}, t)
+}
+
+func Test_CannotGetNonExistentCityRevision(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		cm := NewCityManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM city_revisions").WillReturnError(sql.ErrNoRows)
+
+		cr, err := cm.Revision(1, "version-9")
+		r.Nil(cr)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
@@ -7,4 +7,22 @@ var (
 	ErrNotFound = errors.New("document(s) not found")
 	// ErrAlreadyExists describes an error where a document already exists in the database
 	ErrAlreadyExists = errors.New("document already exists")
+	// ErrVersionConflict describes an error where a document was changed since the version a write is based on
+	ErrVersionConflict = errors.New("document version conflict")
+	// ErrInvalidCursor describes an error where a pagination cursor is malformed or belongs to another query
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrInvalidSort describes an error where a listing is requested in an unsupported order
+	ErrInvalidSort = errors.New("invalid sort order")
+	// ErrTooManyCities describes an error where a label selector matches more cities than can be aggregated
+	ErrTooManyCities = errors.New("too many cities match the selector")
+	// ErrBatchFailed describes an error where a batch is not stored at all because some of its items failed
+	ErrBatchFailed = errors.New("batch failed")
+	// ErrBatchAborted describes an error where an item of a batch is not stored because other items failed
+	ErrBatchAborted = errors.New("batch aborted by the failure of other items")
+	// ErrInvalidCredentials describes an error where a station does not exist or authenticates with a wrong token
+	ErrInvalidCredentials = errors.New("invalid station credentials")
+	// ErrIdempotencyKeyReused describes an error where an idempotency key is used again for a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key already used for a different request")
+	// ErrIdempotencyKeyInProgress describes an error where a request is retried before the first one completed
+	ErrIdempotencyKeyInProgress = errors.New("request of idempotency key still in progress")
 )
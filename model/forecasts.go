@@ -2,17 +2,80 @@ package model
 
 import (
 	"database/sql"
-	"log"
-
-	"github.com/lib/pq"
+	"math"
+	"strings"
+	"time"
 )
 
-// Forecast describes a the forecast of a city with the average minimum and maximum temperature in the past 24h
+// MaxForecastDays is the maximum number of local days a daily forecast spans
+const MaxForecastDays = 31
+
+// MaxSelectedCities is the maximum number of cities a forecast by label selector spans
+const MaxSelectedCities = 100
+
+// dateLayout is the layout of the local date a forecast is computed for
+const dateLayout = "2006-01-02"
+
+// Forecast describes a the forecast of a city with the average minimum and maximum temperature
+// of a day, running from midnight to midnight in the time zone of the city. The averages are not
+// rounded.
 type Forecast struct {
-	CityID int64
-	Min    int64
-	Max    int64
-	Sample int64
+	CityID   int64
+	Date     string
+	TimeZone string
+	Min      float64
+	Max      float64
+	Sample   int64
+}
+
+// RegionForecast describes the forecast of a region, averaging the temperatures recorded on
+// the current local day of each of its member cities. Cities holds the forecast of each member.
+type RegionForecast struct {
+	RegionID int64
+	Min      float64
+	Max      float64
+	Sample   int64
+	Cities   []*Forecast
+}
+
+// SelectorForecast describes the forecast of the cities matching a label selector, averaging the
+// temperatures recorded on the current local day of each of them. Cities holds the forecast of each.
+type SelectorForecast struct {
+	Selector string
+	Min      float64
+	Max      float64
+	Sample   int64
+	Cities   []*Forecast
+}
+
+// MetricsForecast describes the aggregates of the observations of each metric in a city on its
+// current local day, running from midnight to midnight in its time zone
+type MetricsForecast struct {
+	CityID   int64
+	Date     string
+	TimeZone string
+	Metrics  []*MetricAggregate
+}
+
+// MetricAggregate describes the observations of a metric on a day in the base unit of the metric.
+// The Average of wind directions is their circular mean, and Total, the sum of the observations,
+// is only meaningful for cumulative metrics like precipitation. The aggregates are not rounded.
+type MetricAggregate struct {
+	Metric  Metric
+	Min     float64
+	Max     float64
+	Average float64
+	Total   float64
+	Sample  int64
+}
+
+// ForecastFilter describes which temperatures of a city a forecast is computed from and how they
+// are weighted. A StationID other than 0 only takes the temperatures reported by that station.
+// Weighted weighs each temperature by the weight of its station, and temperatures without a
+// station by DefaultStationWeight.
+type ForecastFilter struct {
+	StationID int64
+	Weighted  bool
 }
 
 // ForecastManager describes a forecast model manager
@@ -20,63 +83,404 @@ type ForecastManager struct {
 	DB *sql.DB
 }
 
-// Get returns the forecast of a city
-func (fm *ForecastManager) Get(cid int64) (*Forecast, error) {
+// Get returns the forecast of a city for its current local day, computed from the temperatures
+// selected by filter, or from all of them if filter is nil
+func (fm *ForecastManager) Get(cid int64, filter *ForecastFilter) (*Forecast, error) {
+	loc, err := fm.location(cid)
+	if err != nil {
+		return nil, err
+	}
 
+	forecasts, _, err := fm.daily(cid, loc, 1, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return forecasts[0], nil
+}
+
+// Daily returns the forecasts of a city for each of its last local days, the current day last,
+// computed from the temperatures selected by filter, or from all of them if filter is nil
+func (fm *ForecastManager) Daily(cid int64, days int, filter *ForecastFilter) ([]*Forecast, error) {
+	if days <= 0 {
+		days = 1
+	}
+	if days > MaxForecastDays {
+		days = MaxForecastDays
+	}
+
+	loc, err := fm.location(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	forecasts, _, err := fm.daily(cid, loc, days, filter)
+	return forecasts, err
+}
+
+// Region returns the forecast of a region for the current local day of each of its member cities
+func (fm *ForecastManager) Region(rid int64) (*RegionForecast, error) {
+	// the region is joined with its members so that a region without any is told apart from none
 	sqlStmt := `
-	SELECT min, max FROM temperatures 
-	WHERE city_id = $1
+	SELECT c.ID, c.time_zone FROM regions r
+	LEFT JOIN region_cities rc ON rc.region_id = r.ID
+	LEFT JOIN cities c ON c.ID = rc.city_id AND c.deleted_at IS NULL
+	WHERE r.ID = $1
+	ORDER BY c.ID
 	`
-	rows, err := fm.DB.Query(sqlStmt, cid)
+	rows, err := fm.DB.Query(sqlStmt, rid)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	var members []*forecastMember
+	for rows.Next() {
+		var id sql.NullInt64
+		var tz sql.NullString
+		if err := rows.Scan(&id, &tz); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		found = true
+		if id.Valid {
+			members = append(members, &forecastMember{id.Int64, tz.String})
+		}
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	agg, err := fm.aggregate(members)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegionForecast{
+		RegionID: rid,
+		Min:      agg.Min,
+		Max:      agg.Max,
+		Sample:   agg.Sample,
+		Cities:   agg.Cities,
+	}, nil
+}
+
+// Select returns the forecast of the cities matching a label selector for the current local day
+// of each of them. ErrTooManyCities is returned if more than MaxSelectedCities match.
+func (fm *ForecastManager) Select(sel Selector) (*SelectorForecast, error) {
+	q := &query{}
+	q.where("deleted_at IS NULL")
+	sel.where(q, "labels")
+
+	// one more than the maximum is read to tell whether there are too many
+	sqlStmt := `
+	SELECT ID, time_zone FROM cities
+	` + q.whereClause() + `
+	ORDER BY ID
+	LIMIT ` + q.arg(MaxSelectedCities+1)
+
+	rows, err := fm.DB.Query(sqlStmt, q.args...)
 	if err != nil {
-		if err, ok := err.(*pq.Error); ok {
-			if err.Code == "20000" {
-				return nil, ErrNotFound
-			}
+		return nil, err
+	}
+
+	var members []*forecastMember
+	for rows.Next() {
+		var m forecastMember
+		if err := rows.Scan(&m.id, &m.tz); err != nil {
+			rows.Close()
+			return nil, err
 		}
+		members = append(members, &m)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(members) > MaxSelectedCities {
+		return nil, ErrTooManyCities
+	}
+
+	agg, err := fm.aggregate(members)
+	if err != nil {
+		return nil, err
+	}
+
+	agg.Selector = sel.String()
+	return agg, nil
+}
+
+// Metrics returns the aggregates of the observations of a city on its current local day, of the
+// given metric or of every metric if it is empty. Metrics without observations are included with
+// an empty sample.
+func (fm *ForecastManager) Metrics(cid int64, metric Metric) (*MetricsForecast, error) {
+	loc, err := fm.location(cid)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 0, 1)
+
+	metrics := Metrics
+	if metric != "" {
+		metrics = []Metric{metric}
+	}
+
+	q := &query{}
+	q.where("city_id = " + q.arg(cid))
+	q.where("timestamp >= " + q.arg(from.Unix()))
+	q.where("timestamp < " + q.arg(to.Unix()))
+	if metric != "" {
+		q.where("metric = " + q.arg(string(metric)))
+	}
+
+	sqlStmt := `
+	SELECT metric, value FROM observations
+	` + q.whereClause()
+
+	rows, err := fm.DB.Query(sqlStmt, q.args...)
+	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var sample int64
-	var mins []int64
-	var maxs []int64
+	values := make(map[Metric][]float64, len(metrics))
+	for rows.Next() {
+		var m Metric
+		var v float64
+		if err := rows.Scan(&m, &v); err != nil {
+			return nil, err
+		}
+		values[m] = append(values[m], v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	mf := &MetricsForecast{
+		CityID:   cid,
+		Date:     from.Format(dateLayout),
+		TimeZone: loc.String(),
+		Metrics:  make([]*MetricAggregate, 0, len(metrics)),
+	}
+	for _, m := range metrics {
+		mf.Metrics = append(mf.Metrics, aggregateMetric(m, values[m]))
+	}
+
+	return mf, nil
+}
+
+// aggregateMetric aggregates the observations of a metric
+func aggregateMetric(m Metric, values []float64) *MetricAggregate {
+	agg := &MetricAggregate{Metric: m, Sample: int64(len(values))}
+	if len(values) == 0 {
+		return agg
+	}
+
+	agg.Min, agg.Max = values[0], values[0]
+	for _, v := range values {
+		agg.Min = math.Min(agg.Min, v)
+		agg.Max = math.Max(agg.Max, v)
+		agg.Total += v
+	}
+
+	if spec, ok := metricSpecs[m]; ok && spec.circular {
+		agg.Average = circularMean(values)
+	} else {
+		agg.Average = agg.Total / float64(len(values))
+	}
+
+	return agg
+}
+
+// forecastMember describes a city a forecast is aggregated across
+type forecastMember struct {
+	id int64
+	tz string
+}
+
+// aggregate computes the forecast of each member for its current local day, averaging all of
+// their temperatures into a single forecast
+func (fm *ForecastManager) aggregate(members []*forecastMember) (*SelectorForecast, error) {
+	agg := &SelectorForecast{Cities: make([]*Forecast, 0, len(members))}
+	var total forecastSample
+	for _, m := range members {
+		loc, err := time.LoadLocation(m.tz)
+		if err != nil {
+			return nil, err
+		}
+
+		forecasts, samples, err := fm.daily(m.id, loc, 1, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		agg.Cities = append(agg.Cities, forecasts[0])
+		total.mins = append(total.mins, samples[0].mins...)
+		total.maxs = append(total.maxs, samples[0].maxs...)
+		total.weights = append(total.weights, samples[0].weights...)
+		total.count += samples[0].count
+	}
+
+	if len(total.mins) > 0 {
+		agg.Sample = total.count
+		agg.Min = weightedMean(total.mins, total.weights)
+		agg.Max = weightedMean(total.maxs, total.weights)
+	}
+
+	return agg, nil
+}
+
+// daily computes the forecasts of a city in the given time zone for each of its last local days,
+// along with the temperatures sampled on each of them
+func (fm *ForecastManager) daily(cid int64, loc *time.Location, days int, filter *ForecastFilter) ([]*Forecast, []*forecastSample, error) {
+	if filter == nil {
+		filter = &ForecastFilter{}
+	}
+
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from := today.AddDate(0, 0, 1-days)
+	to := today.AddDate(0, 0, 1)
+
+	forecasts := make([]*Forecast, days)
+	samples := make([]*forecastSample, days)
+	byDate := make(map[string]*forecastSample, days)
+	for i := range forecasts {
+		forecasts[i] = &Forecast{
+			CityID:   cid,
+			Date:     from.AddDate(0, 0, i).Format(dateLayout),
+			TimeZone: loc.String(),
+		}
+		samples[i] = &forecastSample{}
+		byDate[forecasts[i].Date] = samples[i]
+	}
 
-	forecast := &Forecast{
-		CityID: cid,
+	// temperatures that have been rolled up are read from the aggregates holding them, each
+	// counting as many times as the temperatures it aggregates
+	q := &query{}
+	conds := []string{
+		"city_id = " + q.arg(cid),
+		"timestamp >= " + q.arg(from.Unix()),
+		"timestamp < " + q.arg(to.Unix()),
 	}
+	if filter.StationID != 0 {
+		conds = append(conds, "station_id = "+q.arg(filter.StationID))
+	}
+	where := "WHERE " + strings.Join(conds, " AND ")
+
+	tiers := `
+		SELECT min, max, timestamp, 1 AS count, station_id FROM temperatures
+		` + where + ` AND NOT flagged
+		UNION ALL
+		SELECT avg_min, avg_max, timestamp, count, station_id FROM temperatures_hourly
+		` + where + `
+		UNION ALL
+		SELECT avg_min, avg_max, timestamp, count, station_id FROM temperatures_daily
+		` + where
+
+	columns, join := "t.min, t.max, t.timestamp, t.count", ""
+	if filter.Weighted {
+		columns += ", COALESCE(s.weight, " + q.arg(DefaultStationWeight) + ")"
+		join = "LEFT JOIN stations s ON s.ID = t.station_id"
+	}
+
+	sqlStmt := `
+	SELECT ` + columns + ` FROM (` + tiers + `
+	) AS t
+	` + join
+	rows, err := fm.DB.Query(sqlStmt, q.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
 	for rows.Next() {
 		var temp Temperature
-		if err := rows.Scan(&temp.Min, &temp.Max); err != nil {
-			log.Println(err)
-			continue
+		var count int64
+		weight := DefaultStationWeight
+		dest := []interface{}{&temp.Min, &temp.Max, &temp.Timestamp, &count}
+		if filter.Weighted {
+			dest = append(dest, &weight)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, err
 		}
 
-		mins = append(mins, temp.Min)
-		maxs = append(maxs, temp.Max)
+		date := time.Unix(temp.Timestamp, 0).In(loc).Format(dateLayout)
+		if fs, ok := byDate[date]; ok {
+			fs.mins = append(fs.mins, temp.Min)
+			fs.maxs = append(fs.maxs, temp.Max)
+			fs.weights = append(fs.weights, weight*float64(count))
+			fs.count += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
 
-		sample++
+	for i, forecast := range forecasts {
+		fs := samples[i]
+		if len(fs.mins) == 0 {
+			continue
+		}
 
+		forecast.Sample = fs.count
+		forecast.Min = weightedMean(fs.mins, fs.weights)
+		forecast.Max = weightedMean(fs.maxs, fs.weights)
 	}
 
-	if sample == 0 {
-		return forecast, nil
-	}
+	return forecasts, samples, nil
+}
+
+// forecastSample collects the temperatures recorded on a single day, or the averages of those
+// rolled up, along with their weights and the number of temperatures they amount to
+type forecastSample struct {
+	mins    []float64
+	maxs    []float64
+	weights []float64
+	count   int64
+}
 
-	forecast.Sample = sample
-	forecast.Min = sum(mins) / int64(len(mins))
-	forecast.Min = sum(maxs) / int64(len(maxs))
+// location returns the time zone of a city
+func (fm *ForecastManager) location(cid int64) (*time.Location, error) {
+	sqlStmt := `
+	SELECT time_zone FROM cities
+	WHERE ID = $1 AND deleted_at IS NULL
+	`
+
+	var tz string
+	if err := fm.DB.QueryRow(sqlStmt, cid).Scan(&tz); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
 
-	return forecast, nil
+	return time.LoadLocation(tz)
 }
 
-func sum(temps []int64) int64 {
-	var total int64
-	for _, temp := range temps {
-		total = total + temp
+// weightedMean returns the average of the given temperatures, each counting as much as its weight.
+// The temperatures must not be empty.
+func weightedMean(temps, weights []float64) float64 {
+	var total, weight float64
+	for i, temp := range temps {
+		total += temp * weights[i]
+		weight += weights[i]
 	}
 
-	return total
+	return total / weight
 }
 
 // NewForecastManager returns a new ForecastManager
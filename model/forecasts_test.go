@@ -3,6 +3,7 @@ package model
 import (
 	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/require"
@@ -13,12 +14,26 @@ func Test_CanGetForecast(t *testing.T) {
 		r := require.New(t)
 
 		fm := NewForecastManager(db)
-		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id"}
-		mock.ExpectQuery("SELECT *").WithArgs(1).WillReturnRows(sqlmock.NewRows(expectedRows))
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("Europe/Berlin"),
+		)
 
-		fc, err := fm.Get(1)
+		expectedRows := []string{"min", "max", "timestamp", "count"}
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").
+			WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(10, 20, time.Now().Unix(), 1).
+					AddRow(14, 26, time.Now().Unix(), 1),
+			)
+
+		fc, err := fm.Get(1, nil)
 		r.NoError(err)
 		r.NotNil(fc)
+		r.Equal(int64(2), fc.Sample)
+		r.Equal(12.0, fc.Min)
+		r.Equal(23.0, fc.Max)
+		r.Equal("Europe/Berlin", fc.TimeZone)
 	}, t)
 }
 
@@ -27,25 +42,230 @@ func Test_CannotGetForecastWithNonExistentCity(t *testing.T) {
 		r := require.New(t)
 
 		fm := NewForecastManager(db)
-		mock.ExpectQuery("SELECT *").WillReturnError(ErrNotFound)
+		mock.ExpectQuery("SELECT time_zone FROM cities").WillReturnError(sql.ErrNoRows)
 
-		fc, err := fm.Get(1)
+		fc, err := fm.Get(1, nil)
 		r.Nil(fc)
 		r.Error(err)
 		r.Equal(err, ErrNotFound)
 	}, t)
 }
 
+func Test_CannotGetForecastFromUnreadableTemperatures(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		fm := NewForecastManager(db)
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("UTC"),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").WillReturnRows(
+			sqlmock.NewRows([]string{"min", "max", "timestamp", "count"}).
+				AddRow(10, 20, time.Now().Unix(), 1).
+				AddRow("warm", 20, time.Now().Unix(), 1),
+		)
+
+		fc, err := fm.Get(1, nil)
+		r.Error(err)
+		r.Nil(fc)
+	}, t)
+}
+
 func Test_GetForecastWithNoTemperaturesForCityReturnsZeroedValues(t *testing.T) {
 	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
 		r := require.New(t)
 
-		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id"}
-		mock.ExpectQuery("SELECT *").WithArgs(1).WillReturnRows(sqlmock.NewRows(expectedRows))
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("UTC"),
+		)
+
+		expectedRows := []string{"min", "max", "timestamp", "count"}
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows(expectedRows))
 
 		fm := NewForecastManager(db)
-		fc, err := fm.Get(1)
+		fc, err := fm.Get(1, nil)
 		r.NoError(err)
 		r.NotNil(fc)
+		r.Zero(fc.Sample)
+	}, t)
+}
+
+func Test_CanGetDailyForecastsSplitAtLocalMidnight(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		loc, err := time.LoadLocation("America/Chicago")
+		r.NoError(err)
+
+		now := time.Now().In(loc)
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("America/Chicago"),
+		)
+
+		expectedRows := []string{"min", "max", "timestamp", "count"}
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").
+			WithArgs(1, today.AddDate(0, 0, -1).Unix(), today.AddDate(0, 0, 1).Unix()).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(10, 20, today.Add(-time.Minute).Unix(), 1).
+					AddRow(14, 26, today.Add(time.Minute).Unix(), 1),
+			)
+
+		fm := NewForecastManager(db)
+		fcs, err := fm.Daily(1, 2, nil)
+		r.NoError(err)
+		r.Len(fcs, 2)
+		r.Equal(today.AddDate(0, 0, -1).Format("2006-01-02"), fcs[0].Date)
+		r.Equal(20.0, fcs[0].Max)
+		r.Equal(today.Format("2006-01-02"), fcs[1].Date)
+		r.Equal(26.0, fcs[1].Max)
+	}, t)
+}
+
+func Test_CanGetRegionForecastAcrossMemberCities(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		fm := NewForecastManager(db)
+		mock.ExpectQuery("SELECT c.ID, c.time_zone FROM regions").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "time_zone"}).
+				AddRow(1, "Europe/Berlin").
+				AddRow(2, "Europe/Vienna"),
+		)
+
+		expectedRows := []string{"min", "max", "timestamp", "count"}
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").
+			WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(10, 20, time.Now().Unix(), 1).
+					AddRow(14, 26, time.Now().Unix(), 1),
+			)
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").
+			WithArgs(2, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(6, 18, time.Now().Unix(), 1),
+			)
+
+		rf, err := fm.Region(1)
+		r.NoError(err)
+		r.Equal(int64(3), rf.Sample)
+		r.Equal(10.0, rf.Min)
+		r.InDelta(21.33, rf.Max, 0.01)
+		r.Len(rf.Cities, 2)
+		r.Equal(12.0, rf.Cities[0].Min)
+		r.Equal("Europe/Vienna", rf.Cities[1].TimeZone)
+	}, t)
+}
+
+func Test_CanGetForecastOfRegionWithoutCities(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		fm := NewForecastManager(db)
+		mock.ExpectQuery("SELECT c.ID, c.time_zone FROM regions").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "time_zone"}).AddRow(nil, nil),
+		)
+
+		rf, err := fm.Region(1)
+		r.NoError(err)
+		r.Equal(int64(0), rf.Sample)
+		r.Empty(rf.Cities)
+	}, t)
+}
+
+func Test_CannotGetForecastOfNonExistentRegion(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		fm := NewForecastManager(db)
+		mock.ExpectQuery("SELECT c.ID, c.time_zone FROM regions").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "time_zone"}),
+		)
+
+		rf, err := fm.Region(1)
+		r.Nil(rf)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
+
+func Test_CanGetForecastOfCitiesMatchingSelector(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		sel, err := ParseSelector("tier=gold")
+		r.NoError(err)
+
+		fm := NewForecastManager(db)
+		mock.ExpectQuery(`SELECT ID, time_zone FROM cities WHERE deleted_at IS NULL AND labels @> \$1::jsonb`).
+			WithArgs(`{"tier":"gold"}`, MaxSelectedCities+1).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"ID", "time_zone"}).
+					AddRow(1, "Europe/Berlin").
+					AddRow(2, "Europe/Vienna"),
+			)
+
+		expectedRows := []string{"min", "max", "timestamp", "count"}
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").
+			WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(expectedRows).AddRow(10, 20, time.Now().Unix(), 1))
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").
+			WithArgs(2, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(expectedRows).AddRow(20, 30, time.Now().Unix(), 1))
+
+		sf, err := fm.Select(sel)
+		r.NoError(err)
+		r.Equal("tier=gold", sf.Selector)
+		r.Equal(int64(2), sf.Sample)
+		r.Equal(15.0, sf.Min)
+		r.Equal(25.0, sf.Max)
+		r.Len(sf.Cities, 2)
+	}, t)
+}
+
+func Test_CannotGetForecastOfTooManyCitiesMatchingSelector(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		rows := sqlmock.NewRows([]string{"ID", "time_zone"})
+		for i := 1; i <= MaxSelectedCities+1; i++ {
+			rows.AddRow(i, "UTC")
+		}
+
+		fm := NewForecastManager(db)
+		mock.ExpectQuery("SELECT ID, time_zone FROM cities").WillReturnRows(rows)
+
+		sf, err := fm.Select(nil)
+		r.Nil(sf)
+		r.Equal(ErrTooManyCities, err)
+	}, t)
+}
+
+func Test_CanGetDailyForecastFromRolledUpTemperatures(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		fm := NewForecastManager(db)
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("UTC"),
+		)
+
+		// an hourly aggregate of three temperatures counts three times as much as a temperature
+		mock.ExpectQuery("SELECT (.+) FROM temperatures (.+) UNION ALL (.+) FROM temperatures_hourly (.+) UNION ALL (.+) FROM temperatures_daily").
+			WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"min", "max", "timestamp", "count"}).
+					AddRow(10, 20, time.Now().Unix(), 3).
+					AddRow(14, 24, time.Now().Unix(), 1),
+			)
+
+		fc, err := fm.Get(1, nil)
+		r.NoError(err)
+		r.Equal(int64(4), fc.Sample)
+		r.Equal(11.0, fc.Min)
+		r.Equal(21.0, fc.Max)
 	}, t)
 }
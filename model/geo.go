@@ -0,0 +1,113 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// BoundingBox describes a rectangular area between two longitudes and two latitudes.
+// A box whose MinLon is greater than its MaxLon crosses the antimeridian.
+type BoundingBox struct {
+	MinLon float64
+	MinLat float64
+	MaxLon float64
+	MaxLat float64
+}
+
+// Polygon describes an area as GeoJSON does: a list of linear rings of [longitude, latitude]
+// positions, the first one being the exterior and any further ones holes within it. A ring with
+// an edge spanning more than 180 degrees of longitude crosses the antimeridian along it.
+type Polygon [][][2]float64
+
+// CrossesAntimeridian reports whether an edge of the exterior of the polygon crosses the antimeridian
+func (p Polygon) CrossesAntimeridian() bool {
+	if len(p) == 0 {
+		return false
+	}
+
+	ring := p[0]
+	for i := 1; i < len(ring); i++ {
+		if d := ring[i][0] - ring[i-1][0]; d > 180 || d < -180 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unwrapped returns the polygon with the western longitudes of a polygon crossing the
+// antimeridian shifted by 360 degrees, so that its edges no longer wrap around
+func (p Polygon) unwrapped() Polygon {
+	if !p.CrossesAntimeridian() {
+		return p
+	}
+
+	u := make(Polygon, 0, len(p))
+	for _, ring := range p {
+		r := make([][2]float64, 0, len(ring))
+		for _, pos := range ring {
+			r = append(r, [2]float64{unwrapLongitude(pos[0]), pos[1]})
+		}
+		u = append(u, r)
+	}
+
+	return u
+}
+
+// Bounds returns the smallest bounding box enclosing the exterior of the polygon, whose MinLon
+// is greater than its MaxLon if the polygon crosses the antimeridian
+func (p Polygon) Bounds() *BoundingBox {
+	if len(p) == 0 || len(p[0]) == 0 {
+		return nil
+	}
+
+	ring := p.unwrapped()[0]
+	bb := &BoundingBox{
+		MinLon: ring[0][0],
+		MinLat: ring[0][1],
+		MaxLon: ring[0][0],
+		MaxLat: ring[0][1],
+	}
+	for _, pos := range ring[1:] {
+		if pos[0] < bb.MinLon {
+			bb.MinLon = pos[0]
+		}
+		if pos[0] > bb.MaxLon {
+			bb.MaxLon = pos[0]
+		}
+		if pos[1] < bb.MinLat {
+			bb.MinLat = pos[1]
+		}
+		if pos[1] > bb.MaxLat {
+			bb.MaxLat = pos[1]
+		}
+	}
+
+	if bb.MinLon > 180 {
+		bb.MinLon -= 360
+	}
+	if bb.MaxLon > 180 {
+		bb.MaxLon -= 360
+	}
+
+	return bb
+}
+
+// unwrapLongitude shifts a western longitude east of the antimeridian
+func unwrapLongitude(lon float64) float64 {
+	if lon < 0 {
+		return lon + 360
+	}
+
+	return lon
+}
+
+// ringLiteral returns a linear ring as the text of a postgres polygon, e.g. "((0,0),(10,0),(0,10))"
+func ringLiteral(ring [][2]float64) string {
+	points := make([]string, 0, len(ring))
+	for _, pos := range ring {
+		points = append(points, "("+strconv.FormatFloat(pos[0], 'f', -1, 64)+","+strconv.FormatFloat(pos[1], 'f', -1, 64)+")")
+	}
+
+	return "(" + strings.Join(points, ",") + ")"
+}
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PolygonIsBoundedByItsExterior(t *testing.T) {
+	r := require.New(t)
+
+	p := Polygon{
+		{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}},
+		{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}},
+	}
+
+	r.False(p.CrossesAntimeridian())
+	r.Equal(&BoundingBox{MinLon: 0, MinLat: 0, MaxLon: 10, MaxLat: 10}, p.Bounds())
+}
+
+func Test_PolygonCrossingAntimeridianIsBoundedOnBothSides(t *testing.T) {
+	r := require.New(t)
+
+	p := Polygon{{{170, -10}, {-170, -10}, {-170, 10}, {170, 10}, {170, -10}}}
+
+	r.True(p.CrossesAntimeridian())
+	r.Equal(&BoundingBox{MinLon: 170, MinLat: -10, MaxLon: -170, MaxLat: 10}, p.Bounds())
+}
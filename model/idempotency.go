@@ -0,0 +1,142 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// DefaultIdempotencyWindow is how long the response of a request is kept for retries of its
+	// idempotency key unless configured otherwise
+	DefaultIdempotencyWindow = 24 * time.Hour
+	// MaxIdempotencyKeyLength is the maximum number of characters of an idempotency key
+	MaxIdempotencyKeyLength = 255
+	// IdempotencyClaimLease is how long a key is held for the request it was claimed for before a
+	// retry of that request may take it over, in case the request never completed
+	IdempotencyClaimLease = time.Minute
+)
+
+// IdempotentResponse describes the response stored for the request an idempotency key was first
+// used with, which is replayed to every retry of that request. Header holds the values of the
+// headers of the response by canonical name.
+type IdempotentResponse struct {
+	Status int
+	Header map[string][]string
+	Body   []byte
+}
+
+// IdempotencyManager describes an idempotency key model manager
+type IdempotencyManager struct {
+	db *sql.DB
+}
+
+// Claim claims an idempotency key for a request identified by its fingerprint. If the key is new,
+// or its previous use is older than window, the key is claimed and nil is returned without an
+// error; the request is then to be made and its response stored with Complete, or the claim
+// given up with Release. If the key was used for the same request before, its stored response is
+// returned. ErrIdempotencyKeyReused is returned if the key was used for a different request, and
+// ErrIdempotencyKeyInProgress if the request it was claimed for has not completed yet. A claim
+// older than IdempotencyClaimLease is taken over by a retry of the same request, so that a key
+// is not held for the whole window by a request that died before completing.
+func (im *IdempotencyManager) Claim(key, fingerprint string, window time.Duration) (*IdempotentResponse, error) {
+	// an expired key is claimed anew, as if it had never been used
+	sqlStmt := `
+	INSERT INTO idempotency_keys (key, fingerprint)
+	VALUES ($1, $2)
+	ON CONFLICT (key) DO UPDATE
+	SET fingerprint = EXCLUDED.fingerprint, status = NULL, headers = '{}', body = NULL, created_at = now(), claimed_at = now()
+	WHERE idempotency_keys.created_at < now() - $3::float8 * interval '1 second'
+	OR (idempotency_keys.status IS NULL AND idempotency_keys.fingerprint = EXCLUDED.fingerprint
+		AND idempotency_keys.claimed_at < now() - $4::float8 * interval '1 second')
+	RETURNING key;
+	`
+
+	var claimed string
+	err := im.db.QueryRow(sqlStmt, key, fingerprint, window.Seconds(), IdempotencyClaimLease.Seconds()).Scan(&claimed)
+	if err == nil {
+		return nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	sqlStmt = `
+	SELECT fingerprint, status, headers, body FROM idempotency_keys
+	WHERE key = $1;
+	`
+
+	var stored string
+	var status sql.NullInt64
+	var header []byte
+	var resp IdempotentResponse
+	if err := im.db.QueryRow(sqlStmt, key).Scan(&stored, &status, &header, &resp.Body); err != nil {
+		if err == sql.ErrNoRows {
+			// the key was released by a failed request in the meantime
+			return nil, ErrIdempotencyKeyInProgress
+		}
+		return nil, err
+	}
+
+	if stored != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+
+	if !status.Valid {
+		return nil, ErrIdempotencyKeyInProgress
+	}
+
+	if err := json.Unmarshal(header, &resp.Header); err != nil {
+		return nil, err
+	}
+
+	resp.Status = int(status.Int64)
+	return &resp, nil
+}
+
+// Complete stores the response of the request a key was claimed for
+func (im *IdempotencyManager) Complete(key string, resp *IdempotentResponse) error {
+	sqlStmt := `
+	UPDATE idempotency_keys
+	SET status = $2, headers = $3, body = $4
+	WHERE key = $1;
+	`
+
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+
+	res, err := im.db.Exec(sqlStmt, key, resp.Status, header, resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res)
+}
+
+// Release gives up the claim of a key whose request failed, so that it can be retried
+func (im *IdempotencyManager) Release(key string) error {
+	_, err := im.db.Exec(`DELETE FROM idempotency_keys WHERE key = $1 AND status IS NULL;`, key)
+	return err
+}
+
+// Purge deletes the keys used longer than window ago, returning how many were deleted
+func (im *IdempotencyManager) Purge(window time.Duration) (int64, error) {
+	sqlStmt := `
+	DELETE FROM idempotency_keys
+	WHERE created_at < now() - $1::float8 * interval '1 second';
+	`
+
+	res, err := im.db.Exec(sqlStmt, window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// NewIdempotencyManager returns a new IdempotencyManager
+func NewIdempotencyManager(db *sql.DB) *IdempotencyManager {
+	return &IdempotencyManager{db}
+}
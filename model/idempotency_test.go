@@ -0,0 +1,95 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CanClaimNewIdempotencyKey(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		im := NewIdempotencyManager(db)
+		mock.ExpectQuery("INSERT INTO idempotency_keys").
+			WithArgs("key-1", "fingerprint", time.Hour.Seconds(), IdempotencyClaimLease.Seconds()).
+			WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("key-1"))
+
+		resp, err := im.Claim("key-1", "fingerprint", time.Hour)
+		r.NoError(err)
+		r.Nil(resp)
+	}, t)
+}
+
+func Test_CanTakeOverStaleClaimOfIdempotencyKey(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		im := NewIdempotencyManager(db)
+		mock.ExpectQuery(`ON CONFLICT \(key\) DO UPDATE SET (.+) claimed_at = now\(\) WHERE (.+) OR \(idempotency_keys.status IS NULL AND idempotency_keys.fingerprint = EXCLUDED.fingerprint AND idempotency_keys.claimed_at < now\(\) - \$4`).
+			WithArgs("key-1", "fingerprint", time.Hour.Seconds(), IdempotencyClaimLease.Seconds()).
+			WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("key-1"))
+
+		resp, err := im.Claim("key-1", "fingerprint", time.Hour)
+		r.NoError(err)
+		r.Nil(resp)
+	}, t)
+}
+
+func Test_CanReplayResponseOfUsedIdempotencyKey(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		im := NewIdempotencyManager(db)
+		mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnRows(sqlmock.NewRows([]string{"key"}))
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_keys").WithArgs("key-1").WillReturnRows(
+			sqlmock.NewRows([]string{"fingerprint", "status", "headers", "body"}).
+				AddRow("fingerprint", 201, []byte(`{"Content-Type":["application/json"],"Etag":["\"v1\""]}`), []byte(`{"id":1}`)),
+		)
+
+		resp, err := im.Claim("key-1", "fingerprint", time.Hour)
+		r.NoError(err)
+		r.Equal(&IdempotentResponse{
+			Status: 201,
+			Header: map[string][]string{"Content-Type": {"application/json"}, "Etag": {`"v1"`}},
+			Body:   []byte(`{"id":1}`),
+		}, resp)
+	}, t)
+}
+
+func Test_CannotReuseIdempotencyKeyForDifferentRequest(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		im := NewIdempotencyManager(db)
+		mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnRows(sqlmock.NewRows([]string{"key"}))
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_keys").WillReturnRows(
+			sqlmock.NewRows([]string{"fingerprint", "status", "headers", "body"}).
+				AddRow("other-fingerprint", 201, []byte(`{"Content-Type":["application/json"]}`), []byte(`{"id":1}`)),
+		)
+
+		resp, err := im.Claim("key-1", "fingerprint", time.Hour)
+		r.Nil(resp)
+		r.Equal(ErrIdempotencyKeyReused, err)
+	}, t)
+}
+
+func Test_CannotClaimIdempotencyKeyInProgress(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		im := NewIdempotencyManager(db)
+		mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnRows(sqlmock.NewRows([]string{"key"}))
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_keys").WillReturnRows(
+			sqlmock.NewRows([]string{"fingerprint", "status", "headers", "body"}).
+				AddRow("fingerprint", nil, []byte(`{}`), nil),
+		)
+
+		resp, err := im.Claim("key-1", "fingerprint", time.Hour)
+		r.Nil(resp)
+		r.Equal(ErrIdempotencyKeyInProgress, err)
+	}, t)
+}
@@ -0,0 +1,338 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// MaxLabelNameLength is the maximum number of characters of the name of a label key, or of a label value
+	MaxLabelNameLength = 63
+	// MaxLabelPrefixLength is the maximum number of characters of the optional DNS prefix of a label key
+	MaxLabelPrefixLength = 253
+	// MaxLabels is the maximum number of labels of a single city
+	MaxLabels = 64
+)
+
+var (
+	// labelNamePattern matches the name of a label key, and any non-empty label value
+	labelNamePattern = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	// labelPrefixPattern matches the DNS subdomain a label key may be prefixed with
+	labelPrefixPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// Labels describes the key/value labels of a city, e.g. customer=acme or tier=gold. Keys and
+// values follow the syntax of Kubernetes labels.
+type Labels map[string]string
+
+// Scan reads labels stored as a JSON object
+func (l *Labels) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*l = Labels{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into labels", src)
+	}
+
+	labels := Labels{}
+	if err := json.Unmarshal(b, &labels); err != nil {
+		return err
+	}
+
+	*l = labels
+	return nil
+}
+
+// Value stores labels as a JSON object
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+
+	b, err := json.Marshal(map[string]string(l))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// String formats the labels as key=value pairs ordered by key, the same as they are parsed
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+l[k])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// ParseLabels parses comma separated key=value pairs, e.g. "customer=acme,tier=gold"
+func ParseLabels(s string) (Labels, error) {
+	labels := Labels{}
+	if strings.TrimSpace(s) == "" {
+		return labels, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("label %q is not a key=value pair", strings.TrimSpace(pair))
+		}
+
+		key := strings.TrimSpace(kv[0])
+		if _, ok := labels[key]; ok {
+			return nil, fmt.Errorf("label %q is given more than once", key)
+		}
+		labels[key] = strings.TrimSpace(kv[1])
+	}
+
+	return labels, nil
+}
+
+// validateLabels records every invalid key and value of the labels under field
+func validateLabels(ve *ValidationError, field string, labels Labels) {
+	if len(labels) > MaxLabels {
+		ve.Add(field, "must not hold more than %d labels", MaxLabels)
+		return
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := validateLabelKey(k); err != nil {
+			ve.Add(field, "%v", err)
+			continue
+		}
+		if err := validateLabelValue(labels[k]); err != nil {
+			ve.Add(field, "%v of %q", err, k)
+		}
+	}
+}
+
+// validateLabelKey checks that a label key is a name, optionally prefixed by a DNS subdomain and a slash
+func validateLabelKey(key string) error {
+	name := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		prefix := key[:i]
+		name = key[i+1:]
+		if len(prefix) > MaxLabelPrefixLength || !labelPrefixPattern.MatchString(prefix) {
+			return fmt.Errorf("label key %q must have a DNS subdomain as its prefix", key)
+		}
+	}
+
+	if len(name) > MaxLabelNameLength || !labelNamePattern.MatchString(name) {
+		return fmt.Errorf("label key %q must be at most %d alphanumeric characters, '-', '_' or '.', starting and ending alphanumerically", key, MaxLabelNameLength)
+	}
+
+	return nil
+}
+
+// validateLabelValue checks that a label value is either empty or a name
+func validateLabelValue(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	if len(value) > MaxLabelNameLength || !labelNamePattern.MatchString(value) {
+		return fmt.Errorf("label value %q must be at most %d alphanumeric characters, '-', '_' or '.', starting and ending alphanumerically", value, MaxLabelNameLength)
+	}
+
+	return nil
+}
+
+// SelectorOperator describes how a selector requirement compares the value of a label
+type SelectorOperator string
+
+const (
+	// Equals requires a label to have the value
+	Equals SelectorOperator = "="
+	// NotEquals requires a label to be missing or to have another value
+	NotEquals SelectorOperator = "!="
+	// In requires a label to have one of the values
+	In SelectorOperator = "in"
+	// NotIn requires a label to be missing or to have none of the values
+	NotIn SelectorOperator = "notin"
+	// Exists requires a label to be present, whatever its value
+	Exists SelectorOperator = "exists"
+	// DoesNotExist requires a label to be missing
+	DoesNotExist SelectorOperator = "!"
+)
+
+// Requirement describes a single condition of a selector on the label Key
+type Requirement struct {
+	Key      string           `json:"key"`
+	Operator SelectorOperator `json:"operator"`
+	Values   []string         `json:"values,omitempty"`
+}
+
+// Selector describes Kubernetes-style label requirements that all have to hold, e.g.
+// "env=prod,tier!=free,region in (eu,us),!legacy". An empty selector selects everything.
+type Selector []*Requirement
+
+// ParseSelector parses a label selector. Supported are key=value (or key==value), key!=value,
+// key in (v1,v2), key notin (v1,v2), key and !key, separated by commas.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range splitSelector(s) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			return nil, fmt.Errorf("selector %q has an empty requirement", s)
+		}
+
+		req, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := validateLabelKey(req.Key); err != nil {
+			return nil, err
+		}
+		for _, v := range req.Values {
+			if err := validateLabelValue(v); err != nil {
+				return nil, err
+			}
+		}
+
+		sel = append(sel, req)
+	}
+
+	return sel, nil
+}
+
+// splitSelector splits a selector into its requirements at the commas outside of parentheses
+func splitSelector(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+
+	var terms []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+
+	return append(terms, s[start:])
+}
+
+func parseRequirement(term string) (*Requirement, error) {
+	if strings.HasPrefix(term, "!") && !strings.ContainsAny(term, "=()") {
+		return &Requirement{Key: strings.TrimSpace(term[1:]), Operator: DoesNotExist}, nil
+	}
+
+	for _, op := range []string{"!=", "==", "="} {
+		if i := strings.Index(term, op); i >= 0 {
+			operator := Equals
+			if op == "!=" {
+				operator = NotEquals
+			}
+			return &Requirement{
+				Key:      strings.TrimSpace(term[:i]),
+				Operator: operator,
+				Values:   []string{strings.TrimSpace(term[i+len(op):])},
+			}, nil
+		}
+	}
+
+	if i := strings.Index(term, "("); i >= 0 {
+		fields := strings.Fields(term[:i])
+		if len(fields) != 2 || (fields[1] != string(In) && fields[1] != string(NotIn)) || !strings.HasSuffix(term, ")") {
+			return nil, fmt.Errorf("selector requirement %q must be of the form key in (v1,v2) or key notin (v1,v2)", term)
+		}
+
+		var values []string
+		for _, v := range strings.Split(term[i+1:len(term)-1], ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+
+		return &Requirement{Key: fields[0], Operator: SelectorOperator(fields[1]), Values: values}, nil
+	}
+
+	if strings.ContainsAny(term, " \t!") {
+		return nil, fmt.Errorf("selector requirement %q is not understood", term)
+	}
+
+	return &Requirement{Key: term, Operator: Exists}, nil
+}
+
+// Value stores a selector as a JSON array of its requirements, which webhooks are matched by
+func (sel Selector) Value() (driver.Value, error) {
+	b, err := json.Marshal([]*Requirement(sel))
+	if err != nil {
+		return nil, err
+	}
+
+	return string(b), nil
+}
+
+// String formats the selector the same as it is parsed
+func (sel Selector) String() string {
+	terms := make([]string, 0, len(sel))
+	for _, req := range sel {
+		switch req.Operator {
+		case Equals, NotEquals:
+			terms = append(terms, req.Key+string(req.Operator)+req.Values[0])
+		case In, NotIn:
+			terms = append(terms, req.Key+" "+string(req.Operator)+" ("+strings.Join(req.Values, ",")+")")
+		case Exists:
+			terms = append(terms, req.Key)
+		case DoesNotExist:
+			terms = append(terms, "!"+req.Key)
+		}
+	}
+
+	return strings.Join(terms, ",")
+}
+
+// where adds the requirements of the selector on the labels column to the conditions of a query
+func (sel Selector) where(q *query, column string) {
+	for _, req := range sel {
+		switch req.Operator {
+		case Equals:
+			q.where(column + " @> " + q.arg(Labels{req.Key: req.Values[0]}) + "::jsonb")
+		case NotEquals:
+			q.where("NOT " + column + " @> " + q.arg(Labels{req.Key: req.Values[0]}) + "::jsonb")
+		case In:
+			q.where(column + " ->> " + q.arg(req.Key) + " = ANY(" + q.arg(pq.StringArray(req.Values)) + ")")
+		case NotIn:
+			key := q.arg(req.Key)
+			q.where("(" + column + " ->> " + key + " IS NULL OR " + column + " ->> " + key + " <> ALL(" + q.arg(pq.StringArray(req.Values)) + "))")
+		case Exists:
+			q.where(column + " ? " + q.arg(req.Key))
+		case DoesNotExist:
+			q.where("NOT " + column + " ? " + q.arg(req.Key))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CanParseLabels(t *testing.T) {
+	r := require.New(t)
+
+	labels, err := ParseLabels("tier=gold, customer=acme,legacy=")
+	r.NoError(err)
+	r.Equal(Labels{"tier": "gold", "customer": "acme", "legacy": ""}, labels)
+	r.Equal("customer=acme,legacy=,tier=gold", labels.String())
+
+	_, err = ParseLabels("tier")
+	r.Error(err)
+
+	_, err = ParseLabels("tier=gold,tier=free")
+	r.Error(err)
+}
+
+func Test_CanParseSelector(t *testing.T) {
+	r := require.New(t)
+
+	sel, err := ParseSelector("env=prod, tier!=free,region in (eu, us),example.com/legacy notin (yes),beta,!deprecated")
+	r.NoError(err)
+	r.Equal(Selector{
+		{Key: "env", Operator: Equals, Values: []string{"prod"}},
+		{Key: "tier", Operator: NotEquals, Values: []string{"free"}},
+		{Key: "region", Operator: In, Values: []string{"eu", "us"}},
+		{Key: "example.com/legacy", Operator: NotIn, Values: []string{"yes"}},
+		{Key: "beta", Operator: Exists},
+		{Key: "deprecated", Operator: DoesNotExist},
+	}, sel)
+	r.Equal("env=prod,tier!=free,region in (eu,us),example.com/legacy notin (yes),beta,!deprecated", sel.String())
+
+	sel, err = ParseSelector("")
+	r.NoError(err)
+	r.Empty(sel)
+
+	for _, s := range []string{"env=prod,", "region in eu", "tier = not valid", "-env=prod", "env foo"} {
+		_, err := ParseSelector(s)
+		r.Error(err, s)
+	}
+}
+
+func Test_SelectorBuildsConditionsOnLabels(t *testing.T) {
+	r := require.New(t)
+
+	for s, want := range map[string]struct {
+		where string
+		args  []interface{}
+	}{
+		"":                  {"", nil},
+		"env=prod":          {"WHERE labels @> $1::jsonb", []interface{}{Labels{"env": "prod"}}},
+		"env==prod":         {"WHERE labels @> $1::jsonb", []interface{}{Labels{"env": "prod"}}},
+		"tier!=free":        {"WHERE NOT labels @> $1::jsonb", []interface{}{Labels{"tier": "free"}}},
+		"region in (eu,us)": {"WHERE labels ->> $1 = ANY($2)", []interface{}{"region", pq.StringArray{"eu", "us"}}},
+		"region notin (eu)": {"WHERE (labels ->> $1 IS NULL OR labels ->> $1 <> ALL($2))", []interface{}{"region", pq.StringArray{"eu"}}},
+		"env,!tier":         {"WHERE labels ? $1 AND NOT labels ? $2", []interface{}{"env", "tier"}},
+	} {
+		sel, err := ParseSelector(s)
+		r.NoError(err, s)
+
+		q := &query{}
+		sel.where(q, "labels")
+		r.Equal(want.where, q.whereClause(), s)
+		r.Equal(want.args, q.args, s)
+	}
+}
+
+func Test_CanListCitiesBySelector(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		sel, err := ParseSelector("env=prod,region in (eu,us),!legacy")
+		r.NoError(err)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery(`WHERE deleted_at IS NULL AND labels @> \$1::jsonb AND labels ->> \$2 = ANY\(\$3\) AND NOT labels \? \$4`).
+			WithArgs(`{"env":"prod"}`, "region", pq.StringArray{"eu", "us"}, "legacy", sqlmock.AnyArg()).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.405, "version", "DE", "Berlin", "Europe/Berlin", `{"env":"prod","region":"eu"}`),
+			)
+
+		page, err := NewCityManager(db).List(&CityQuery{Selector: sel})
+		r.NoError(err)
+		r.Len(page.Cities, 1)
+		r.Equal(Labels{"env": "prod", "region": "eu"}, page.Cities[0].Labels)
+	}, t)
+}
+
+func Test_CannotCreateCityWithInvalidLabels(t *testing.T) {
+	r := require.New(t)
+
+	err := (&NewCity{Name: "Berlin", Latitude: 52.52, Longitude: 13.405, Labels: Labels{"-env": "prod", "tier": "gold!"}}).Validate()
+	r.Error(err)
+
+	ve := err.(*ValidationError)
+	r.Len(ve.Errors, 2)
+	r.True(ve.Has("labels"))
+}
@@ -0,0 +1,200 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Metric describes a quantity observed by weather stations besides temperatures
+type Metric string
+
+const (
+	// Humidity is the relative humidity of the air, in percent
+	Humidity Metric = "humidity"
+	// Pressure is the atmospheric pressure, in hectopascal
+	Pressure Metric = "pressure"
+	// WindSpeed is the speed of the wind, in metres per second
+	WindSpeed Metric = "wind_speed"
+	// WindDirection is the direction the wind blows from, in degrees clockwise from north
+	WindDirection Metric = "wind_direction"
+	// Precipitation is the amount of rain or melted snow fallen since the previous observation, in millimetres
+	Precipitation Metric = "precipitation"
+)
+
+// MetricUnit describes the unit an observation of a metric is given in. Observations are stored in
+// the base unit of their metric.
+type MetricUnit string
+
+const (
+	// Percent is the base unit of humidity
+	Percent MetricUnit = "percent"
+	// Hectopascal is the base unit of pressure
+	Hectopascal MetricUnit = "hpa"
+	// Kilopascal is a unit of pressure of 10 hectopascal
+	Kilopascal MetricUnit = "kpa"
+	// InchOfMercury is a unit of pressure used in aviation and the United States
+	InchOfMercury MetricUnit = "inhg"
+	// MetrePerSecond is the base unit of wind speed
+	MetrePerSecond MetricUnit = "m/s"
+	// KilometrePerHour is a unit of wind speed
+	KilometrePerHour MetricUnit = "km/h"
+	// MilePerHour is a unit of wind speed used in the United States and the United Kingdom
+	MilePerHour MetricUnit = "mph"
+	// Knot is a unit of wind speed used at sea and in aviation
+	Knot MetricUnit = "kn"
+	// Degree is the base unit of wind direction
+	Degree MetricUnit = "degrees"
+	// Millimetre is the base unit of precipitation
+	Millimetre MetricUnit = "mm"
+	// Inch is a unit of precipitation used in the United States
+	Inch MetricUnit = "in"
+)
+
+// ObservationDecimals is the number of decimals observations are stored with in the base unit of their metric
+const ObservationDecimals = 2
+
+// metricSpec describes the units a metric may be given in, the first being its base unit, and the
+// range of values in the base unit it may take. Observations of a cumulative metric are totalled
+// by forecasts, and the average of a circular metric is the mean of its angles.
+type metricSpec struct {
+	units      []MetricUnit
+	min        float64
+	max        float64
+	maxOpen    bool
+	cumulative bool
+	circular   bool
+}
+
+// metricSpecs holds the spec of every supported metric
+var metricSpecs = map[Metric]*metricSpec{
+	Humidity:      {units: []MetricUnit{Percent}, min: 0, max: 100},
+	Pressure:      {units: []MetricUnit{Hectopascal, Kilopascal, InchOfMercury}, min: 0, max: 2000},
+	WindSpeed:     {units: []MetricUnit{MetrePerSecond, KilometrePerHour, MilePerHour, Knot}, min: 0, max: 200},
+	WindDirection: {units: []MetricUnit{Degree}, min: 0, max: 360, maxOpen: true, circular: true},
+	Precipitation: {units: []MetricUnit{Millimetre, Inch}, min: 0, max: 2000, cumulative: true},
+}
+
+// Metrics lists every supported metric in the order forecasts present them in
+var Metrics = []Metric{Humidity, Pressure, WindSpeed, WindDirection, Precipitation}
+
+// unitScales holds how many of the base unit of its metric a unit amounts to
+var unitScales = map[MetricUnit]float64{
+	Percent:          1,
+	Hectopascal:      1,
+	Kilopascal:       10,
+	InchOfMercury:    33.8639,
+	MetrePerSecond:   1,
+	KilometrePerHour: 1 / 3.6,
+	MilePerHour:      0.44704,
+	Knot:             1852.0 / 3600,
+	Degree:           1,
+	Millimetre:       1,
+	Inch:             25.4,
+}
+
+// ParseMetric parses the name of a metric, e.g. "wind_speed"
+func ParseMetric(s string) (Metric, error) {
+	m := Metric(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := metricSpecs[m]; !ok {
+		return "", fmt.Errorf("metric must be one of %s, got %q", metricNames(), s)
+	}
+
+	return m, nil
+}
+
+// ParseUnit parses the unit a value of the metric is given in. An empty string is taken to be the
+// base unit of the metric.
+func (m Metric) ParseUnit(s string) (MetricUnit, error) {
+	spec, ok := metricSpecs[m]
+	if !ok {
+		return "", fmt.Errorf("unknown metric %q", m)
+	}
+
+	u := MetricUnit(strings.ToLower(strings.TrimSpace(s)))
+	if u == "" {
+		return m.BaseUnit(), nil
+	}
+	for _, unit := range spec.units {
+		if u == unit {
+			return u, nil
+		}
+	}
+
+	return "", fmt.Errorf("unit of %s must be one of %s, got %q", m, unitNames(spec.units), s)
+}
+
+// BaseUnit returns the unit observations of the metric are stored in, or an empty unit if the
+// metric is not supported
+func (m Metric) BaseUnit() MetricUnit {
+	spec, ok := metricSpecs[m]
+	if !ok {
+		return ""
+	}
+
+	return spec.units[0]
+}
+
+// Cumulative reports whether the observations of the metric add up, like precipitation
+func (m Metric) Cumulative() bool {
+	spec, ok := metricSpecs[m]
+	return ok && spec.cumulative
+}
+
+// ToBase converts a value given in the unit to the base unit of its metric
+func (u MetricUnit) ToBase(v float64) float64 {
+	if scale, ok := unitScales[u]; ok {
+		return v * scale
+	}
+
+	return v
+}
+
+// FromBase converts a value given in the base unit of its metric to the unit
+func (u MetricUnit) FromBase(v float64) float64 {
+	if scale, ok := unitScales[u]; ok {
+		return v / scale
+	}
+
+	return v
+}
+
+// circularMean returns the mean of the given angles in degrees, normalised to [0, 360), which
+// keeps the mean of 350 and 10 at 0 rather than 180. The angles must not be empty.
+func circularMean(degrees []float64) float64 {
+	var sin, cos float64
+	for _, d := range degrees {
+		rad := d * math.Pi / 180
+		sin += math.Sin(rad)
+		cos += math.Cos(rad)
+	}
+
+	mean := math.Atan2(sin, cos) * 180 / math.Pi
+	if mean < 0 {
+		mean += 360
+	}
+	// a mean a rounding error below north is north
+	if 360-mean < 1e-9 {
+		mean = 0
+	}
+
+	return mean
+}
+
+func metricNames() string {
+	names := make([]string, 0, len(Metrics))
+	for _, m := range Metrics {
+		names = append(names, string(m))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func unitNames(units []MetricUnit) string {
+	names := make([]string, 0, len(units))
+	for _, u := range units {
+		names = append(names, string(u))
+	}
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CanParseMetricUnits(t *testing.T) {
+	r := require.New(t)
+
+	m, err := ParseMetric(" Wind_Speed ")
+	r.NoError(err)
+	r.Equal(WindSpeed, m)
+
+	_, err = ParseMetric("visibility")
+	r.Error(err)
+
+	u, err := WindSpeed.ParseUnit("")
+	r.NoError(err)
+	r.Equal(MetrePerSecond, u)
+
+	u, err = WindSpeed.ParseUnit("KM/H")
+	r.NoError(err)
+	r.Equal(KilometrePerHour, u)
+
+	_, err = Humidity.ParseUnit("mm")
+	r.Error(err)
+}
+
+func Test_CanConvertMetricUnits(t *testing.T) {
+	r := require.New(t)
+
+	r.InDelta(10.0, KilometrePerHour.ToBase(36), 1e-9)
+	r.InDelta(36.0, KilometrePerHour.FromBase(10), 1e-9)
+	r.InDelta(1013.25, InchOfMercury.ToBase(29.9213), 0.01)
+	r.Equal(25.4, Inch.ToBase(1))
+	r.Equal(55.0, Percent.FromBase(55))
+}
+
+func Test_CanAverageWindDirections(t *testing.T) {
+	r := require.New(t)
+
+	r.InDelta(0, circularMean([]float64{350, 10}), 1e-9)
+	r.InDelta(90, circularMean([]float64{45, 135}), 1e-9)
+	r.InDelta(270, circularMean([]float64{260, 280}), 1e-9)
+}
@@ -0,0 +1,243 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultObservationLimit is the number of observations returned in a listing if no limit is given
+	DefaultObservationLimit = 100
+	// MaxObservationLimit is the maximum number of observations returned in a single listing
+	MaxObservationLimit = 1000
+)
+
+// observationColumns are the columns selected whenever an observation is read from the database
+const observationColumns = `ID, city_id, metric, value, timestamp, received_at`
+
+// Observation describes the value of a metric observed in a city, in the base unit of the metric
+// with up to ObservationDecimals decimals. Timestamp is the Unix time the value was observed at
+// and ReceivedAt the Unix time it was stored at.
+type Observation struct {
+	ID         int64
+	CityID     int64
+	Metric     Metric
+	Value      float64
+	Timestamp  int64
+	ReceivedAt int64
+}
+
+// NewObservation describes a new observation of a metric to be added for a city. Value is given in
+// Unit, defaulting to the base unit of the metric. ObservedAt is the time the value was observed
+// at, defaulting to the time it is stored at.
+type NewObservation struct {
+	CityID     int64
+	Metric     Metric
+	Value      float64
+	Unit       MetricUnit
+	ObservedAt time.Time
+}
+
+// ObservationQuery describes the metric, time range and pagination of a listing of the
+// observations of a city. An empty Metric lists the observations of every metric. From is
+// inclusive and To exclusive; a zero time leaves its end of the range open.
+type ObservationQuery struct {
+	CityID int64
+	Metric Metric
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Cursor string
+}
+
+// ObservationPage describes a page of listed observations and the cursor of the page following it
+type ObservationPage struct {
+	Observations []*Observation
+	NextCursor   string
+}
+
+// observationCursor describes the position of the last observation of a listed page
+type observationCursor struct {
+	CityID    int64  `json:"c"`
+	Metric    Metric `json:"m,omitempty"`
+	Timestamp int64  `json:"t"`
+	ID        int64  `json:"i"`
+}
+
+// ObservationManager describes an observation model manager
+type ObservationManager struct {
+	DB *sql.DB
+}
+
+// Create creates an observation of a city, returning ErrNotFound if the city does not exist or
+// is deleted, or a *ValidationError if the observation is invalid
+func (om *ObservationManager) Create(no *NewObservation) (*Observation, error) {
+	if err := no.Validate(); err != nil {
+		return nil, err
+	}
+
+	// selecting the values from the city rejects observations of cities that are deleted
+	sqlStmt := `
+	INSERT INTO observations
+	(city_id, metric, value, timestamp, received_at)
+	SELECT ID, $2, $3, $4, $5 FROM cities
+	WHERE ID = $1 AND deleted_at IS NULL
+	RETURNING ` + observationColumns + `;
+	`
+
+	now := time.Now()
+	observedAt := now
+	if !no.ObservedAt.IsZero() {
+		observedAt = no.ObservedAt
+	}
+
+	o, err := scanObservation(om.DB.QueryRow(sqlStmt, no.CityID, string(no.Metric), no.base(), observedAt.Unix(), now.Unix()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// List returns a page of the observations of a city within the time range of the query, ordered
+// by the time they were observed at and then by ID. ErrNotFound is returned if the city does not
+// exist or is deleted, and ErrInvalidCursor if the cursor is malformed or belongs to another
+// city or metric.
+func (om *ObservationManager) List(oq *ObservationQuery) (*ObservationPage, error) {
+	limit := oq.Limit
+	if limit <= 0 {
+		limit = DefaultObservationLimit
+	}
+	if limit > MaxObservationLimit {
+		limit = MaxObservationLimit
+	}
+
+	var q query
+	q.where("city_id = " + q.arg(oq.CityID))
+	// the observations of a deleted city are not listed
+	q.where("EXISTS (SELECT 1 FROM cities WHERE cities.ID = observations.city_id AND cities.deleted_at IS NULL)")
+	if oq.Metric != "" {
+		q.where("metric = " + q.arg(string(oq.Metric)))
+	}
+	if !oq.From.IsZero() {
+		q.where("timestamp >= " + q.arg(oq.From.Unix()))
+	}
+	if !oq.To.IsZero() {
+		q.where("timestamp < " + q.arg(oq.To.Unix()))
+	}
+
+	if oq.Cursor != "" {
+		cur, err := decodeObservationCursor(oq.Cursor)
+		if err != nil || cur.CityID != oq.CityID || cur.Metric != oq.Metric {
+			return nil, ErrInvalidCursor
+		}
+
+		q.where(fmt.Sprintf("(timestamp, ID) > (%s, %s)", q.arg(cur.Timestamp), q.arg(cur.ID)))
+	}
+
+	sqlStmt := `
+	SELECT ` + observationColumns + ` FROM observations
+	` + q.whereClause() + `
+	ORDER BY timestamp ASC, ID ASC
+	LIMIT ` + q.arg(limit+1) + `;
+	`
+
+	rows, err := om.DB.Query(sqlStmt, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &ObservationPage{
+		Observations: []*Observation{},
+	}
+	for rows.Next() {
+		o, err := scanObservation(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Observations = append(page.Observations, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// an empty page tells apart a city without observations in the range from one that does not exist
+	if len(page.Observations) == 0 {
+		var exists bool
+		if err := om.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM cities WHERE ID = $1 AND deleted_at IS NULL);`, oq.CityID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrNotFound
+		}
+	}
+
+	if len(page.Observations) > limit {
+		page.Observations = page.Observations[:limit]
+
+		last := page.Observations[limit-1]
+		page.NextCursor, err = encodeObservationCursor(&observationCursor{
+			CityID:    oq.CityID,
+			Metric:    oq.Metric,
+			Timestamp: last.Timestamp,
+			ID:        last.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// scanObservation scans the observationColumns of a row
+func scanObservation(row rowScanner) (*Observation, error) {
+	var o Observation
+	if err := row.Scan(&o.ID, &o.CityID, &o.Metric, &o.Value, &o.Timestamp, &o.ReceivedAt); err != nil {
+		return nil, err
+	}
+
+	return &o, nil
+}
+
+// base converts the value of the new observation to the base unit of its metric, rounded to the
+// decimals observations are stored with
+func (no *NewObservation) base() float64 {
+	return Round(no.Unit.ToBase(no.Value), ObservationDecimals)
+}
+
+func encodeObservationCursor(cur *observationCursor) (string, error) {
+	b, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeObservationCursor(s string) (*observationCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cur observationCursor
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, err
+	}
+
+	return &cur, nil
+}
+
+// NewObservationManager returns a new ObservationManager
+func NewObservationManager(db *sql.DB) *ObservationManager {
+	return &ObservationManager{db}
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var observationRows = []string{"ID", "city_id", "metric", "value", "timestamp", "received_at"}
+
+func Test_CanCreateObservation(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		om := NewObservationManager(db)
+
+		mock.ExpectQuery("INSERT INTO observations").
+			WithArgs(1, "wind_speed", 10.0, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(observationRows).AddRow(1, 1, "wind_speed", 10.0, time.Now().Unix(), time.Now().Unix()))
+
+		o, err := om.Create(&NewObservation{CityID: 1, Metric: WindSpeed, Value: 36, Unit: KilometrePerHour})
+		r.NoError(err)
+		r.Equal(WindSpeed, o.Metric)
+		r.Equal(10.0, o.Value)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CannotCreateObservationOfNonExistentCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		om := NewObservationManager(db)
+
+		mock.ExpectQuery("INSERT INTO observations").WillReturnError(sql.ErrNoRows)
+
+		o, err := om.Create(&NewObservation{CityID: 404, Metric: Humidity, Value: 55})
+		r.Nil(o)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
+
+func Test_CannotCreateInvalidObservation(t *testing.T) {
+	r := require.New(t)
+
+	err := (&NewObservation{CityID: 1, Metric: Humidity, Value: 120}).Validate()
+	r.True(err.(*ValidationError).Has("value"))
+
+	err = (&NewObservation{CityID: 1, Metric: WindDirection, Value: 360}).Validate()
+	r.True(err.(*ValidationError).Has("value"))
+
+	err = (&NewObservation{CityID: 1, Metric: Pressure, Value: 1013, Unit: Millimetre}).Validate()
+	r.True(err.(*ValidationError).Has("unit"))
+
+	err = (&NewObservation{Metric: "visibility", Value: 10}).Validate()
+	r.True(err.(*ValidationError).Has("metric"))
+	r.True(err.(*ValidationError).Has("city_id"))
+}
+
+func Test_CanListObservationsOfMetric(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		om := NewObservationManager(db)
+
+		now := time.Now().Unix()
+		mock.ExpectQuery("SELECT (.+) FROM observations WHERE city_id = \\$1 (.+) AND metric = \\$2").
+			WithArgs(1, "humidity", 2).
+			WillReturnRows(sqlmock.NewRows(observationRows).
+				AddRow(1, 1, "humidity", 55.0, now, now).
+				AddRow(2, 1, "humidity", 60.0, now, now))
+
+		page, err := om.List(&ObservationQuery{CityID: 1, Metric: Humidity, Limit: 1})
+		r.NoError(err)
+		r.Len(page.Observations, 1)
+		r.NotEmpty(page.NextCursor)
+
+		_, err = om.List(&ObservationQuery{CityID: 1, Metric: Pressure, Cursor: page.NextCursor})
+		r.Equal(ErrInvalidCursor, err)
+	}, t)
+}
+
+func Test_CanForecastMetrics(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		fm := NewForecastManager(db)
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("UTC"),
+		)
+		mock.ExpectQuery("SELECT metric, value FROM observations").
+			WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"metric", "value"}).
+				AddRow("precipitation", 1.5).
+				AddRow("precipitation", 2.5).
+				AddRow("wind_direction", 350.0).
+				AddRow("wind_direction", 10.0))
+
+		mf, err := fm.Metrics(1, "")
+		r.NoError(err)
+		r.Len(mf.Metrics, len(Metrics))
+
+		byMetric := make(map[Metric]*MetricAggregate)
+		for _, agg := range mf.Metrics {
+			byMetric[agg.Metric] = agg
+		}
+		r.Equal(int64(0), byMetric[Humidity].Sample)
+		r.Equal(4.0, byMetric[Precipitation].Total)
+		r.Equal(2.0, byMetric[Precipitation].Average)
+		r.InDelta(0, byMetric[WindDirection].Average, 1e-9)
+	}, t)
+}
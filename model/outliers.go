@@ -0,0 +1,260 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// OutlierAction describes what is done with a temperature scored as an outlier
+type OutlierAction string
+
+const (
+	// OutlierAccept stores outliers like any other temperature
+	OutlierAccept OutlierAction = "accept"
+	// OutlierFlag stores outliers flagged, excluding them from forecasts
+	OutlierFlag OutlierAction = "flag"
+	// OutlierReject does not store outliers at all
+	OutlierReject OutlierAction = "reject"
+)
+
+const (
+	// DefaultOutlierAction is what is done with outliers unless the policy of their city or the
+	// OutlierAction of the TemperatureManager says otherwise
+	DefaultOutlierAction = OutlierFlag
+	// DefaultOutlierThreshold is the score above which a temperature is an outlier unless the
+	// policy of its city says otherwise
+	DefaultOutlierThreshold = 3.5
+	// OutlierHistory is the number of most recent unflagged temperatures of a city a new
+	// temperature is scored against
+	OutlierHistory = 100
+	// MinOutlierHistory is the number of temperatures a city needs before new ones are scored
+	MinOutlierHistory = 10
+	// DefaultFlaggedLimit is the number of flagged temperatures listed if no limit is given
+	DefaultFlaggedLimit = 20
+	// MaxFlaggedLimit is the maximum number of flagged temperatures listed at once
+	MaxFlaggedLimit = 100
+	// madScale scales the median absolute deviation to the standard deviation of a normal distribution
+	madScale = 1.4826
+	// minOutlierDeviation is the lowest deviation in degrees Celsius scores are computed with, so
+	// that a city whose temperatures barely vary does not take every small change for an outlier
+	minOutlierDeviation = 1.0
+)
+
+// OutlierPolicy describes how the temperatures of a city are checked for outliers. Every new
+// temperature is scored by how many deviations its min or max lies from the median of the recent
+// temperatures of the city; one scoring above Threshold is handled according to Action.
+type OutlierPolicy struct {
+	CityID    int64
+	Action    OutlierAction
+	Threshold float64
+}
+
+// OutlierError describes a temperature rejected as an outlier by the policy of its city
+type OutlierError struct {
+	Score     float64
+	Threshold float64
+}
+
+// Error describes the score of the rejected temperature
+func (e *OutlierError) Error() string {
+	return fmt.Sprintf("temperature is an outlier with a score of %.2f above the threshold of %v", e.Score, e.Threshold)
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// outlierCheck describes the outcome of scoring a new temperature. Score is nil if the city has
+// too few temperatures to score it against.
+type outlierCheck struct {
+	Score   *float64
+	Flagged bool
+}
+
+// checkOutlier scores a new temperature given in Celsius against the recent temperatures of its
+// city and applies the policy of the city, or action if it has none, returning an *OutlierError
+// if it is rejected or ErrNotFound if the city does not exist or is deleted
+func checkOutlier(q queryer, cid int64, min, max float64, action OutlierAction) (*outlierCheck, error) {
+	sqlStmt := `
+	SELECT COALESCE(p.action, $2), COALESCE(p.threshold, $3), t.min, t.max
+	FROM cities c
+	LEFT JOIN outlier_policies p ON p.city_id = c.ID
+	LEFT JOIN LATERAL (
+		SELECT min, max FROM temperatures
+		WHERE city_id = c.ID AND NOT flagged
+		ORDER BY timestamp DESC, ID DESC
+		LIMIT $4
+	) AS t ON true
+	WHERE c.ID = $1 AND c.deleted_at IS NULL
+	`
+	rows, err := q.Query(sqlStmt, cid, string(action), DefaultOutlierThreshold, OutlierHistory)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := false
+	var policy OutlierPolicy
+	var mins, maxs []float64
+	for rows.Next() {
+		var tmin, tmax sql.NullFloat64
+		if err := rows.Scan(&policy.Action, &policy.Threshold, &tmin, &tmax); err != nil {
+			return nil, err
+		}
+
+		found = true
+		if tmin.Valid {
+			mins = append(mins, tmin.Float64)
+			maxs = append(maxs, tmax.Float64)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	check := &outlierCheck{}
+	if len(mins) < MinOutlierHistory {
+		return check, nil
+	}
+
+	score := Round(math.Max(outlierScore(mins, min), outlierScore(maxs, max)), 2)
+	check.Score = &score
+	if score <= policy.Threshold {
+		return check, nil
+	}
+
+	switch policy.Action {
+	case OutlierFlag:
+		check.Flagged = true
+	case OutlierReject:
+		return nil, &OutlierError{Score: score, Threshold: policy.Threshold}
+	}
+
+	return check, nil
+}
+
+// outlierScore returns the robust z-score of v, the number of deviations it lies from the median
+// of history, estimating the deviation from the median absolute deviation of history
+func outlierScore(history []float64, v float64) float64 {
+	m := median(history)
+	deviations := make([]float64, 0, len(history))
+	for _, h := range history {
+		deviations = append(deviations, math.Abs(h-m))
+	}
+
+	deviation := math.Max(madScale*median(deviations), minOutlierDeviation)
+	return math.Abs(v-m) / deviation
+}
+
+// median returns the median of values, which must not be empty, without reordering them
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+
+	return sorted[mid]
+}
+
+// OutlierPolicy returns the outlier policy of a city, which is to handle outliers scoring above
+// DefaultOutlierThreshold according to the OutlierAction of the manager unless set otherwise.
+// ErrNotFound is returned if the city does not exist or is deleted.
+func (tm *TemperatureManager) OutlierPolicy(cid int64) (*OutlierPolicy, error) {
+	sqlStmt := `
+	SELECT c.ID, COALESCE(p.action, $2), COALESCE(p.threshold, $3) FROM cities c
+	LEFT JOIN outlier_policies p ON p.city_id = c.ID
+	WHERE c.ID = $1 AND c.deleted_at IS NULL
+	`
+
+	var p OutlierPolicy
+	if err := tm.DB.QueryRow(sqlStmt, cid, string(tm.OutlierAction), DefaultOutlierThreshold).Scan(&p.CityID, &p.Action, &p.Threshold); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// SetOutlierPolicy sets the outlier policy of a city, returning ErrNotFound if the city does not
+// exist or is deleted, or a *ValidationError if the policy is invalid
+func (tm *TemperatureManager) SetOutlierPolicy(p *OutlierPolicy) (*OutlierPolicy, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	sqlStmt := `
+	INSERT INTO outlier_policies (city_id, action, threshold)
+	SELECT ID, $2, $3 FROM cities
+	WHERE ID = $1 AND deleted_at IS NULL
+	ON CONFLICT (city_id) DO UPDATE
+	SET action = EXCLUDED.action, threshold = EXCLUDED.threshold
+	RETURNING city_id, action, threshold;
+	`
+
+	var set OutlierPolicy
+	if err := tm.DB.QueryRow(sqlStmt, p.CityID, string(p.Action), p.Threshold).Scan(&set.CityID, &set.Action, &set.Threshold); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return &set, nil
+}
+
+// Flagged lists the most recent flagged temperatures, newest first, of a single city or of all
+// cities if cid is 0. Temperatures of deleted cities are left out.
+func (tm *TemperatureManager) Flagged(cid int64, limit int) ([]*Temperature, error) {
+	if limit <= 0 {
+		limit = DefaultFlaggedLimit
+	}
+	if limit > MaxFlaggedLimit {
+		limit = MaxFlaggedLimit
+	}
+
+	q := &query{}
+	q.where("t.flagged")
+	if cid != 0 {
+		q.where("t.city_id = " + q.arg(cid))
+	}
+
+	sqlStmt := `
+	SELECT t.ID, t.min, t.max, t.timestamp, t.city_id, t.received_at, t.flagged, t.outlier_score, t.station_id
+	FROM temperatures t
+	JOIN cities c ON c.ID = t.city_id AND c.deleted_at IS NULL
+	` + q.whereClause() + `
+	ORDER BY t.timestamp DESC, t.ID DESC
+	LIMIT ` + q.arg(limit)
+
+	rows, err := tm.DB.Query(sqlStmt, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	temps := []*Temperature{}
+	for rows.Next() {
+		temp, err := scanTemperature(rows)
+		if err != nil {
+			return nil, err
+		}
+		temps = append(temps, temp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return temps, nil
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+// outlierHistory returns the rows of a policy and a steady history of temperatures around 10 to 20
+func outlierHistory(action OutlierAction) *sqlmock.Rows {
+	rows := sqlmock.NewRows(outlierRows)
+	for i := 0; i < MinOutlierHistory; i++ {
+		rows.AddRow(string(action), DefaultOutlierThreshold, 10+float64(i%3), 20+float64(i%3))
+	}
+
+	return rows
+}
+
+func Test_CanScoreOutlier(t *testing.T) {
+	r := require.New(t)
+
+	history := []float64{10, 11, 12, 10, 11, 12, 10, 11, 12, 11}
+	r.InDelta(0, outlierScore(history, 11), 1e-9)
+	r.True(outlierScore(history, 12.5) < DefaultOutlierThreshold)
+	r.True(outlierScore(history, 900) > DefaultOutlierThreshold)
+
+	// a history that never varies still tolerates changes of a degree
+	r.InDelta(1, outlierScore([]float64{5, 5, 5, 5}, 6), 1e-9)
+}
+
+func Test_OutlierIsFlaggedByCityPolicy(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WithArgs(1, string(DefaultOutlierAction), DefaultOutlierThreshold, OutlierHistory).
+			WillReturnRows(outlierHistory(OutlierFlag))
+		mock.ExpectQuery("INSERT INTO temperatures").
+			WithArgs(1, 900.0, 905.0, sqlmock.AnyArg(), sqlmock.AnyArg(), true, sqlmock.AnyArg(), nil).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}).
+					AddRow(1, 900, 905, time.Now().Unix(), 1, time.Now().Unix(), true, 597.01, nil),
+			)
+
+		temp, err := tm.Create(&NewTemperature{CityID: 1, Min: 900, Max: 905})
+		r.NoError(err)
+		r.True(temp.Flagged)
+		r.NotNil(temp.OutlierScore)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_OutlierIsRejectedByCityPolicy(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WillReturnRows(outlierHistory(OutlierReject))
+
+		temp, err := tm.Create(&NewTemperature{CityID: 1, Min: 900, Max: 905})
+		r.Nil(temp)
+		r.IsType(&OutlierError{}, err)
+		r.True(err.(*OutlierError).Score > DefaultOutlierThreshold)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_OutlierIsHandledByActionOfManagerWithoutCityPolicy(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+		tm.OutlierAction = OutlierAccept
+
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WithArgs(1, "accept", DefaultOutlierThreshold, OutlierHistory).
+			WillReturnRows(outlierHistory(OutlierAccept))
+		mock.ExpectQuery("INSERT INTO temperatures").
+			WithArgs(1, 900.0, 905.0, sqlmock.AnyArg(), sqlmock.AnyArg(), false, sqlmock.AnyArg(), nil).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}).
+					AddRow(1, 900, 905, time.Now().Unix(), 1, time.Now().Unix(), false, 597.01, nil),
+			)
+
+		temp, err := tm.Create(&NewTemperature{CityID: 1, Min: 900, Max: 905})
+		r.NoError(err)
+		r.False(temp.Flagged)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CanSetOutlierPolicy(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		mock.ExpectQuery("INSERT INTO outlier_policies").WithArgs(1, "flag", 5.0).WillReturnRows(
+			sqlmock.NewRows([]string{"city_id", "action", "threshold"}).AddRow(1, "flag", 5.0),
+		)
+
+		p, err := tm.SetOutlierPolicy(&OutlierPolicy{CityID: 1, Action: OutlierFlag, Threshold: 5})
+		r.NoError(err)
+		r.Equal(OutlierFlag, p.Action)
+
+		_, err = tm.SetOutlierPolicy(&OutlierPolicy{CityID: 1, Action: "ignore", Threshold: 0})
+		r.Len(err.(*ValidationError).Errors, 2)
+	}, t)
+}
+
+func Test_CannotGetOutlierPolicyOfNonExistentCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WithArgs(404, string(DefaultOutlierAction), DefaultOutlierThreshold).
+			WillReturnError(sql.ErrNoRows)
+
+		p, err := tm.OutlierPolicy(404)
+		r.Nil(p)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
+
+func Test_CanListFlaggedTemperatures(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM temperatures t JOIN cities c ON c.ID = t.city_id AND c.deleted_at IS NULL WHERE t.flagged AND t.city_id = \\$1").WithArgs(1, DefaultFlaggedLimit).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}).
+				AddRow(7, 900, 905, time.Now().Unix(), 1, time.Now().Unix(), true, 597.01, nil),
+		)
+
+		temps, err := tm.Flagged(1, 0)
+		r.NoError(err)
+		r.Len(temps, 1)
+		r.Equal(597.01, *temps[0].OutlierScore)
+	}, t)
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// query collects the conditions and positional arguments of a dynamically built sql statement
+type query struct {
+	conds []string
+	args  []interface{}
+}
+
+// arg adds a positional argument to the query and returns its placeholder
+func (q *query) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// where adds a condition that all returned rows have to satisfy
+func (q *query) where(cond string) {
+	q.conds = append(q.conds, cond)
+}
+
+// whereClause returns the WHERE clause of all conditions or an empty string if there are none
+func (q *query) whereClause() string {
+	if len(q.conds) == 0 {
+		return ""
+	}
+
+	return "WHERE " + strings.Join(q.conds, " AND ")
+}
+
+// escapeLike escapes the wildcard characters of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,311 @@
+package model
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// MaxRegionNameLength is the maximum number of characters of a region name
+const MaxRegionNameLength = 100
+
+// regionColumns are the columns selected whenever a region is read from the database, along with
+// the IDs of its member cities that are not deleted
+const regionColumns = `r.ID, r.name,
+	COALESCE(array_agg(c.ID ORDER BY c.ID) FILTER (WHERE c.ID IS NOT NULL), '{}')`
+
+// regionFrom joins the regions read through regionColumns with their member cities
+const regionFrom = `FROM regions r
+	LEFT JOIN region_cities rc ON rc.region_id = r.ID
+	LEFT JOIN cities c ON c.ID = rc.city_id AND c.deleted_at IS NULL`
+
+// Region describes a named group of cities, e.g. Brandenburg or DACH. A city may be a member of
+// any number of regions.
+type Region struct {
+	ID      int64
+	Name    string
+	CityIDs []int64
+}
+
+// NewRegion describes the form values of a new region and the IDs of its member cities
+type NewRegion struct {
+	Name    string
+	CityIDs []int64
+}
+
+// RegionManager describes a region model manager
+type RegionManager struct {
+	db *sql.DB
+}
+
+// scanRegion scans a row of regionColumns
+func scanRegion(row rowScanner) (*Region, error) {
+	var region Region
+	var ids pq.Int64Array
+	if err := row.Scan(&region.ID, &region.Name, &ids); err != nil {
+		return nil, err
+	}
+
+	region.CityIDs = []int64(ids)
+	return &region, nil
+}
+
+// Validate checks that a new region can be stored, returning a *ValidationError listing all
+// invalid fields
+func (nr *NewRegion) Validate() error {
+	var ve ValidationError
+	validateRegionName(&ve, nr.Name)
+	for _, id := range nr.CityIDs {
+		if id <= 0 {
+			ve.Add("city_id", "must be a positive ID, got %d", id)
+			break
+		}
+	}
+
+	return ve.Err()
+}
+
+func validateRegionName(ve *ValidationError, name string) {
+	switch {
+	case strings.TrimSpace(name) == "":
+		ve.Add("name", "must not be empty")
+	case len([]rune(name)) > MaxRegionNameLength:
+		ve.Add("name", "must not be longer than %d characters", MaxRegionNameLength)
+	}
+}
+
+// Create creates a new region along with its member cities. ErrAlreadyExists is returned if a
+// region of the same name exists, and a *ValidationError if any member city does not exist.
+func (rm *RegionManager) Create(nr *NewRegion) (*Region, error) {
+	if err := nr.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := rm.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	var id int64
+	if err := tx.QueryRow(`INSERT INTO regions (name) VALUES ($1) RETURNING ID;`, nr.Name).Scan(&id); err != nil {
+		tx.Rollback()
+		if pgerr, ok := err.(*pq.Error); ok {
+			if pgerr.Code == "23505" {
+				return nil, ErrAlreadyExists
+			}
+		}
+		return nil, err
+	}
+
+	if len(nr.CityIDs) > 0 {
+		// only cities that exist are added, so a shortfall means some of them do not
+		sqlStmt := `
+		INSERT INTO region_cities (region_id, city_id)
+		SELECT $1, ID FROM cities
+		WHERE ID = ANY($2) AND deleted_at IS NULL
+		ON CONFLICT DO NOTHING;
+		`
+
+		res, err := tx.Exec(sqlStmt, id, pq.Int64Array(nr.CityIDs))
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if n != int64(len(uniqueIDs(nr.CityIDs))) {
+			tx.Rollback()
+			var ve ValidationError
+			ve.Add("city_id", "must only name existing cities")
+			return nil, &ve
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return rm.Get(id)
+}
+
+// Get returns an existing region along with the IDs of its member cities
+func (rm *RegionManager) Get(id int64) (*Region, error) {
+	sqlStmt := `
+	SELECT ` + regionColumns + `
+	` + regionFrom + `
+	WHERE r.ID = $1
+	GROUP BY r.ID;
+	`
+
+	region, err := scanRegion(rm.db.QueryRow(sqlStmt, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return region, nil
+}
+
+// List returns all regions ordered by name
+func (rm *RegionManager) List() ([]*Region, error) {
+	sqlStmt := `
+	SELECT ` + regionColumns + `
+	` + regionFrom + `
+	GROUP BY r.ID
+	ORDER BY r.name;
+	`
+
+	rows, err := rm.db.Query(sqlStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	regions := []*Region{}
+	for rows.Next() {
+		region, err := scanRegion(rows)
+		if err != nil {
+			return nil, err
+		}
+		regions = append(regions, region)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return regions, nil
+}
+
+// Rename changes the name of an existing region, returning ErrAlreadyExists if another region
+// has that name already
+func (rm *RegionManager) Rename(id int64, name string) (*Region, error) {
+	var ve ValidationError
+	validateRegionName(&ve, name)
+	if err := ve.Err(); err != nil {
+		return nil, err
+	}
+
+	res, err := rm.db.Exec(`UPDATE regions SET name = $1 WHERE ID = $2;`, name, id)
+	if err != nil {
+		if pgerr, ok := err.(*pq.Error); ok {
+			if pgerr.Code == "23505" {
+				return nil, ErrAlreadyExists
+			}
+		}
+		return nil, err
+	}
+
+	if err := expectAffected(res); err != nil {
+		return nil, err
+	}
+
+	return rm.Get(id)
+}
+
+// Delete deletes an existing region. Its member cities are left as they are.
+func (rm *RegionManager) Delete(id int64) (*Region, error) {
+	region, err := rm.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := rm.db.Exec(`DELETE FROM regions WHERE ID = $1;`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := expectAffected(res); err != nil {
+		return nil, err
+	}
+
+	return region, nil
+}
+
+// AddCity makes an existing city a member of an existing region. Adding a city that is a member
+// already leaves the region as it is.
+func (rm *RegionManager) AddCity(id, cityID int64) (*Region, error) {
+	sqlStmt := `
+	INSERT INTO region_cities (region_id, city_id)
+	SELECT r.ID, c.ID FROM regions r, cities c
+	WHERE r.ID = $1 AND c.ID = $2 AND c.deleted_at IS NULL
+	ON CONFLICT DO NOTHING
+	RETURNING region_id;
+	`
+
+	var rid int64
+	if err := rm.db.QueryRow(sqlStmt, id, cityID).Scan(&rid); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	region, err := rm.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !containsID(region.CityIDs, cityID) {
+		return nil, ErrNotFound
+	}
+
+	return region, nil
+}
+
+// RemoveCity removes a city from the members of a region, returning ErrNotFound if it is no member
+func (rm *RegionManager) RemoveCity(id, cityID int64) (*Region, error) {
+	res, err := rm.db.Exec(`DELETE FROM region_cities WHERE region_id = $1 AND city_id = $2;`, id, cityID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := expectAffected(res); err != nil {
+		return nil, err
+	}
+
+	return rm.Get(id)
+}
+
+// expectAffected returns ErrNotFound if a statement changed no rows
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+func uniqueIDs(ids []int64) map[int64]bool {
+	unique := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+
+	return unique
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewRegionManager returns a new RegionManager
+func NewRegionManager(db *sql.DB) *RegionManager {
+	return &RegionManager{db}
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CanCreateRegion(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		rm := NewRegionManager(db)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO regions").WithArgs("DACH").WillReturnRows(
+			sqlmock.NewRows([]string{"ID"}).AddRow(1),
+		)
+		mock.ExpectExec("INSERT INTO region_cities").
+			WithArgs(1, pq.Int64Array{1, 2, 2}).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		mock.ExpectQuery("SELECT (.+) FROM regions r").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "name", "city_ids"}).AddRow(1, "DACH", "{1,2}"),
+		)
+
+		region, err := rm.Create(&NewRegion{Name: "DACH", CityIDs: []int64{1, 2, 2}})
+		r.NoError(err)
+		r.Equal("DACH", region.Name)
+		r.Equal([]int64{1, 2}, region.CityIDs)
+	}, t)
+}
+
+func Test_CannotCreateRegionWithNonExistentCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		rm := NewRegionManager(db)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO regions").WillReturnRows(
+			sqlmock.NewRows([]string{"ID"}).AddRow(1),
+		)
+		mock.ExpectExec("INSERT INTO region_cities").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectRollback()
+
+		region, err := rm.Create(&NewRegion{Name: "DACH", CityIDs: []int64{1, 99}})
+		r.Nil(region)
+		r.Error(err)
+		r.True(err.(*ValidationError).Has("city_id"))
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CannotCreateRegionThatExists(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		rm := NewRegionManager(db)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO regions").WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+
+		region, err := rm.Create(&NewRegion{Name: "DACH"})
+		r.Nil(region)
+		r.Equal(ErrAlreadyExists, err)
+	}, t)
+}
+
+func Test_CannotAddNonExistentCityToRegion(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		rm := NewRegionManager(db)
+
+		mock.ExpectQuery("INSERT INTO region_cities").WithArgs(1, 99).WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT (.+) FROM regions r").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "name", "city_ids"}).AddRow(1, "DACH", "{1,2}"),
+		)
+
+		region, err := rm.AddCity(1, 99)
+		r.Nil(region)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
+
+func Test_CannotRemoveCityThatIsNoMemberOfRegion(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		rm := NewRegionManager(db)
+
+		mock.ExpectExec("DELETE FROM region_cities").WithArgs(1, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		region, err := rm.RemoveCity(1, 3)
+		r.Nil(region)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
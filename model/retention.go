@@ -0,0 +1,236 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Resolution describes the period temperatures are aggregated over
+type Resolution string
+
+const (
+	// Raw lists every temperature of a city as it was stored
+	Raw Resolution = "raw"
+	// Hourly aggregates the temperatures of each local hour of a city
+	Hourly Resolution = "hourly"
+	// Daily aggregates the temperatures of each local day of a city
+	Daily Resolution = "daily"
+)
+
+const (
+	// DefaultRawRetention is how long temperatures are kept before being rolled into hourly
+	// aggregates unless set otherwise
+	DefaultRawRetention = 7 * 24 * time.Hour
+	// DefaultHourlyRetention is how long after their hour hourly aggregates are kept before being
+	// rolled into daily aggregates unless set otherwise
+	DefaultHourlyRetention = 90 * 24 * time.Hour
+	// DefaultDailyRetention is how long after their day daily aggregates are kept unless set
+	// otherwise, 0 keeping them forever
+	DefaultDailyRetention = 0
+	// MinRetention is the shortest retention of any tier
+	MinRetention = time.Hour
+)
+
+// RetentionPolicy describes how long the temperatures of a city are kept at each resolution,
+// counting from the time they were observed at. Temperatures older than Raw are rolled into
+// hourly aggregates, which are rolled into daily aggregates once older than Hourly. Daily
+// aggregates older than Daily are deleted, unless Daily is 0. Corrected temperatures are never
+// rolled up, and flagged temperatures not until they are reviewed by correcting or deleting them.
+type RetentionPolicy struct {
+	CityID int64
+	Raw    time.Duration
+	Hourly time.Duration
+	Daily  time.Duration
+}
+
+// Downsampling describes the outcome of rolling up the temperatures of all cities: the number of
+// temperatures rolled into hourly aggregates, of hourly aggregates rolled into daily aggregates
+// and of daily aggregates deleted
+type Downsampling struct {
+	Raw    int64
+	Hourly int64
+	Daily  int64
+}
+
+// ParseResolution parses the name of a resolution, e.g. "hourly"
+func ParseResolution(s string) (Resolution, error) {
+	switch res := Resolution(strings.ToLower(strings.TrimSpace(s))); res {
+	case Raw, Hourly, Daily:
+		return res, nil
+	}
+
+	return "", fmt.Errorf("resolution must be one of raw, hourly or daily, got %q", s)
+}
+
+// mergeAggregate merges an aggregate of the same city, station and period into an existing
+// one aliased as a, which happens when late temperatures are rolled into a period already rolled up
+const mergeAggregate = `
+	ON CONFLICT (city_id, (COALESCE(station_id, 0)), timestamp) DO UPDATE SET
+	min = LEAST(a.min, EXCLUDED.min),
+	max = GREATEST(a.max, EXCLUDED.max),
+	avg_min = (a.avg_min * a.count + EXCLUDED.avg_min * EXCLUDED.count) / (a.count + EXCLUDED.count),
+	avg_max = (a.avg_max * a.count + EXCLUDED.avg_max * EXCLUDED.count) / (a.count + EXCLUDED.count),
+	count = a.count + EXCLUDED.count
+	`
+
+// RetentionPolicy returns the retention policy of a city, which is defaults unless set
+// otherwise. ErrNotFound is returned if the city does not exist or is deleted.
+func (tm *TemperatureManager) RetentionPolicy(cid int64, defaults *RetentionPolicy) (*RetentionPolicy, error) {
+	sqlStmt := `
+	SELECT c.ID, COALESCE(p.raw_retention, $2), COALESCE(p.hourly_retention, $3), COALESCE(p.daily_retention, $4)
+	FROM cities c
+	LEFT JOIN retention_policies p ON p.city_id = c.ID
+	WHERE c.ID = $1 AND c.deleted_at IS NULL
+	`
+
+	p, err := scanRetentionPolicy(tm.DB.QueryRow(sqlStmt, cid, seconds(defaults.Raw), seconds(defaults.Hourly), seconds(defaults.Daily)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// SetRetentionPolicy sets the retention policy of a city, returning ErrNotFound if the city does
+// not exist or is deleted, or a *ValidationError if the policy is invalid
+func (tm *TemperatureManager) SetRetentionPolicy(p *RetentionPolicy) (*RetentionPolicy, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	sqlStmt := `
+	INSERT INTO retention_policies (city_id, raw_retention, hourly_retention, daily_retention)
+	SELECT ID, $2, $3, $4 FROM cities
+	WHERE ID = $1 AND deleted_at IS NULL
+	ON CONFLICT (city_id) DO UPDATE
+	SET raw_retention = EXCLUDED.raw_retention, hourly_retention = EXCLUDED.hourly_retention, daily_retention = EXCLUDED.daily_retention
+	RETURNING city_id, raw_retention, hourly_retention, daily_retention;
+	`
+
+	set, err := scanRetentionPolicy(tm.DB.QueryRow(sqlStmt, p.CityID, seconds(p.Raw), seconds(p.Hourly), seconds(p.Daily)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// ResetRetentionPolicy removes the retention policy set for a city, so that the defaults apply to
+// it again. ErrNotFound is returned if the city has no policy of its own.
+func (tm *TemperatureManager) ResetRetentionPolicy(cid int64) error {
+	res, err := tm.DB.Exec(`DELETE FROM retention_policies WHERE city_id = $1;`, cid)
+	if err != nil {
+		return err
+	}
+
+	return expectAffected(res)
+}
+
+// Downsample rolls the temperatures of every city that have outlived its retention policy, or
+// defaults if it has none, into hourly aggregates, rolls the hourly aggregates that have outlived
+// it into daily aggregates and deletes the daily aggregates that have outlived it, as of now.
+// Each step is atomic, so that running it concurrently does not roll up any temperature twice.
+func (tm *TemperatureManager) Downsample(defaults *RetentionPolicy, now time.Time) (*Downsampling, error) {
+	var ds Downsampling
+
+	// temperatures are rolled into the hour they were observed in, local to their city, except
+	// corrected ones, which are kept so that they can be corrected again along their audit trail,
+	// and flagged ones, which are kept until they are reviewed
+	rawStmt := `
+	WITH expired AS (
+		DELETE FROM temperatures t
+		USING cities c
+		LEFT JOIN retention_policies p ON p.city_id = c.ID
+		WHERE t.city_id = c.ID AND t.timestamp < $1 - COALESCE(p.raw_retention, $2)
+		AND NOT t.flagged AND NOT EXISTS (SELECT 1 FROM temperature_corrections tc WHERE tc.temperature_id = t.ID)
+		RETURNING t.city_id, t.station_id, t.min, t.max, t.timestamp, c.time_zone
+	), rolled AS (
+		INSERT INTO temperatures_hourly AS a (city_id, station_id, timestamp, min, max, avg_min, avg_max, count)
+		SELECT city_id, station_id, ` + periodStart(Hourly, "timestamp", "time_zone") + ` AS period,
+		MIN(min), MAX(max), AVG(min), AVG(max), COUNT(*)
+		FROM expired
+		GROUP BY city_id, station_id, period
+		` + mergeAggregate + `
+	)
+	SELECT COUNT(*) FROM expired;
+	`
+	if err := tm.DB.QueryRow(rawStmt, now.Unix(), seconds(defaults.Raw)).Scan(&ds.Raw); err != nil {
+		return nil, err
+	}
+
+	hourlyStmt := `
+	WITH expired AS (
+		DELETE FROM temperatures_hourly h
+		USING cities c
+		LEFT JOIN retention_policies p ON p.city_id = c.ID
+		WHERE h.city_id = c.ID AND h.timestamp < $1 - COALESCE(p.hourly_retention, $2)
+		RETURNING h.city_id, h.station_id, h.min, h.max, h.avg_min, h.avg_max, h.count, h.timestamp, c.time_zone
+	), rolled AS (
+		INSERT INTO temperatures_daily AS a (city_id, station_id, timestamp, min, max, avg_min, avg_max, count)
+		SELECT city_id, station_id, ` + periodStart(Daily, "timestamp", "time_zone") + ` AS period,
+		MIN(min), MAX(max), SUM(avg_min * count) / SUM(count), SUM(avg_max * count) / SUM(count), SUM(count)
+		FROM expired
+		GROUP BY city_id, station_id, period
+		` + mergeAggregate + `
+	)
+	SELECT COUNT(*) FROM expired;
+	`
+	if err := tm.DB.QueryRow(hourlyStmt, now.Unix(), seconds(defaults.Hourly)).Scan(&ds.Hourly); err != nil {
+		return nil, err
+	}
+
+	dailyStmt := `
+	DELETE FROM temperatures_daily d
+	USING cities c
+	LEFT JOIN retention_policies p ON p.city_id = c.ID
+	WHERE d.city_id = c.ID AND COALESCE(p.daily_retention, $2) > 0 AND d.timestamp < $1 - COALESCE(p.daily_retention, $2);
+	`
+	res, err := tm.DB.Exec(dailyStmt, now.Unix(), seconds(defaults.Daily))
+	if err != nil {
+		return nil, err
+	}
+	if ds.Daily, err = res.RowsAffected(); err != nil {
+		return nil, err
+	}
+
+	return &ds, nil
+}
+
+// periodStart returns the sql expression of the Unix time of the start of the local hour or day,
+// in the time zone named by tz, of the Unix time held by column
+func periodStart(res Resolution, column, tz string) string {
+	unit := "hour"
+	if res == Daily {
+		unit = "day"
+	}
+
+	return "EXTRACT(EPOCH FROM date_trunc('" + unit + "', to_timestamp(" + column + ") AT TIME ZONE " + tz + ") AT TIME ZONE " + tz + ")::BIGINT"
+}
+
+// scanRetentionPolicy scans the city and the retentions in seconds of a retention policy
+func scanRetentionPolicy(row rowScanner) (*RetentionPolicy, error) {
+	var p RetentionPolicy
+	var raw, hourly, daily int64
+	if err := row.Scan(&p.CityID, &raw, &hourly, &daily); err != nil {
+		return nil, err
+	}
+
+	p.Raw = time.Duration(raw) * time.Second
+	p.Hourly = time.Duration(hourly) * time.Second
+	p.Daily = time.Duration(daily) * time.Second
+
+	return &p, nil
+}
+
+// seconds returns a retention in whole seconds, as it is stored
+func seconds(d time.Duration) int64 {
+	return int64(d / time.Second)
+}
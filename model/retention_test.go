@@ -0,0 +1,135 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var testRetention = &RetentionPolicy{Raw: DefaultRawRetention, Hourly: DefaultHourlyRetention, Daily: DefaultDailyRetention}
+
+func Test_CanDownsampleTemperatures(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+		now := time.Now()
+
+		mock.ExpectQuery(`DELETE FROM temperatures t (.+) AND NOT t.flagged AND NOT EXISTS \(SELECT 1 FROM temperature_corrections (.+) INSERT INTO temperatures_hourly`).
+			WithArgs(now.Unix(), int64(DefaultRawRetention/time.Second)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(120))
+		mock.ExpectQuery("DELETE FROM temperatures_hourly h (.+) INSERT INTO temperatures_daily").
+			WithArgs(now.Unix(), int64(DefaultHourlyRetention/time.Second)).
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(24))
+		mock.ExpectExec("DELETE FROM temperatures_daily d").
+			WithArgs(now.Unix(), int64(0)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		ds, err := tm.Downsample(testRetention, now)
+		r.NoError(err)
+		r.Equal(&Downsampling{Raw: 120, Hourly: 24, Daily: 0}, ds)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CanGetDefaultRetentionPolicy(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM cities c LEFT JOIN retention_policies p").
+			WithArgs(1, int64(604800), int64(7776000), int64(0)).
+			WillReturnRows(sqlmock.NewRows([]string{"ID", "raw_retention", "hourly_retention", "daily_retention"}).AddRow(1, 604800, 7776000, 0))
+
+		p, err := tm.RetentionPolicy(1, testRetention)
+		r.NoError(err)
+		r.Equal(DefaultRawRetention, p.Raw)
+		r.Equal(DefaultHourlyRetention, p.Hourly)
+		r.Equal(time.Duration(0), p.Daily)
+	}, t)
+}
+
+func Test_CannotSetInvalidRetentionPolicy(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		_, err := tm.SetRetentionPolicy(&RetentionPolicy{CityID: 1, Raw: time.Minute, Hourly: DefaultHourlyRetention, Daily: 30 * time.Minute})
+		ve, ok := err.(*ValidationError)
+		r.True(ok)
+		r.True(ve.Has("raw"))
+		r.False(ve.Has("hourly"))
+		r.True(ve.Has("daily"))
+	}, t)
+}
+
+func Test_CanPickHistoryResolutionByRetention(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Now()
+	r.Equal(Hourly, testRetention.resolution(now.AddDate(0, 0, -30), now))
+	r.Equal(Daily, testRetention.resolution(now.AddDate(0, 0, -120), now))
+	r.Equal(Daily, testRetention.resolution(time.Time{}, now))
+}
+
+func Test_CanGetTemperatureHistory(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+		now := time.Now()
+		from := now.AddDate(0, 0, -2).Truncate(time.Hour)
+
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("Europe/Berlin"),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM cities c LEFT JOIN retention_policies p").WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "raw_retention", "hourly_retention", "daily_retention"}).AddRow(1, 604800, 7776000, 0),
+		)
+
+		historyRows := []string{"period", "min", "max", "avg_min", "avg_max", "count"}
+		mock.ExpectQuery("SELECT period, (.+) FROM temperatures (.+) UNION ALL (.+) FROM temperatures_hourly (.+) GROUP BY period").
+			WithArgs("Europe/Berlin", 1, from.Unix(), from.Unix(), 3).
+			WillReturnRows(
+				sqlmock.NewRows(historyRows).
+					AddRow(from.Unix(), 8, 21, 10.5, 19.5, 4).
+					AddRow(from.Add(time.Hour).Unix(), 9, 20, 11, 18, 3).
+					AddRow(from.Add(2*time.Hour).Unix(), 10, 19, 12, 17, 1),
+			)
+
+		h, err := tm.History(&TemperatureHistoryQuery{CityID: 1, From: from, Limit: 2}, testRetention, now)
+		r.NoError(err)
+		r.Equal(Hourly, h.Resolution)
+		r.Len(h.Aggregates, 2)
+		r.Equal(int64(4), h.Aggregates[0].Sample)
+		r.Equal(10.5, h.Aggregates[0].AverageMin)
+		r.NotEmpty(h.NextCursor)
+
+		// the cursor of an hourly history does not page through a daily one
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("Europe/Berlin"),
+		)
+
+		_, err = tm.History(&TemperatureHistoryQuery{CityID: 1, Resolution: Daily, Cursor: h.NextCursor}, testRetention, now)
+		r.Equal(ErrInvalidCursor, err)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CannotGetTemperatureHistoryOfNonExistentCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(404).WillReturnError(sql.ErrNoRows)
+
+		_, err := tm.History(&TemperatureHistoryQuery{CityID: 404}, testRetention, time.Now())
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
@@ -0,0 +1,285 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// MaxStationOwnerLength is the maximum number of characters of the owner of a station
+	MaxStationOwnerLength = 100
+	// DefaultStationWeight is the weight of the temperatures of a station in weighted forecasts
+	// unless set otherwise, which is also the weight of temperatures without a station
+	DefaultStationWeight = 1.0
+	// MaxStationWeight is the maximum weight of the temperatures of a station in weighted forecasts
+	MaxStationWeight = 100.0
+	// stationTokenBytes is the number of random bytes of a station token
+	stationTokenBytes = 32
+)
+
+// stationColumns are the columns selected whenever a station is read from the database
+const stationColumns = `ID, city_id, latitude, longitude, elevation, owner, weight, created_at, deleted_at`
+
+// Station describes a weather station of a city reporting temperatures. Elevation is the height of
+// the station above sea level in metres, if known. The temperatures of a station count Weight
+// times in weighted forecasts. A deleted station is kept, so that the temperatures it reported stay
+// attributed to it, but can no longer report any.
+type Station struct {
+	ID        int64
+	CityID    int64
+	Latitude  float64
+	Longitude float64
+	Elevation *float64
+	Owner     string
+	Weight    float64
+	CreatedAt time.Time
+	DeletedAt *time.Time
+}
+
+// NewStation describes a new station of a city. A zero Weight is taken to be DefaultStationWeight.
+type NewStation struct {
+	CityID    int64
+	Latitude  float64
+	Longitude float64
+	Elevation *float64
+	Owner     string
+	Weight    float64
+}
+
+// StationManager describes a station model manager
+type StationManager struct {
+	DB *sql.DB
+}
+
+// Create creates a station of an existing city along with the token it authenticates with,
+// which is only stored hashed and so is returned only once. ErrNotFound is returned if the city
+// does not exist or is deleted, and a *ValidationError if the station is invalid.
+func (sm *StationManager) Create(ns *NewStation) (*Station, string, error) {
+	if ns.Weight == 0 {
+		ns.Weight = DefaultStationWeight
+	}
+	if err := ns.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	token, hash, err := newStationToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sqlStmt := `
+	INSERT INTO stations (city_id, latitude, longitude, elevation, owner, weight, token_hash)
+	SELECT ID, $2, $3, $4, $5, $6, $7 FROM cities
+	WHERE ID = $1 AND deleted_at IS NULL
+	RETURNING ` + stationColumns + `;
+	`
+
+	var elevation sql.NullFloat64
+	if ns.Elevation != nil {
+		elevation = sql.NullFloat64{Float64: *ns.Elevation, Valid: true}
+	}
+
+	st, err := scanStation(sm.DB.QueryRow(sqlStmt, ns.CityID, ns.Latitude, ns.Longitude, elevation, ns.Owner, ns.Weight, hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", ErrNotFound
+		}
+		return nil, "", err
+	}
+
+	return st, token, nil
+}
+
+// Get returns a station, including a deleted one, or ErrNotFound if it does not exist
+func (sm *StationManager) Get(id int64) (*Station, error) {
+	sqlStmt := `
+	SELECT ` + stationColumns + ` FROM stations
+	WHERE ID = $1;
+	`
+
+	st, err := scanStation(sm.DB.QueryRow(sqlStmt, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// List returns the stations of a city that are not deleted, ordered by ID. ErrNotFound is returned
+// if the city does not exist or is deleted.
+func (sm *StationManager) List(cid int64) ([]*Station, error) {
+	// the city is joined with its stations so that a city without any is told apart from none
+	sqlStmt := `
+	SELECT s.ID, s.city_id, s.latitude, s.longitude, s.elevation, s.owner, s.weight, s.created_at, s.deleted_at
+	FROM cities c
+	LEFT JOIN stations s ON s.city_id = c.ID AND s.deleted_at IS NULL
+	WHERE c.ID = $1 AND c.deleted_at IS NULL
+	ORDER BY s.ID;
+	`
+
+	rows, err := sm.DB.Query(sqlStmt, cid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := false
+	stations := []*Station{}
+	for rows.Next() {
+		var id, cityID sql.NullInt64
+		var st Station
+		var lat, lng, weight, elevation sql.NullFloat64
+		var owner sql.NullString
+		var createdAt, deletedAt pq.NullTime
+		if err := rows.Scan(&id, &cityID, &lat, &lng, &elevation, &owner, &weight, &createdAt, &deletedAt); err != nil {
+			return nil, err
+		}
+
+		found = true
+		if !id.Valid {
+			continue
+		}
+
+		st.ID, st.CityID, st.Latitude, st.Longitude = id.Int64, cityID.Int64, lat.Float64, lng.Float64
+		st.Owner, st.Weight, st.CreatedAt = owner.String, weight.Float64, createdAt.Time
+		if elevation.Valid {
+			st.Elevation = &elevation.Float64
+		}
+		stations = append(stations, &st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return stations, nil
+}
+
+// Delete deletes a station, revoking its token. ErrNotFound is returned if the station does not
+// exist or is already deleted.
+func (sm *StationManager) Delete(id int64) (*Station, error) {
+	sqlStmt := `
+	UPDATE stations SET deleted_at = now()
+	WHERE ID = $1 AND deleted_at IS NULL
+	RETURNING ` + stationColumns + `;
+	`
+
+	st, err := scanStation(sm.DB.QueryRow(sqlStmt, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// RotateToken replaces the token of a station, revoking the previous one, and returns the new
+// token. ErrNotFound is returned if the station does not exist or is deleted.
+func (sm *StationManager) RotateToken(id int64) (string, error) {
+	token, hash, err := newStationToken()
+	if err != nil {
+		return "", err
+	}
+
+	sqlStmt := `
+	UPDATE stations SET token_hash = $2
+	WHERE ID = $1 AND deleted_at IS NULL;
+	`
+
+	res, err := sm.DB.Exec(sqlStmt, id, hash)
+	if err != nil {
+		return "", err
+	}
+
+	if err := expectAffected(res); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Authenticate returns the station a token belongs to, or ErrInvalidCredentials if the station
+// does not exist, is deleted or has another token
+func (sm *StationManager) Authenticate(id int64, token string) (*Station, error) {
+	if token == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	sqlStmt := `
+	SELECT ` + stationColumns + `, token_hash FROM stations
+	WHERE ID = $1 AND deleted_at IS NULL;
+	`
+
+	var hash string
+	st, err := scanStation(sm.DB.QueryRow(sqlStmt, id), &hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashStationToken(token)), []byte(hash)) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+
+	return st, nil
+}
+
+// scanStation scans the stationColumns of a row, followed by any further columns into dest
+func scanStation(row rowScanner, dest ...interface{}) (*Station, error) {
+	var st Station
+	var elevation sql.NullFloat64
+	var deletedAt pq.NullTime
+	cols := []interface{}{&st.ID, &st.CityID, &st.Latitude, &st.Longitude, &elevation, &st.Owner, &st.Weight, &st.CreatedAt, &deletedAt}
+	if err := row.Scan(append(cols, dest...)...); err != nil {
+		return nil, err
+	}
+
+	if elevation.Valid {
+		st.Elevation = &elevation.Float64
+	}
+	if deletedAt.Valid {
+		st.DeletedAt = &deletedAt.Time
+	}
+
+	return &st, nil
+}
+
+// newStationToken generates a random station token along with its hash
+func newStationToken() (token, hash string, err error) {
+	b := make([]byte, stationTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashStationToken(token), nil
+}
+
+// hashStationToken returns the hex encoded SHA-256 hash a station token is stored as. Tokens are
+// random enough not to need a salt or a slow hash.
+func hashStationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewStationManager returns a new StationManager
+func NewStationManager(db *sql.DB) *StationManager {
+	return &StationManager{db}
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var stationRows = []string{"ID", "city_id", "latitude", "longitude", "elevation", "owner", "weight", "created_at", "deleted_at"}
+
+func Test_CanCreateStation(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		sm := NewStationManager(db)
+
+		mock.ExpectQuery("INSERT INTO stations").
+			WithArgs(1, 52.52, 13.405, 34.0, "acme", DefaultStationWeight, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(stationRows).AddRow(1, 1, 52.52, 13.405, 34.0, "acme", 1.0, time.Now(), nil))
+
+		elevation := 34.0
+		st, token, err := sm.Create(&NewStation{CityID: 1, Latitude: 52.52, Longitude: 13.405, Elevation: &elevation, Owner: "acme"})
+		r.NoError(err)
+		r.Equal(int64(1), st.ID)
+		r.Equal(34.0, *st.Elevation)
+		r.Nil(st.DeletedAt)
+		r.NotEmpty(token)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CannotCreateInvalidStation(t *testing.T) {
+	r := require.New(t)
+
+	err := (&NewStation{CityID: 1, Latitude: 91, Longitude: 13, Weight: -1}).Validate()
+	r.True(err.(*ValidationError).Has("latitude"))
+	r.True(err.(*ValidationError).Has("weight"))
+}
+
+func Test_CanAuthenticateStation(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		sm := NewStationManager(db)
+
+		token, hash, err := newStationToken()
+		r.NoError(err)
+
+		for i := 0; i < 2; i++ {
+			mock.ExpectQuery("SELECT (.+), token_hash FROM stations").WithArgs(1).WillReturnRows(
+				sqlmock.NewRows(append(stationRows, "token_hash")).AddRow(1, 1, 52.52, 13.405, nil, "", 1.0, time.Now(), nil, hash),
+			)
+		}
+
+		st, err := sm.Authenticate(1, token)
+		r.NoError(err)
+		r.Equal(int64(1), st.CityID)
+
+		st, err = sm.Authenticate(1, token+"x")
+		r.Nil(st)
+		r.Equal(ErrInvalidCredentials, err)
+
+		// a missing token is rejected without looking the station up
+		_, err = sm.Authenticate(1, "")
+		r.Equal(ErrInvalidCredentials, err)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CannotAuthenticateDeletedStation(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		sm := NewStationManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM stations WHERE ID = \\$1 AND deleted_at IS NULL").WithArgs(1).WillReturnError(sql.ErrNoRows)
+
+		_, err := sm.Authenticate(1, "token")
+		r.Equal(ErrInvalidCredentials, err)
+	}, t)
+}
+
+func Test_CanListStationsOfCityWithoutStations(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		sm := NewStationManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(stationRows).AddRow(nil, nil, nil, nil, nil, nil, nil, nil, nil),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WithArgs(404).WillReturnRows(sqlmock.NewRows(stationRows))
+
+		sts, err := sm.List(1)
+		r.NoError(err)
+		r.Empty(sts)
+
+		_, err = sm.List(404)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
+
+func Test_CanWeighForecastByStation(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		fm := NewForecastManager(db)
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("UTC"),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM (.+) LEFT JOIN stations s").
+			WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), DefaultStationWeight).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"min", "max", "timestamp", "count", "weight"}).
+					AddRow(10, 20, time.Now().Unix(), 1, 3.0).
+					AddRow(14, 24, time.Now().Unix(), 1, 1.0),
+			)
+
+		fc, err := fm.Get(1, &ForecastFilter{Weighted: true})
+		r.NoError(err)
+		r.Equal(int64(2), fc.Sample)
+		r.Equal(11.0, fc.Min)
+		r.Equal(21.0, fc.Max)
+	}, t)
+}
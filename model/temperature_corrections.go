@@ -0,0 +1,242 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CorrectionAction describes how a stored temperature was corrected
+type CorrectionAction string
+
+const (
+	// CorrectionUpdate corrects the values of a temperature
+	CorrectionUpdate CorrectionAction = "update"
+	// CorrectionDelete deletes a temperature
+	CorrectionDelete CorrectionAction = "delete"
+)
+
+// temperatureCorrectionColumns are the columns selected whenever a temperature correction is read from the database
+const temperatureCorrectionColumns = `ID, temperature_id, city_id, action, reason, corrected_at,
+	previous_min, previous_max, previous_timestamp, min, max, timestamp`
+
+// TemperatureUpdate describes the corrected values of a stored temperature. Min and Max are given
+// in Unit, defaulting to Celsius. A zero ObservedAt keeps the time the temperature was observed at.
+// Reason tells why the temperature was corrected.
+type TemperatureUpdate struct {
+	ID         int64
+	Min        float64
+	Max        float64
+	Unit       Unit
+	ObservedAt time.Time
+	Reason     string
+}
+
+// TemperatureCorrection describes a correction of a stored temperature, kept for auditing. Previous
+// holds the values of the temperature before the correction and Current its corrected values,
+// which are nil if the temperature was deleted.
+type TemperatureCorrection struct {
+	ID            int64
+	TemperatureID int64
+	CityID        int64
+	Action        CorrectionAction
+	Reason        string
+	CorrectedAt   time.Time
+	Previous      *Temperature
+	Current       *Temperature
+}
+
+// scanTemperatureCorrection scans a row of temperatureCorrectionColumns
+func scanTemperatureCorrection(row rowScanner) (*TemperatureCorrection, error) {
+	var tc TemperatureCorrection
+	var prev Temperature
+	var min, max sql.NullFloat64
+	var timestamp sql.NullInt64
+	err := row.Scan(&tc.ID, &tc.TemperatureID, &tc.CityID, &tc.Action, &tc.Reason, &tc.CorrectedAt,
+		&prev.Min, &prev.Max, &prev.Timestamp, &min, &max, &timestamp)
+	if err != nil {
+		return nil, err
+	}
+
+	prev.ID, prev.CityID = tc.TemperatureID, tc.CityID
+	tc.Previous = &prev
+	if timestamp.Valid {
+		tc.Current = &Temperature{
+			ID:        tc.TemperatureID,
+			CityID:    tc.CityID,
+			Min:       min.Float64,
+			Max:       max.Float64,
+			Timestamp: timestamp.Int64,
+		}
+	}
+
+	return &tc, nil
+}
+
+// Correct corrects the values of a temperature of an existing city, recording the correction.
+// A corrected temperature is no longer flagged as an outlier, and is kept rather than rolled up
+// once it outlives the retention policy of its city. ErrNotFound is returned if the temperature
+// does not exist, has already been rolled up or its city is deleted, and a *ValidationError if
+// the corrected values are invalid.
+func (tm *TemperatureManager) Correct(tu *TemperatureUpdate) (*TemperatureCorrection, error) {
+	if err := tu.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := tm.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlStmt := `
+	SELECT ` + temperatureColumns + ` FROM temperatures
+	WHERE ID = $1 AND EXISTS (SELECT 1 FROM cities WHERE cities.ID = temperatures.city_id AND cities.deleted_at IS NULL)
+	FOR UPDATE;
+	`
+	prev, err := scanTemperature(tx.QueryRow(sqlStmt, tu.ID))
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	timestamp := prev.Timestamp
+	if !tu.ObservedAt.IsZero() {
+		timestamp = tu.ObservedAt.Unix()
+	}
+
+	sqlStmt = `
+	UPDATE temperatures
+	SET min = $2, max = $3, timestamp = $4, flagged = false, outlier_score = NULL
+	WHERE ID = $1
+	RETURNING ` + temperatureColumns + `;
+	`
+	unit := tu.Unit
+	cur, err := scanTemperature(tx.QueryRow(sqlStmt, tu.ID,
+		Round(unit.ToCelsius(tu.Min), TemperatureDecimals), Round(unit.ToCelsius(tu.Max), TemperatureDecimals), timestamp))
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	tc, err := recordCorrection(tx, CorrectionUpdate, tu.Reason, prev, cur)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+// Delete deletes a temperature of an existing city, recording the deletion as a correction.
+// ErrNotFound is returned if the temperature does not exist, has already been rolled up or its
+// city is deleted.
+func (tm *TemperatureManager) Delete(id int64, reason string) (*TemperatureCorrection, error) {
+	var ve ValidationError
+	validateCorrectionReason(&ve, reason)
+	if err := ve.Err(); err != nil {
+		return nil, err
+	}
+
+	tx, err := tm.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlStmt := `
+	DELETE FROM temperatures
+	WHERE ID = $1 AND EXISTS (SELECT 1 FROM cities WHERE cities.ID = temperatures.city_id AND cities.deleted_at IS NULL)
+	RETURNING ` + temperatureColumns + `;
+	`
+	prev, err := scanTemperature(tx.QueryRow(sqlStmt, id))
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	tc, err := recordCorrection(tx, CorrectionDelete, reason, prev, nil)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+// Corrections returns the corrections of a temperature, most recent first. The corrections of a
+// deleted temperature are kept along with its city.
+func (tm *TemperatureManager) Corrections(id int64) ([]*TemperatureCorrection, error) {
+	sqlStmt := `
+	SELECT ` + temperatureCorrectionColumns + ` FROM temperature_corrections
+	WHERE temperature_id = $1
+	ORDER BY ID DESC;
+	`
+
+	rows, err := tm.DB.Query(sqlStmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	corrections := []*TemperatureCorrection{}
+	for rows.Next() {
+		tc, err := scanTemperatureCorrection(rows)
+		if err != nil {
+			return nil, err
+		}
+		corrections = append(corrections, tc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return corrections, nil
+}
+
+// recordCorrection records the correction of a temperature from prev to cur, which is nil if the
+// temperature was deleted
+func recordCorrection(tx *sql.Tx, action CorrectionAction, reason string, prev, cur *Temperature) (*TemperatureCorrection, error) {
+	var min, max sql.NullFloat64
+	var timestamp sql.NullInt64
+	if cur != nil {
+		min = sql.NullFloat64{Float64: cur.Min, Valid: true}
+		max = sql.NullFloat64{Float64: cur.Max, Valid: true}
+		timestamp = sql.NullInt64{Int64: cur.Timestamp, Valid: true}
+	}
+
+	sqlStmt := `
+	INSERT INTO temperature_corrections
+	(temperature_id, city_id, action, reason, previous_min, previous_max, previous_timestamp, min, max, timestamp)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	RETURNING ID, corrected_at;
+	`
+
+	tc := &TemperatureCorrection{
+		TemperatureID: prev.ID,
+		CityID:        prev.CityID,
+		Action:        action,
+		Reason:        reason,
+		Previous:      prev,
+		Current:       cur,
+	}
+	err := tx.QueryRow(sqlStmt, prev.ID, prev.CityID, string(action), reason,
+		prev.Min, prev.Max, prev.Timestamp, min, max, timestamp).Scan(&tc.ID, &tc.CorrectedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return tc, nil
+}
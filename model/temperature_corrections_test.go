@@ -0,0 +1,116 @@
+package model
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+var temperatureRows = []string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}
+
+func Test_CanCorrectTemperature(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		observed := time.Now().Add(-time.Hour).Unix()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM temperatures (.+) FOR UPDATE").WithArgs(7).WillReturnRows(
+			sqlmock.NewRows(temperatureRows).AddRow(7, 900, 905, observed, 1, observed, true, 597.01, nil),
+		)
+		mock.ExpectQuery("UPDATE temperatures").WithArgs(7, 10.0, 15.0, observed).WillReturnRows(
+			sqlmock.NewRows(temperatureRows).AddRow(7, 10, 15, observed, 1, observed, false, nil, nil),
+		)
+		mock.ExpectQuery("INSERT INTO temperature_corrections").
+			WithArgs(7, 1, "update", "typo", 900.0, 905.0, observed, 10.0, 15.0, observed).
+			WillReturnRows(sqlmock.NewRows([]string{"ID", "corrected_at"}).AddRow(1, time.Now()))
+		mock.ExpectCommit()
+
+		tc, err := tm.Correct(&TemperatureUpdate{ID: 7, Min: 50, Max: 59, Unit: Fahrenheit, Reason: "typo"})
+		r.NoError(err)
+		r.Equal(CorrectionUpdate, tc.Action)
+		r.Equal(900.0, tc.Previous.Min)
+		r.Equal(10.0, tc.Current.Min)
+		r.False(tc.Current.Flagged)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CannotCorrectNonExistentTemperature(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM temperatures (.+) FOR UPDATE").WithArgs(404).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		tc, err := tm.Correct(&TemperatureUpdate{ID: 404, Min: 10, Max: 15})
+		r.Nil(tc)
+		r.Equal(ErrNotFound, err)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CannotCorrectTemperatureWithInvalidValues(t *testing.T) {
+	r := require.New(t)
+
+	tu := &TemperatureUpdate{ID: 7, Min: 20, Max: 10, Reason: strings.Repeat("a", MaxCorrectionReasonLength+1)}
+	err := tu.Validate()
+	r.IsType(&ValidationError{}, err)
+	r.True(err.(*ValidationError).Has("min"))
+	r.True(err.(*ValidationError).Has("reason"))
+}
+
+func Test_CanDeleteTemperature(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		observed := time.Now().Unix()
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM temperatures").WithArgs(7).WillReturnRows(
+			sqlmock.NewRows(temperatureRows).AddRow(7, 10, 15, observed, 1, observed, false, nil, nil),
+		)
+		mock.ExpectQuery("INSERT INTO temperature_corrections").
+			WithArgs(7, 1, "delete", "duplicate", 10.0, 15.0, observed, nil, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"ID", "corrected_at"}).AddRow(2, time.Now()))
+		mock.ExpectCommit()
+
+		tc, err := tm.Delete(7, "duplicate")
+		r.NoError(err)
+		r.Equal(CorrectionDelete, tc.Action)
+		r.Nil(tc.Current)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CanListTemperatureCorrections(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		observed := time.Now().Unix()
+		mock.ExpectQuery("SELECT (.+) FROM temperature_corrections").WithArgs(7).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "temperature_id", "city_id", "action", "reason", "corrected_at",
+				"previous_min", "previous_max", "previous_timestamp", "min", "max", "timestamp"}).
+				AddRow(2, 7, 1, "delete", "duplicate", time.Now(), 10, 15, observed, nil, nil, nil).
+				AddRow(1, 7, 1, "update", "typo", time.Now(), 900, 905, observed, 10, 15, observed),
+		)
+
+		tcs, err := tm.Corrections(7)
+		r.NoError(err)
+		r.Len(tcs, 2)
+		r.Nil(tcs[0].Current)
+		r.Equal(15.0, tcs[1].Current.Max)
+		r.Equal(905.0, tcs[1].Previous.Max)
+	}, t)
+}
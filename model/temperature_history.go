@@ -0,0 +1,221 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// TemperatureHistoryQuery describes the resolution, time range and pagination of the history of
+// the temperatures of a city. An empty Resolution picks the finest resolution the retention
+// policy of the city keeps for the whole range. The range selects periods by the time they start
+// at; From is inclusive and To exclusive, and a zero time leaves its end of the range open. A
+// StationID other than 0 only aggregates the temperatures reported by that station.
+type TemperatureHistoryQuery struct {
+	CityID     int64
+	StationID  int64
+	Resolution Resolution
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Cursor     string
+}
+
+// TemperatureAggregate describes the temperatures of a city observed in the local hour or day
+// starting at the Unix time Timestamp: the lowest Min, the highest Max and the averages of both.
+// Flagged temperatures are not aggregated.
+type TemperatureAggregate struct {
+	Timestamp  int64
+	Min        float64
+	Max        float64
+	AverageMin float64
+	AverageMax float64
+	Sample     int64
+}
+
+// TemperatureHistory describes a page of the history of the temperatures of a city at a
+// resolution, and the cursor of the page following it
+type TemperatureHistory struct {
+	CityID     int64
+	Resolution Resolution
+	TimeZone   string
+	Aggregates []*TemperatureAggregate
+	NextCursor string
+}
+
+// historyCursor describes the position of the last period of a page of history
+type historyCursor struct {
+	CityID     int64      `json:"c"`
+	StationID  int64      `json:"s,omitempty"`
+	Resolution Resolution `json:"r"`
+	Timestamp  int64      `json:"t"`
+}
+
+// History returns a page of the history of the temperatures of a city, ordered by the periods
+// they were observed in. Every period is aggregated from whichever of the temperatures, hourly
+// aggregates and daily aggregates hold it, so the history is the same before and after it is
+// rolled up, as long as it is not requested at a finer resolution than it is kept at. ErrNotFound
+// is returned if the city does not exist or is deleted, and ErrInvalidCursor if the cursor is
+// malformed or belongs to another city, station or resolution.
+func (tm *TemperatureManager) History(hq *TemperatureHistoryQuery, defaults *RetentionPolicy, now time.Time) (*TemperatureHistory, error) {
+	limit := hq.Limit
+	if limit <= 0 {
+		limit = DefaultTemperatureLimit
+	}
+	if limit > MaxTemperatureLimit {
+		limit = MaxTemperatureLimit
+	}
+
+	var tz string
+	if err := tm.DB.QueryRow(`SELECT time_zone FROM cities WHERE ID = $1 AND deleted_at IS NULL;`, hq.CityID).Scan(&tz); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	res := hq.Resolution
+	if res == "" {
+		policy, err := tm.RetentionPolicy(hq.CityID, defaults)
+		if err != nil {
+			return nil, err
+		}
+		res = policy.resolution(hq.From, now)
+	}
+
+	var q query
+	tzArg := q.arg(tz)
+
+	// the conditions common to every tier, whose rows are grouped by the period they fall in
+	conds := []string{"city_id = " + q.arg(hq.CityID)}
+	if hq.StationID != 0 {
+		conds = append(conds, "station_id = "+q.arg(hq.StationID))
+	}
+	if !hq.From.IsZero() {
+		// no period starting at or after From holds an earlier temperature
+		conds = append(conds, "timestamp >= "+q.arg(hq.From.Unix()))
+	}
+	where := "WHERE " + strings.Join(conds, " AND ")
+
+	tiers := `
+		SELECT ` + periodStart(res, "timestamp", tzArg) + ` AS period, min, max, min AS avg_min, max AS avg_max, 1 AS count
+		FROM temperatures
+		` + where + ` AND NOT flagged
+		UNION ALL
+		SELECT ` + periodStart(res, "timestamp", tzArg) + `, min, max, avg_min, avg_max, count
+		FROM temperatures_hourly
+		` + where
+	if res == Daily {
+		tiers += `
+		UNION ALL
+		SELECT timestamp, min, max, avg_min, avg_max, count
+		FROM temperatures_daily
+		` + where
+	}
+
+	if !hq.From.IsZero() {
+		q.where("period >= " + q.arg(hq.From.Unix()))
+	}
+	if !hq.To.IsZero() {
+		q.where("period < " + q.arg(hq.To.Unix()))
+	}
+
+	if hq.Cursor != "" {
+		cur, err := decodeHistoryCursor(hq.Cursor)
+		if err != nil || cur.CityID != hq.CityID || cur.StationID != hq.StationID || cur.Resolution != res {
+			return nil, ErrInvalidCursor
+		}
+
+		q.where("period > " + q.arg(cur.Timestamp))
+	}
+
+	sqlStmt := `
+	SELECT period, MIN(min), MAX(max), SUM(avg_min * count) / SUM(count), SUM(avg_max * count) / SUM(count), SUM(count)
+	FROM (` + tiers + `
+	) AS tiers
+	` + q.whereClause() + `
+	GROUP BY period
+	ORDER BY period ASC
+	LIMIT ` + q.arg(limit+1) + `;
+	`
+
+	rows, err := tm.DB.Query(sqlStmt, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := &TemperatureHistory{
+		CityID:     hq.CityID,
+		Resolution: res,
+		TimeZone:   tz,
+		Aggregates: []*TemperatureAggregate{},
+	}
+	for rows.Next() {
+		var agg TemperatureAggregate
+		if err := rows.Scan(&agg.Timestamp, &agg.Min, &agg.Max, &agg.AverageMin, &agg.AverageMax, &agg.Sample); err != nil {
+			return nil, err
+		}
+
+		history.Aggregates = append(history.Aggregates, &agg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(history.Aggregates) > limit {
+		history.Aggregates = history.Aggregates[:limit]
+
+		history.NextCursor, err = encodeHistoryCursor(&historyCursor{
+			CityID:     hq.CityID,
+			StationID:  hq.StationID,
+			Resolution: res,
+			Timestamp:  history.Aggregates[limit-1].Timestamp,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return history, nil
+}
+
+// resolution returns the finest resolution the policy keeps every temperature observed since
+// from at, as of now, which is daily if from is zero
+func (p *RetentionPolicy) resolution(from, now time.Time) Resolution {
+	hourly := p.Hourly
+	if p.Raw > hourly {
+		hourly = p.Raw
+	}
+
+	if !from.IsZero() && !from.Before(now.Add(-hourly)) {
+		return Hourly
+	}
+
+	return Daily
+}
+
+func encodeHistoryCursor(cur *historyCursor) (string, error) {
+	b, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeHistoryCursor(s string) (*historyCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cur historyCursor
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, err
+	}
+
+	return &cur, nil
+}
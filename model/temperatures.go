@@ -2,50 +2,472 @@ package model
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
-// Temperature describes a the temperature of any given day
+// MaxTemperatureBatch is the maximum number of temperatures created in a single batch
+const MaxTemperatureBatch = 1000
+
+const (
+	// DefaultTemperatureLimit is the number of temperatures returned in a listing if no limit is given
+	DefaultTemperatureLimit = 100
+	// MaxTemperatureLimit is the maximum number of temperatures returned in a single listing
+	MaxTemperatureLimit = 1000
+)
+
+// MaxObservationSkew is how far in the future the observation time of a temperature may be,
+// allowing for clocks of sensors running slightly ahead
+const MaxObservationSkew = 5 * time.Minute
+
+// temperatureColumns are the columns selected whenever a temperature is read from the database
+const temperatureColumns = `ID, min, max, timestamp, city_id, received_at, flagged, outlier_score, station_id`
+
+// insertTemperature inserts a temperature of a city. Selecting the values from the city rejects
+// temperatures of cities that are deleted, returning no row.
+const insertTemperature = `
+	INSERT INTO temperatures 
+	(city_id, min, max, timestamp, received_at, flagged, outlier_score, station_id) 
+	SELECT ID, $2, $3, $4, $5, $6, $7, $8 FROM cities
+	WHERE ID = $1 AND deleted_at IS NULL
+	RETURNING ` + temperatureColumns + `;
+	`
+
+// Temperature describes a the temperature of any given day in Celsius, with up to
+// TemperatureDecimals decimals. Timestamp is the Unix time
+// the temperature was observed at, which forecasts are computed by, and ReceivedAt the Unix time
+// it was stored at. OutlierScore is the score the temperature was given against the recent
+// temperatures of its city, if it had enough of them; Flagged temperatures are outliers excluded
+// from forecasts. StationID is the station that reported the temperature, or 0 if it is not
+// attributed to any.
 type Temperature struct {
-	ID        int64
-	CityID    int64
-	Min       int64
-	Max       int64
-	Timestamp int64
+	ID           int64
+	CityID       int64
+	Min          float64
+	Max          float64
+	Timestamp    int64
+	ReceivedAt   int64
+	Flagged      bool
+	OutlierScore *float64
+	StationID    int64
 }
 
-// NewTemperature describes a new temperature to be added for a city
+// NewTemperature describes a new temperature to be added for a city. ObservedAt is the time the
+// temperature was observed at, defaulting to the time it is stored at, so that readings captured
+// offline can be uploaded later. Min and Max are given in Unit, defaulting to Celsius, and are
+// converted to Celsius to be stored, rounded to TemperatureDecimals decimals. StationID is the
+// station of the city that reported the temperature, if any, which the caller has to have
+// authenticated and checked to belong to the city.
 type NewTemperature struct {
-	CityID int64
-	Min    int64
-	Max    int64
+	CityID     int64
+	StationID  int64
+	Min        float64
+	Max        float64
+	Unit       Unit
+	ObservedAt time.Time
+}
+
+// TemperatureQuery describes the time range and pagination of a listing of the temperatures of
+// a city. From is inclusive and To exclusive; a zero time leaves its end of the range open. A
+// StationID other than 0 only lists the temperatures reported by that station. An empty
+// Resolution lists the temperatures as they were stored unless some of the range has been
+// rolled up.
+type TemperatureQuery struct {
+	CityID     int64
+	StationID  int64
+	Resolution Resolution
+	From       time.Time
+	To         time.Time
+	Limit      int
+	Cursor     string
+}
+
+// TemperaturePage describes a page of listed temperatures and the cursor of the page following
+// it. A page at the Raw resolution holds Temperatures; at any other it holds the Aggregates of
+// the local periods of the city in TimeZone.
+type TemperaturePage struct {
+	Resolution   Resolution
+	Temperatures []*Temperature
+	Aggregates   []*TemperatureAggregate
+	TimeZone     string
+	NextCursor   string
+}
+
+// temperatureCursor describes the position of the last temperature of a listed page
+type temperatureCursor struct {
+	CityID    int64 `json:"c"`
+	StationID int64 `json:"s,omitempty"`
+	Timestamp int64 `json:"t"`
+	ID        int64 `json:"i"`
+}
+
+// TemperatureResult describes the outcome of creating a single temperature of a batch, holding
+// either the created temperature or the reason it was not created
+type TemperatureResult struct {
+	Temperature *Temperature
+	Err         error
 }
 
 // TemperatureManager describes a temperature model manager
 type TemperatureManager struct {
 	DB *sql.DB
+
+	// OutlierAction is what is done with the outliers of cities without an outlier policy of their own
+	OutlierAction OutlierAction
 }
 
-// Create creates a temperature entry in the database
+// Create creates a temperature entry in the database, returning ErrNotFound if the city
+// does not exist or is deleted, a *ValidationError if the temperature is invalid, or an
+// *OutlierError if it is rejected as an outlier by the policy of the city
 func (tm *TemperatureManager) Create(tf *NewTemperature) (*Temperature, error) {
+	if err := tf.Validate(); err != nil {
+		return nil, err
+	}
 
-	var temp Temperature
+	insert := func(args ...interface{}) *sql.Row {
+		return tm.DB.QueryRow(insertTemperature, args...)
+	}
+
+	return tm.createTemperature(tm.DB, insert, tf, time.Now())
+}
+
+// List returns a page of the temperatures of a city observed within the time range of the query,
+// ordered by the time they were observed at. Temperatures observed at the same time are ordered
+// by ID, so that paging through them with the cursor of each page is stable. Unless a resolution
+// is given, a range of which some temperatures have been rolled up is listed as the history of
+// the coarsest tier holding them, so that no part of it is missing; the cursor of a page keeps
+// the resolution of the first. ErrNotFound is returned if the city does not exist or is
+// deleted, and ErrInvalidCursor if the cursor is malformed or belongs to another city.
+func (tm *TemperatureManager) List(tq *TemperatureQuery) (*TemperaturePage, error) {
+	res := tq.Resolution
+	if res == "" {
+		if tq.Cursor != "" {
+			res = cursorResolution(tq.Cursor)
+		} else {
+			var err error
+			if res, err = tm.rolledUpResolution(tq); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if res != Raw {
+		history, err := tm.History(&TemperatureHistoryQuery{
+			CityID:     tq.CityID,
+			StationID:  tq.StationID,
+			Resolution: res,
+			From:       tq.From,
+			To:         tq.To,
+			Limit:      tq.Limit,
+			Cursor:     tq.Cursor,
+		}, nil, time.Time{})
+		if err != nil {
+			return nil, err
+		}
+
+		return &TemperaturePage{
+			Resolution:   res,
+			Temperatures: []*Temperature{},
+			Aggregates:   history.Aggregates,
+			TimeZone:     history.TimeZone,
+			NextCursor:   history.NextCursor,
+		}, nil
+	}
+
+	limit := tq.Limit
+	if limit <= 0 {
+		limit = DefaultTemperatureLimit
+	}
+	if limit > MaxTemperatureLimit {
+		limit = MaxTemperatureLimit
+	}
+
+	var q query
+	q.where("city_id = " + q.arg(tq.CityID))
+	// the temperatures of a deleted city are not listed
+	q.where("EXISTS (SELECT 1 FROM cities WHERE cities.ID = temperatures.city_id AND cities.deleted_at IS NULL)")
+	if tq.StationID != 0 {
+		q.where("station_id = " + q.arg(tq.StationID))
+	}
+	if !tq.From.IsZero() {
+		q.where("timestamp >= " + q.arg(tq.From.Unix()))
+	}
+	if !tq.To.IsZero() {
+		q.where("timestamp < " + q.arg(tq.To.Unix()))
+	}
+
+	if tq.Cursor != "" {
+		cur, err := decodeTemperatureCursor(tq.Cursor)
+		if err != nil || cur.CityID != tq.CityID || cur.StationID != tq.StationID {
+			return nil, ErrInvalidCursor
+		}
+
+		q.where(fmt.Sprintf("(timestamp, ID) > (%s, %s)", q.arg(cur.Timestamp), q.arg(cur.ID)))
+	}
 
 	sqlStmt := `
-	INSERT INTO temperatures 
-	(city_id, min, max, timestamp) 
-	VALUES($1, $2, $3, $4) 
-	RETURNING ID, min, max, timestamp, city_id;
+	SELECT ` + temperatureColumns + ` FROM temperatures
+	` + q.whereClause() + `
+	ORDER BY timestamp ASC, ID ASC
+	LIMIT ` + q.arg(limit+1) + `;
+	`
+
+	rows, err := tm.DB.Query(sqlStmt, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &TemperaturePage{
+		Resolution:   Raw,
+		Temperatures: []*Temperature{},
+	}
+	for rows.Next() {
+		temp, err := scanTemperature(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Temperatures = append(page.Temperatures, temp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// an empty page tells apart a city without temperatures in the range from one that does not exist
+	if len(page.Temperatures) == 0 {
+		var exists bool
+		if err := tm.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM cities WHERE ID = $1 AND deleted_at IS NULL);`, tq.CityID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, ErrNotFound
+		}
+	}
+
+	if len(page.Temperatures) > limit {
+		page.Temperatures = page.Temperatures[:limit]
+
+		last := page.Temperatures[limit-1]
+		page.NextCursor, err = encodeTemperatureCursor(&temperatureCursor{
+			CityID:    tq.CityID,
+			StationID: tq.StationID,
+			Timestamp: last.Timestamp,
+			ID:        last.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// rolledUpResolution returns the coarsest resolution some temperatures within the range of the
+// query have been rolled up to, or Raw if none have
+func (tm *TemperatureManager) rolledUpResolution(tq *TemperatureQuery) (Resolution, error) {
+	var q query
+	q.where("city_id = " + q.arg(tq.CityID))
+	if tq.StationID != 0 {
+		q.where("station_id = " + q.arg(tq.StationID))
+	}
+	if !tq.From.IsZero() {
+		q.where("timestamp >= " + q.arg(tq.From.Unix()))
+	}
+	if !tq.To.IsZero() {
+		q.where("timestamp < " + q.arg(tq.To.Unix()))
+	}
+
+	sqlStmt := `
+	SELECT CASE
+	WHEN EXISTS (SELECT 1 FROM temperatures_daily ` + q.whereClause() + `) THEN ` + q.arg(string(Daily)) + `
+	WHEN EXISTS (SELECT 1 FROM temperatures_hourly ` + q.whereClause() + `) THEN ` + q.arg(string(Hourly)) + `
+	ELSE ` + q.arg(string(Raw)) + ` END;
 	`
-	if err := tm.DB.QueryRow(sqlStmt, tf.CityID, tf.Min, tf.Max, time.Now().Unix()).
-		Scan(&temp.ID, &temp.Min, &temp.Max, &temp.Timestamp, &temp.CityID); err != nil {
+
+	var res Resolution
+	if err := tm.DB.QueryRow(sqlStmt, q.args...).Scan(&res); err != nil {
+		return "", err
+	}
+
+	return res, nil
+}
+
+// cursorResolution returns the resolution of the page a cursor was returned with, which is Raw
+// for the cursor of a page of temperatures
+func cursorResolution(s string) Resolution {
+	if cur, err := decodeHistoryCursor(s); err == nil && cur.Resolution != "" {
+		return cur.Resolution
+	}
+
+	return Raw
+}
+
+// CreateBatch creates many temperatures in a single transaction, returning the result of each
+// in the order given. Unless partial is set the batch is all or nothing: if any temperature is
+// invalid or of a city that does not exist, none is stored, ErrBatchFailed is returned and the
+// temperatures that could have been stored fail with ErrBatchAborted. In partial mode every valid
+// temperature of an existing city is stored regardless of the others. Temperatures rejected as
+// outliers fail with an *OutlierError.
+func (tm *TemperatureManager) CreateBatch(nts []*NewTemperature, partial bool) ([]*TemperatureResult, error) {
+	results := make([]*TemperatureResult, len(nts))
+	failed := false
+	for i, nt := range nts {
+		results[i] = &TemperatureResult{}
+		if err := nt.Validate(); err != nil {
+			results[i].Err = err
+			failed = true
+		}
+	}
+
+	if failed && !partial {
+		return abortBatch(results), ErrBatchFailed
+	}
+
+	tx, err := tm.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.Prepare(insertTemperature)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for i, nt := range nts {
+		if results[i].Err != nil {
+			continue
+		}
+
+		temp, err := tm.createTemperature(tx, stmt.QueryRow, nt, now)
+		if err != nil {
+			if _, ok := err.(*OutlierError); !ok && err != ErrNotFound {
+				tx.Rollback()
+				return nil, err
+			}
+
+			results[i].Err = err
+			if !partial {
+				tx.Rollback()
+				return abortBatch(results), ErrBatchFailed
+			}
+			continue
+		}
+
+		results[i].Temperature = temp
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// createTemperature scores a valid new temperature against the recent temperatures of its city
+// and inserts it with insert, querying a row of insertTemperature, unless it is rejected
+func (tm *TemperatureManager) createTemperature(q queryer, insert func(args ...interface{}) *sql.Row, nt *NewTemperature, now time.Time) (*Temperature, error) {
+	min, max := nt.celsius(nt.Min), nt.celsius(nt.Max)
+	check, err := checkOutlier(q, nt.CityID, min, max, tm.OutlierAction)
+	if err != nil {
+		return nil, err
+	}
+
+	var station sql.NullInt64
+	if nt.StationID != 0 {
+		station = sql.NullInt64{Int64: nt.StationID, Valid: true}
+	}
+
+	temp, err := scanTemperature(insert(nt.CityID, min, max, nt.observedAt(now), now.Unix(), check.Flagged, check.Score, station))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 
+	return temp, nil
+}
+
+// scanTemperature scans the temperatureColumns of a row
+func scanTemperature(row rowScanner) (*Temperature, error) {
+	var temp Temperature
+	var score sql.NullFloat64
+	var station sql.NullInt64
+	if err := row.Scan(&temp.ID, &temp.Min, &temp.Max, &temp.Timestamp, &temp.CityID, &temp.ReceivedAt, &temp.Flagged, &score, &station); err != nil {
+		return nil, err
+	}
+
+	temp.StationID = station.Int64
+
+	if score.Valid {
+		temp.OutlierScore = &score.Float64
+	}
+
 	return &temp, nil
 }
 
+// observedAt returns the Unix time the temperature was observed at, which is the time it is
+// received at unless given
+func (nt *NewTemperature) observedAt(receivedAt time.Time) int64 {
+	if nt.ObservedAt.IsZero() {
+		return receivedAt.Unix()
+	}
+
+	return nt.ObservedAt.Unix()
+}
+
+// celsius converts a temperature given in the unit of the new temperature to Celsius, rounded to
+// the decimals temperatures are stored with
+func (nt *NewTemperature) celsius(v float64) float64 {
+	return Round(nt.Unit.ToCelsius(v), TemperatureDecimals)
+}
+
+// abortBatch marks every result of a batch that has not failed as aborted, dropping the
+// temperatures already created since they are rolled back
+func abortBatch(results []*TemperatureResult) []*TemperatureResult {
+	for _, res := range results {
+		res.Temperature = nil
+		if res.Err == nil {
+			res.Err = ErrBatchAborted
+		}
+	}
+
+	return results
+}
+
+func encodeTemperatureCursor(cur *temperatureCursor) (string, error) {
+	b, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeTemperatureCursor(s string) (*temperatureCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cur temperatureCursor
+	if err := json.Unmarshal(b, &cur); err != nil {
+		return nil, err
+	}
+
+	return &cur, nil
+}
+
 // NewTemperatureManager returns a new TemperatureManager
 func NewTemperatureManager(db *sql.DB) *TemperatureManager {
-	return &TemperatureManager{db}
+	return &TemperatureManager{
+		DB:            db,
+		OutlierAction: DefaultOutlierAction,
+	}
 }
@@ -9,6 +9,16 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// outlierRows are the columns of the policy and recent temperatures a new temperature is scored against
+var outlierRows = []string{"action", "threshold", "min", "max"}
+
+// expectNoOutlierHistory expects a new temperature to be scored against a city without any
+// temperatures and the default policy
+func expectNoOutlierHistory(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT (.+) FROM cities c").
+		WillReturnRows(sqlmock.NewRows(outlierRows).AddRow("accept", DefaultOutlierThreshold, nil, nil))
+}
+
 func Test_CanCreateTemperature(t *testing.T) {
 	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
 		r := require.New(t)
@@ -21,7 +31,9 @@ func Test_CanCreateTemperature(t *testing.T) {
 			Max:    29,
 		}
 
-		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id"}
+		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WithArgs(1, string(DefaultOutlierAction), DefaultOutlierThreshold, OutlierHistory).
+			WillReturnRows(sqlmock.NewRows(outlierRows).AddRow("accept", DefaultOutlierThreshold, nil, nil))
 		mock.ExpectQuery("INSERT INTO").WillReturnRows(
 			sqlmock.NewRows(expectedRows).AddRow(
 				1,
@@ -29,6 +41,10 @@ func Test_CanCreateTemperature(t *testing.T) {
 				nt.Max,
 				time.Now().Unix(),
 				nt.CityID,
+				time.Now().Unix(),
+				false,
+				nil,
+				nil,
 			),
 		)
 
@@ -53,7 +69,7 @@ func Test_CannotCreateTemperatureWithNonExistentCityID(t *testing.T) {
 			Max:    29,
 		}
 
-		mock.ExpectQuery("INSERT INTO").WillReturnError(ErrNotFound)
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WillReturnRows(sqlmock.NewRows(outlierRows))
 
 		temp, err := tm.Create(nt)
 		r.Error(err)
@@ -61,3 +77,237 @@ func Test_CannotCreateTemperatureWithNonExistentCityID(t *testing.T) {
 		r.Equal(err, ErrNotFound)
 	}, t)
 }
+
+func Test_CanCreateTemperatureBatch(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}
+		mock.ExpectBegin()
+		prep := mock.ExpectPrepare("INSERT INTO temperatures")
+		expectNoOutlierHistory(mock)
+		prep.ExpectQuery().WithArgs(1, 20.0, 25.0, sqlmock.AnyArg(), sqlmock.AnyArg(), false, nil, nil).WillReturnRows(
+			sqlmock.NewRows(expectedRows).AddRow(1, 20, 25, time.Now().Unix(), 1, time.Now().Unix(), false, nil, nil),
+		)
+		expectNoOutlierHistory(mock)
+		prep.ExpectQuery().WithArgs(2, 10.0, 12.0, sqlmock.AnyArg(), sqlmock.AnyArg(), false, nil, nil).WillReturnRows(
+			sqlmock.NewRows(expectedRows).AddRow(2, 10, 12, time.Now().Unix(), 2, time.Now().Unix(), false, nil, nil),
+		)
+		mock.ExpectCommit()
+
+		results, err := tm.CreateBatch([]*NewTemperature{
+			{CityID: 1, Min: 20, Max: 25},
+			{CityID: 2, Min: 10, Max: 12},
+		}, false)
+		r.NoError(err)
+		r.Len(results, 2)
+		r.Equal(int64(2), results[1].Temperature.CityID)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_TemperatureBatchIsRolledBackIfAnyCityDoesNotExist(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}
+		mock.ExpectBegin()
+		prep := mock.ExpectPrepare("INSERT INTO temperatures")
+		expectNoOutlierHistory(mock)
+		prep.ExpectQuery().WithArgs(1, 20.0, 25.0, sqlmock.AnyArg(), sqlmock.AnyArg(), false, nil, nil).WillReturnRows(
+			sqlmock.NewRows(expectedRows).AddRow(1, 20, 25, time.Now().Unix(), 1, time.Now().Unix(), false, nil, nil),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WithArgs(404, string(DefaultOutlierAction), DefaultOutlierThreshold, OutlierHistory).
+			WillReturnRows(sqlmock.NewRows(outlierRows))
+		mock.ExpectRollback()
+
+		results, err := tm.CreateBatch([]*NewTemperature{
+			{CityID: 1, Min: 20, Max: 25},
+			{CityID: 404, Min: 10, Max: 12},
+			{CityID: 3, Min: 10, Max: 12},
+		}, false)
+		r.Equal(ErrBatchFailed, err)
+		r.Nil(results[0].Temperature)
+		r.Equal(ErrBatchAborted, results[0].Err)
+		r.Equal(ErrNotFound, results[1].Err)
+		r.Equal(ErrBatchAborted, results[2].Err)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CanCreatePartialTemperatureBatch(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}
+		mock.ExpectBegin()
+		prep := mock.ExpectPrepare("INSERT INTO temperatures")
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WithArgs(404, string(DefaultOutlierAction), DefaultOutlierThreshold, OutlierHistory).
+			WillReturnRows(sqlmock.NewRows(outlierRows))
+		expectNoOutlierHistory(mock)
+		prep.ExpectQuery().WithArgs(1, 20.0, 25.0, sqlmock.AnyArg(), sqlmock.AnyArg(), false, nil, nil).WillReturnRows(
+			sqlmock.NewRows(expectedRows).AddRow(1, 20, 25, time.Now().Unix(), 1, time.Now().Unix(), false, nil, nil),
+		)
+		mock.ExpectCommit()
+
+		results, err := tm.CreateBatch([]*NewTemperature{
+			{CityID: 404, Min: 10, Max: 12},
+			{CityID: 1, Min: 30, Max: 25},
+			{CityID: 1, Min: 20, Max: 25},
+		}, true)
+		r.NoError(err)
+		r.Equal(ErrNotFound, results[0].Err)
+		r.IsType(&ValidationError{}, results[1].Err)
+		r.NotNil(results[2].Temperature)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CanCreateTemperatureObservedInThePast(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		observedAt := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+		nt := &NewTemperature{CityID: 1, Min: 25, Max: 29, ObservedAt: observedAt}
+
+		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}
+		expectNoOutlierHistory(mock)
+		mock.ExpectQuery("INSERT INTO temperatures").
+			WithArgs(1, 25.0, 29.0, observedAt.Unix(), sqlmock.AnyArg(), false, nil, nil).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).AddRow(1, 25, 29, observedAt.Unix(), 1, time.Now().Unix(), false, nil, nil),
+			)
+
+		temp, err := tm.Create(nt)
+		r.NoError(err)
+		r.Equal(observedAt.Unix(), temp.Timestamp)
+		r.True(temp.ReceivedAt > temp.Timestamp)
+	}, t)
+}
+
+func Test_CanPageThroughTemperaturesOfCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery(`SELECT CASE WHEN EXISTS \(SELECT 1 FROM temperatures_daily WHERE city_id = \$1 AND timestamp >= \$2\)`).
+			WithArgs(1, from.Unix(), "daily", "hourly", "raw").
+			WillReturnRows(sqlmock.NewRows([]string{"resolution"}).AddRow("raw"))
+
+		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}
+		mock.ExpectQuery(`SELECT (.+) FROM temperatures WHERE city_id = \$1 AND EXISTS (.+) AND timestamp >= \$2 ORDER BY timestamp ASC, ID ASC`).
+			WithArgs(1, from.Unix(), 3).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, 10, 20, from.Unix(), 1, from.Unix(), false, nil, nil).
+					AddRow(2, 11, 21, from.Unix()+60, 1, from.Unix(), false, nil, nil).
+					AddRow(3, 12, 22, from.Unix()+60, 1, from.Unix(), false, nil, nil),
+			)
+
+		page, err := tm.List(&TemperatureQuery{CityID: 1, From: from, Limit: 2})
+		r.NoError(err)
+		r.Equal(Raw, page.Resolution)
+		r.Len(page.Temperatures, 2)
+		r.NotEmpty(page.NextCursor)
+		cursor := page.NextCursor
+
+		mock.ExpectQuery(`SELECT (.+) FROM temperatures WHERE (.+) AND \(timestamp, ID\) > \(\$3, \$4\)`).
+			WithArgs(1, from.Unix(), from.Unix()+60, 2, 3).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(3, 12, 22, from.Unix()+60, 1, from.Unix(), false, nil, nil),
+			)
+
+		page, err = tm.List(&TemperatureQuery{CityID: 1, From: from, Limit: 2, Cursor: cursor})
+		r.NoError(err)
+		r.Len(page.Temperatures, 1)
+		r.Equal(int64(3), page.Temperatures[0].ID)
+		r.Empty(page.NextCursor)
+
+		// a cursor only pages through the temperatures of the city it was returned for
+		_, err = tm.List(&TemperatureQuery{CityID: 2, Cursor: cursor})
+		r.Equal(ErrInvalidCursor, err)
+	}, t)
+}
+
+func Test_CanListTemperaturesBeyondRawRetention(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		// the range starts before the raw retention, so its oldest temperatures are rolled up
+		to := time.Now().Truncate(time.Hour)
+		from := to.Add(-DefaultRawRetention - 3*24*time.Hour)
+		mock.ExpectQuery("SELECT CASE WHEN EXISTS (.+) temperatures_daily (.+) temperatures_hourly").
+			WithArgs(1, from.Unix(), to.Unix(), "daily", "hourly", "raw").
+			WillReturnRows(sqlmock.NewRows([]string{"resolution"}).AddRow("hourly"))
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("Europe/Berlin"),
+		)
+
+		historyRows := []string{"period", "min", "max", "avg_min", "avg_max", "count"}
+		mock.ExpectQuery("SELECT period, (.+) FROM temperatures (.+) UNION ALL (.+) FROM temperatures_hourly (.+) GROUP BY period").
+			WithArgs("Europe/Berlin", 1, from.Unix(), from.Unix(), to.Unix(), 2).
+			WillReturnRows(
+				sqlmock.NewRows(historyRows).
+					AddRow(from.Unix(), 8, 21, 10.5, 19.5, 4).
+					AddRow(from.Add(time.Hour).Unix(), 9, 20, 11, 18, 3),
+			)
+
+		page, err := tm.List(&TemperatureQuery{CityID: 1, From: from, To: to, Limit: 1})
+		r.NoError(err)
+		r.Equal(Hourly, page.Resolution)
+		r.Equal("Europe/Berlin", page.TimeZone)
+		r.Empty(page.Temperatures)
+		r.Len(page.Aggregates, 1)
+		r.Equal(int64(4), page.Aggregates[0].Sample)
+		r.NotEmpty(page.NextCursor)
+
+		// the next page keeps the resolution of the first, including the raw temperatures since
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("Europe/Berlin"),
+		)
+		mock.ExpectQuery("SELECT period, (.+) FROM temperatures (.+) UNION ALL (.+) FROM temperatures_hourly (.+) AND period > \\$6 GROUP BY period").
+			WithArgs("Europe/Berlin", 1, from.Unix(), from.Unix(), to.Unix(), from.Unix(), 2).
+			WillReturnRows(
+				sqlmock.NewRows(historyRows).
+					AddRow(from.Add(time.Hour).Unix(), 9, 20, 11, 18, 3),
+			)
+
+		page, err = tm.List(&TemperatureQuery{CityID: 1, From: from, To: to, Limit: 1, Cursor: page.NextCursor})
+		r.NoError(err)
+		r.Equal(Hourly, page.Resolution)
+		r.Len(page.Aggregates, 1)
+		r.Empty(page.NextCursor)
+		r.NoError(mock.ExpectationsWereMet())
+	}, t)
+}
+
+func Test_CannotListTemperaturesOfNonExistentCity(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		tm := NewTemperatureManager(db)
+
+		mock.ExpectQuery("SELECT CASE").WillReturnRows(sqlmock.NewRows([]string{"resolution"}).AddRow("raw"))
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}),
+		)
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(404).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		page, err := tm.List(&TemperatureQuery{CityID: 404})
+		r.Nil(page)
+		r.Equal(ErrNotFound, err)
+	}, t)
+}
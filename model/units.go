@@ -0,0 +1,76 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Unit describes the unit temperatures are given in. Temperatures are stored in Celsius.
+type Unit string
+
+const (
+	// Celsius is the canonical unit temperatures are stored in
+	Celsius Unit = "celsius"
+	// Fahrenheit is the unit of temperatures in the United States
+	Fahrenheit Unit = "fahrenheit"
+	// Kelvin is the SI unit of temperatures, starting at absolute zero
+	Kelvin Unit = "kelvin"
+)
+
+const (
+	// TemperatureDecimals is the number of decimals temperatures are stored with
+	TemperatureDecimals = 2
+	// DefaultForecastDecimals is the number of decimals forecast averages are rounded to unless
+	// configured otherwise
+	DefaultForecastDecimals = 1
+	// maxCelsius is the highest temperature in Celsius that can be stored
+	maxCelsius = 9999.99
+	// absoluteZero is the lowest possible temperature in Celsius
+	absoluteZero = -273.15
+)
+
+// ParseUnit parses the name of a unit or its symbol, e.g. "fahrenheit" or "F". An empty string
+// is taken to be Celsius.
+func ParseUnit(s string) (Unit, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "celsius", "c":
+		return Celsius, nil
+	case "fahrenheit", "f":
+		return Fahrenheit, nil
+	case "kelvin", "k":
+		return Kelvin, nil
+	default:
+		return "", fmt.Errorf("unit must be one of celsius, fahrenheit or kelvin, got %q", s)
+	}
+}
+
+// ToCelsius converts a temperature given in the unit to Celsius
+func (u Unit) ToCelsius(v float64) float64 {
+	switch u {
+	case Fahrenheit:
+		return (v - 32) * 5 / 9
+	case Kelvin:
+		return v + absoluteZero
+	default:
+		return v
+	}
+}
+
+// FromCelsius converts a temperature given in Celsius to the unit
+func (u Unit) FromCelsius(c float64) float64 {
+	switch u {
+	case Fahrenheit:
+		return c*9/5 + 32
+	case Kelvin:
+		return c - absoluteZero
+	default:
+		return c
+	}
+}
+
+// Round rounds a temperature half away from zero to the given number of decimals
+func Round(v float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(v*scale) / scale
+}
@@ -0,0 +1,40 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CanParseUnit(t *testing.T) {
+	r := require.New(t)
+
+	for s, want := range map[string]Unit{"": Celsius, "C": Celsius, "fahrenheit": Fahrenheit, " K ": Kelvin} {
+		u, err := ParseUnit(s)
+		r.NoError(err)
+		r.Equal(want, u)
+	}
+
+	_, err := ParseUnit("rankine")
+	r.Error(err)
+}
+
+func Test_CanConvertUnits(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal(0.0, Fahrenheit.ToCelsius(32))
+	r.InDelta(21.7, Fahrenheit.ToCelsius(71.06), 1e-9)
+	r.Equal(212.0, Fahrenheit.FromCelsius(100))
+	r.Equal(0.0, Kelvin.ToCelsius(273.15))
+	r.Equal(293.15, Kelvin.FromCelsius(20))
+	r.Equal(-5.5, Celsius.FromCelsius(-5.5))
+}
+
+func Test_CanRoundTemperatures(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal(21.7, Round(21.66666, 1))
+	r.Equal(21.67, Round(21.66666, 2))
+	r.Equal(-22.0, Round(-21.5, 0))
+	r.Equal(22.0, Round(21.5, 0))
+}
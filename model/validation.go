@@ -0,0 +1,361 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shaybix/weather-monster/timezone"
+)
+
+const (
+	// MaxAdminRegionLength is the maximum number of characters of an administrative region
+	MaxAdminRegionLength = 100
+	// MaxCallbackURLLength is the maximum number of characters of a webhook callback URL
+	MaxCallbackURLLength = 255
+	// MaxSelectorLength is the maximum number of characters of the label selector of a webhook
+	MaxSelectorLength = 255
+	// MaxCorrectionReasonLength is the maximum number of characters of the reason for correcting a temperature
+	MaxCorrectionReasonLength = 255
+)
+
+// FieldError describes why the value of a single field is invalid
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// ValidationError describes every invalid field of a value, so that all of them can be fixed at once
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+// Error lists the invalid fields along with their messages
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Field+" "+fe.Message)
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Add records that the value of field is invalid
+func (e *ValidationError) Add(field, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Has reports whether the value of field has been recorded as invalid
+func (e *ValidationError) Has(field string) bool {
+	for _, fe := range e.Errors {
+		if fe.Field == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Err returns e if any field is invalid, or nil otherwise
+func (e *ValidationError) Err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// Validate checks that every field of a new city can be stored, returning a *ValidationError
+// listing all invalid fields
+func (nc *NewCity) Validate() error {
+	var ve ValidationError
+	validateCityName(&ve, nc.Name)
+	validateLatitude(&ve, nc.Latitude)
+	validateLongitude(&ve, nc.Longitude)
+	validateCountryCode(&ve, nc.CountryCode)
+	validateAdminRegion(&ve, nc.AdminRegion)
+	if nc.TimeZone != "" {
+		validateTimeZone(&ve, nc.TimeZone)
+	}
+	validateLabels(&ve, "labels", nc.Labels)
+
+	return ve.Err()
+}
+
+// Validate checks that every field given in a city update can be stored, returning a
+// *ValidationError listing all invalid fields
+func (cu *CityUpdate) Validate() error {
+	var ve ValidationError
+	if cu.Name != nil {
+		validateCityName(&ve, *cu.Name)
+	}
+	if cu.Latitude != nil {
+		validateLatitude(&ve, *cu.Latitude)
+	}
+	if cu.Longitude != nil {
+		validateLongitude(&ve, *cu.Longitude)
+	}
+	if cu.CountryCode != nil {
+		validateCountryCode(&ve, *cu.CountryCode)
+	}
+	if cu.AdminRegion != nil {
+		validateAdminRegion(&ve, *cu.AdminRegion)
+	}
+	if cu.TimeZone != nil {
+		validateTimeZone(&ve, *cu.TimeZone)
+	}
+	validateLabels(&ve, "labels", cu.Labels)
+	validateLabels(&ve, "labels", cu.SetLabels)
+	for _, k := range cu.RemoveLabels {
+		if err := validateLabelKey(k); err != nil {
+			ve.Add("labels", "%v", err)
+		}
+	}
+
+	return ve.Err()
+}
+
+// Validate checks the filters of a city listing, returning a *ValidationError listing all invalid
+// fields
+func (cq *CityQuery) Validate() error {
+	var ve ValidationError
+	validateCountryCode(&ve, cq.CountryCode)
+
+	return ve.Err()
+}
+
+// Validate checks that a new temperature can be stored, returning a *ValidationError listing
+// all invalid fields
+func (nt *NewTemperature) Validate() error {
+	var ve ValidationError
+	if nt.CityID <= 0 {
+		ve.Add("city_id", "must be a positive ID")
+	}
+	if nt.StationID < 0 {
+		ve.Add("station_id", "must be a positive ID")
+	}
+	validateTemperature(&ve, nt.Min, nt.Max, nt.Unit, nt.ObservedAt)
+
+	return ve.Err()
+}
+
+// Validate checks that a new station can be created, returning a *ValidationError listing all
+// invalid fields
+func (ns *NewStation) Validate() error {
+	var ve ValidationError
+	if ns.CityID <= 0 {
+		ve.Add("city_id", "must be a positive ID")
+	}
+	validateLatitude(&ve, ns.Latitude)
+	validateLongitude(&ve, ns.Longitude)
+	if ns.Elevation != nil && !(*ns.Elevation >= -500 && *ns.Elevation <= 9000) {
+		ve.Add("elevation", "must be between -500 and 9000 metres, got %v", *ns.Elevation)
+	}
+	if len([]rune(ns.Owner)) > MaxStationOwnerLength {
+		ve.Add("owner", "must not be longer than %d characters", MaxStationOwnerLength)
+	}
+	if !(ns.Weight > 0 && ns.Weight <= MaxStationWeight) {
+		ve.Add("weight", "must be above 0 and at most %v, got %v", MaxStationWeight, ns.Weight)
+	}
+
+	return ve.Err()
+}
+
+// Validate checks that a new observation can be stored, returning a *ValidationError listing all
+// invalid fields
+func (no *NewObservation) Validate() error {
+	var ve ValidationError
+	if no.CityID <= 0 {
+		ve.Add("city_id", "must be a positive ID")
+	}
+
+	spec, ok := metricSpecs[no.Metric]
+	if !ok {
+		ve.Add("metric", "must be one of %s, got %q", metricNames(), no.Metric)
+	} else if _, err := no.Metric.ParseUnit(string(no.Unit)); err != nil {
+		ve.Add("unit", "must be one of %s, got %q", unitNames(spec.units), no.Unit)
+	}
+
+	if math.IsNaN(no.Value) || math.IsInf(no.Value, 0) {
+		ve.Add("value", "must be a finite number")
+	} else if ok && !ve.Has("unit") {
+		v := no.base()
+		if v < spec.min || v > spec.max || (spec.maxOpen && v == spec.max) {
+			bound := "at most"
+			if spec.maxOpen {
+				bound = "below"
+			}
+			ve.Add("value", "must be at least %v and %s %v %s", spec.min, bound, spec.max, spec.units[0])
+		}
+	}
+
+	if no.ObservedAt.After(time.Now().Add(MaxObservationSkew)) {
+		ve.Add("observed_at", "must not be more than %v in the future", MaxObservationSkew)
+	}
+
+	return ve.Err()
+}
+
+// Validate checks that the corrected values of a temperature can be stored, returning a
+// *ValidationError listing all invalid fields
+func (tu *TemperatureUpdate) Validate() error {
+	var ve ValidationError
+	validateTemperature(&ve, tu.Min, tu.Max, tu.Unit, tu.ObservedAt)
+	validateCorrectionReason(&ve, tu.Reason)
+
+	return ve.Err()
+}
+
+// Validate checks that an outlier policy can be stored, returning a *ValidationError listing
+// all invalid fields
+func (p *OutlierPolicy) Validate() error {
+	var ve ValidationError
+	switch p.Action {
+	case OutlierAccept, OutlierFlag, OutlierReject:
+	default:
+		ve.Add("action", "must be one of accept, flag or reject, got %q", p.Action)
+	}
+	if !(p.Threshold > 0) || math.IsInf(p.Threshold, 0) {
+		ve.Add("threshold", "must be a positive number, got %v", p.Threshold)
+	}
+
+	return ve.Err()
+}
+
+// Validate checks that a retention policy can be stored, returning a *ValidationError listing
+// all invalid fields
+func (p *RetentionPolicy) Validate() error {
+	var ve ValidationError
+	if p.Raw < MinRetention || p.Raw%time.Second != 0 {
+		ve.Add("raw", "must be whole seconds of at least %v, got %v", MinRetention, p.Raw)
+	}
+	if p.Hourly < MinRetention || p.Hourly%time.Second != 0 {
+		ve.Add("hourly", "must be whole seconds of at least %v, got %v", MinRetention, p.Hourly)
+	}
+	if p.Daily != 0 && (p.Daily < MinRetention || p.Daily%time.Second != 0) {
+		ve.Add("daily", "must be 0 or whole seconds of at least %v, got %v", MinRetention, p.Daily)
+	}
+
+	return ve.Err()
+}
+
+// Validate checks that a new webhook can be stored, returning a *ValidationError listing
+// all invalid fields
+func (nw *NewWebhook) Validate() error {
+	var ve ValidationError
+	switch {
+	case nw.Selector != "" && nw.CityID != 0:
+		ve.Add("selector", "must not be given along with city_id")
+	case nw.Selector != "":
+		if len(nw.Selector) > MaxSelectorLength {
+			ve.Add("selector", "must not be longer than %d characters", MaxSelectorLength)
+		} else if sel, err := ParseSelector(nw.Selector); err != nil {
+			ve.Add("selector", "%v", err)
+		} else if len(sel) == 0 {
+			ve.Add("selector", "must have at least one requirement")
+		}
+	case nw.CityID <= 0:
+		ve.Add("city_id", "must be a positive ID")
+	}
+	switch nw.Unit {
+	case "", Celsius, Fahrenheit, Kelvin:
+	default:
+		ve.Add("unit", "must be one of celsius, fahrenheit or kelvin, got %q", nw.Unit)
+	}
+
+	u, err := url.Parse(nw.CallbackURL)
+	switch {
+	case nw.CallbackURL == "":
+		ve.Add("callback_url", "must not be empty")
+	case len(nw.CallbackURL) > MaxCallbackURLLength:
+		ve.Add("callback_url", "must not be longer than %d characters", MaxCallbackURLLength)
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		ve.Add("callback_url", "must be an absolute http or https URL")
+	}
+
+	return ve.Err()
+}
+
+func validateTemperature(ve *ValidationError, min, max float64, unit Unit, observedAt time.Time) {
+	validTemperature := true
+	for _, f := range []struct {
+		field string
+		value float64
+	}{{"min", min}, {"max", max}} {
+		if math.IsNaN(f.value) || math.IsInf(f.value, 0) {
+			ve.Add(f.field, "must be a finite number")
+			validTemperature = false
+		}
+	}
+	switch unit {
+	case "", Celsius, Fahrenheit, Kelvin:
+		if !validTemperature {
+			break
+		}
+		if Round(unit.ToCelsius(min), TemperatureDecimals) < absoluteZero {
+			ve.Add("min", "must not be below absolute zero")
+		}
+		if Round(unit.ToCelsius(max), TemperatureDecimals) > maxCelsius {
+			ve.Add("max", "must not be above %v degrees Celsius", maxCelsius)
+		}
+	default:
+		ve.Add("unit", "must be one of celsius, fahrenheit or kelvin, got %q", unit)
+	}
+	if validTemperature && min > max {
+		ve.Add("min", "must not be greater than max %v", max)
+	}
+	if observedAt.After(time.Now().Add(MaxObservationSkew)) {
+		ve.Add("observed_at", "must not be more than %v in the future", MaxObservationSkew)
+	}
+}
+
+func validateCorrectionReason(ve *ValidationError, reason string) {
+	if len([]rune(reason)) > MaxCorrectionReasonLength {
+		ve.Add("reason", "must not be longer than %d characters", MaxCorrectionReasonLength)
+	}
+}
+
+func validateCityName(ve *ValidationError, name string) {
+	switch {
+	case strings.TrimSpace(name) == "":
+		ve.Add("name", "must not be empty")
+	case len([]rune(name)) > MaxCityNameLength:
+		ve.Add("name", "must not be longer than %d characters", MaxCityNameLength)
+	}
+}
+
+func validateLatitude(ve *ValidationError, lat float64) {
+	if !(lat >= -90 && lat <= 90) {
+		ve.Add("latitude", "must be between -90 and 90, got %v", lat)
+	}
+}
+
+func validateLongitude(ve *ValidationError, lon float64) {
+	if !(lon >= -180 && lon <= 180) {
+		ve.Add("longitude", "must be between -180 and 180, got %v", lon)
+	}
+}
+
+func validateCountryCode(ve *ValidationError, code string) {
+	if code == "" {
+		return
+	}
+
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		ve.Add("country_code", "must be an ISO 3166-1 alpha-2 code, got %q", code)
+	}
+}
+
+func validateAdminRegion(ve *ValidationError, region string) {
+	if len([]rune(region)) > MaxAdminRegionLength {
+		ve.Add("admin_region", "must not be longer than %d characters", MaxAdminRegionLength)
+	}
+}
+
+func validateTimeZone(ve *ValidationError, tz string) {
+	if err := timezone.Validate(tz); err != nil {
+		ve.Add("time_zone", "must be an IANA time zone, got %q", tz)
+	}
+}
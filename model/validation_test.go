@@ -0,0 +1,109 @@
+package model
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewCityValidationListsAllInvalidFields(t *testing.T) {
+	r := require.New(t)
+
+	err := (&NewCity{Name: " ", Latitude: 500, Longitude: 13.4, CountryCode: "DEU", TimeZone: "Mars/Olympus"}).Validate()
+	r.Error(err)
+
+	ve, ok := err.(*ValidationError)
+	r.True(ok)
+	r.Len(ve.Errors, 4)
+	r.True(ve.Has("name"))
+	r.True(ve.Has("latitude"))
+	r.True(ve.Has("country_code"))
+	r.True(ve.Has("time_zone"))
+	r.False(ve.Has("longitude"))
+
+	r.NoError((&NewCity{Name: "Berlin", Latitude: 52.52, Longitude: 13.405, CountryCode: "DE"}).Validate())
+}
+
+func Test_CityUpdateValidationChecksOnlyGivenFields(t *testing.T) {
+	r := require.New(t)
+
+	longitude := -181.0
+	err := (&CityUpdate{ID: 1, Longitude: &longitude}).Validate()
+	r.Error(err)
+	r.Equal([]*FieldError{{Field: "longitude", Message: "must be between -180 and 180, got -181"}}, err.(*ValidationError).Errors)
+
+	name := "Potsdam"
+	r.NoError((&CityUpdate{ID: 1, Name: &name}).Validate())
+}
+
+func Test_NewTemperatureValidationRejectsMinAboveMax(t *testing.T) {
+	r := require.New(t)
+
+	err := (&NewTemperature{CityID: 1, Min: 30, Max: 20}).Validate()
+	r.Error(err)
+	r.True(err.(*ValidationError).Has("min"))
+
+	r.NoError((&NewTemperature{CityID: 1, Min: 20, Max: 20}).Validate())
+}
+
+func Test_NewWebhookValidationRejectsRelativeCallbackURL(t *testing.T) {
+	r := require.New(t)
+
+	err := (&NewWebhook{CityID: 0, CallbackURL: "example.com/hook"}).Validate()
+	r.Error(err)
+	r.True(err.(*ValidationError).Has("city_id"))
+	r.True(err.(*ValidationError).Has("callback_url"))
+
+	r.NoError((&NewWebhook{CityID: 1, CallbackURL: "https://example.com/hook"}).Validate())
+}
+
+func Test_NewWebhookValidationRequiresEitherCityOrSelector(t *testing.T) {
+	r := require.New(t)
+
+	err := (&NewWebhook{CityID: 1, Selector: "env=prod", CallbackURL: "https://example.com/hook"}).Validate()
+	r.Error(err)
+	r.True(err.(*ValidationError).Has("selector"))
+
+	err = (&NewWebhook{Selector: "env in prod", CallbackURL: "https://example.com/hook"}).Validate()
+	r.Error(err)
+	r.True(err.(*ValidationError).Has("selector"))
+
+	r.NoError((&NewWebhook{Selector: "env=prod", CallbackURL: "https://example.com/hook"}).Validate())
+}
+
+func Test_NewTemperatureValidationRejectsObservationInTheFuture(t *testing.T) {
+	r := require.New(t)
+
+	err := (&NewTemperature{CityID: 1, Min: 20, Max: 25, ObservedAt: time.Now().Add(time.Hour)}).Validate()
+	r.Error(err)
+	r.True(err.(*ValidationError).Has("observed_at"))
+
+	r.NoError((&NewTemperature{CityID: 1, Min: 20, Max: 25, ObservedAt: time.Now().Add(time.Minute)}).Validate())
+	r.NoError((&NewTemperature{CityID: 1, Min: 20, Max: 25, ObservedAt: time.Now().AddDate(-1, 0, 0)}).Validate())
+}
+
+func Test_NewTemperatureValidationRejectsMinBelowAbsoluteZero(t *testing.T) {
+	r := require.New(t)
+
+	err := (&NewTemperature{CityID: 1, Min: -500, Max: -400, Unit: Fahrenheit}).Validate()
+	r.Error(err)
+	r.True(err.(*ValidationError).Has("min"))
+
+	err = (&NewTemperature{CityID: 1, Min: 20, Max: 25, Unit: "rankine"}).Validate()
+	r.Error(err)
+	r.True(err.(*ValidationError).Has("unit"))
+
+	r.NoError((&NewTemperature{CityID: 1, Min: 0, Max: 300, Unit: Kelvin}).Validate())
+}
+
+func Test_NewTemperatureValidationRejectsNonFiniteValues(t *testing.T) {
+	r := require.New(t)
+
+	err := (&NewTemperature{CityID: 1, Min: math.NaN(), Max: math.Inf(1)}).Validate()
+	r.Error(err)
+	r.Len(err.(*ValidationError).Errors, 2)
+
+	r.NoError((&NewTemperature{CityID: 1, Min: 21.7, Max: 21.75}).Validate())
+}
@@ -2,22 +2,31 @@ package model
 
 import (
 	"database/sql"
-	"log"
 
 	"github.com/lib/pq"
 )
 
-// Webhook describes a webhook for subscribing to a city's temperatures
+// webhookColumns are the columns returned whenever a webhook is written to the database
+const webhookColumns = `ID, city_id, callback_url, selector, unit`
+
+// Webhook describes a webhook for subscribing to temperatures, either those of a single city or
+// those of every city its label selector matches. CityID is 0 for a webhook with a selector.
+// Temperatures are posted to it in Unit.
 type Webhook struct {
 	ID          int64
 	CityID      int64
+	Selector    string
 	CallbackURL string
+	Unit        Unit
 }
 
-// NewWebhook describes a new webhook to be created
+// NewWebhook describes a new webhook to be created, targeting either CityID or every city
+// matching Selector. Unit defaults to Celsius.
 type NewWebhook struct {
 	CityID      int64
+	Selector    string
 	CallbackURL string
+	Unit        Unit
 }
 
 // WebhookManager describes a webhook model manager
@@ -25,17 +34,60 @@ type WebhookManager struct {
 	db *sql.DB
 }
 
-// Create creates a new webhook for a given city
+// scanWebhook scans a row of webhookColumns followed by any extra dest
+func scanWebhook(row rowScanner, dest ...interface{}) (*Webhook, error) {
+	var wh Webhook
+	var cityID sql.NullInt64
+	var selector sql.NullString
+	if err := row.Scan(append([]interface{}{&wh.ID, &cityID, &wh.CallbackURL, &selector, &wh.Unit}, dest...)...); err != nil {
+		return nil, err
+	}
+
+	wh.CityID = cityID.Int64
+	wh.Selector = selector.String
+	return &wh, nil
+}
+
+// Create creates a new webhook for a given city or label selector, returning a *ValidationError
+// if it is invalid
 func (w *WebhookManager) Create(nw *NewWebhook) (*Webhook, error) {
+	if err := nw.Validate(); err != nil {
+		return nil, err
+	}
 
-	var wh Webhook
-	sqlStmt := `
-	INSERT INTO webhooks 
-	(city_id, callback_url) 
-	VALUES($1, $2)
-	RETURNING ID, city_id, callback_url;`
-	if err := w.db.QueryRow(sqlStmt, nw.CityID, nw.CallbackURL).
-		Scan(&wh.ID, &wh.CityID, &wh.CallbackURL); err != nil {
+	unit := nw.Unit
+	if unit == "" {
+		unit = Celsius
+	}
+
+	var row *sql.Row
+	if nw.Selector != "" {
+		sel, err := ParseSelector(nw.Selector)
+		if err != nil {
+			return nil, err
+		}
+
+		sqlStmt := `
+		INSERT INTO webhooks 
+		(selector, callback_url, unit, requirements) 
+		VALUES ($1, $2, $3, $4)
+		RETURNING ` + webhookColumns + `;`
+		row = w.db.QueryRow(sqlStmt, nw.Selector, nw.CallbackURL, unit, sel)
+	} else {
+		sqlStmt := `
+		INSERT INTO webhooks 
+		(city_id, callback_url, unit) 
+		SELECT ID, $2, $3 FROM cities
+		WHERE ID = $1 AND deleted_at IS NULL
+		RETURNING ` + webhookColumns + `;`
+		row = w.db.QueryRow(sqlStmt, nw.CityID, nw.CallbackURL, unit)
+	}
+
+	wh, err := scanWebhook(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		if pgerr, ok := err.(*pq.Error); ok {
 			if pgerr.Code == "23505" {
 				return nil, ErrAlreadyExists
@@ -44,36 +96,47 @@ func (w *WebhookManager) Create(nw *NewWebhook) (*Webhook, error) {
 		return nil, err
 	}
 
-	return &wh, nil
+	return wh, nil
 }
 
-// Get gets all webhooks associated with a city
+// Get gets all webhooks targeting a city, both those created for it and those whose selector
+// matches its labels, returning ErrNotFound if there are none. The requirements of selectors are
+// matched the same as Selector.Matches does.
 func (w *WebhookManager) Get(cityID int64) ([]*Webhook, error) {
-
-	var webhooks []*Webhook
 	sqlStmt := `
-	SELECT * FROM webhooks 
-	WHERE city_id = $1
-	RETURNING ID, city_id, callback_url;`
+	SELECT w.ID, w.city_id, w.callback_url, w.selector, w.unit FROM webhooks w
+	JOIN cities c ON c.ID = $1 AND c.deleted_at IS NULL
+	WHERE w.city_id = c.ID OR (w.requirements IS NOT NULL AND NOT EXISTS (
+		SELECT 1 FROM jsonb_to_recordset(w.requirements) AS r ("key" TEXT, "operator" TEXT, "values" JSONB)
+		WHERE NOT COALESCE(CASE r."operator"
+			WHEN '=' THEN c.labels @> jsonb_build_object(r."key", r."values" -> 0)
+			WHEN '!=' THEN NOT c.labels @> jsonb_build_object(r."key", r."values" -> 0)
+			WHEN 'in' THEN r."values" ? (c.labels ->> r."key")
+			WHEN 'notin' THEN NOT COALESCE(r."values" ? (c.labels ->> r."key"), false)
+			WHEN 'exists' THEN c.labels ? r."key"
+			WHEN '!' THEN NOT c.labels ? r."key"
+		END, false)
+	))
+	ORDER BY w.ID;`
 
 	rows, err := w.db.Query(sqlStmt, cityID)
 	if err != nil {
-		if err, ok := err.(*pq.Error); ok {
-			if err.Code == "20000" {
-				return nil, ErrNotFound
-			}
-		}
 		return nil, err
 	}
+	defer rows.Close()
 
+	var webhooks []*Webhook
 	for rows.Next() {
-		var wh Webhook
-		if err := rows.Scan(&wh.ID, &wh.CityID, &wh.CallbackURL); err != nil {
-			log.Println(err)
-			continue
+		wh, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
 		}
 
-		webhooks = append(webhooks, &wh)
+		webhooks = append(webhooks, wh)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
 	if len(webhooks) == 0 {
@@ -85,23 +148,20 @@ func (w *WebhookManager) Get(cityID int64) ([]*Webhook, error) {
 
 // Delete deletes a webhook
 func (w *WebhookManager) Delete(id int64) (*Webhook, error) {
-
-	var wh Webhook
 	sqlStmt := `
 	DELETE FROM webhooks 
 	WHERE ID = $1
-	RETURNING ID, city_id, callback_url;`
-	if err := w.db.QueryRow(sqlStmt, id).
-		Scan(&wh.ID, &wh.CityID, &wh.CallbackURL); err != nil {
-		if err, ok := err.(*pq.Error); ok {
-			if err.Code == "20000" {
-				return nil, ErrNotFound
-			}
+	RETURNING ` + webhookColumns + `;`
+
+	wh, err := scanWebhook(w.db.QueryRow(sqlStmt, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
 		}
 		return nil, err
 	}
 
-	return &wh, nil
+	return wh, nil
 }
 
 // NewWebhookManager returns a new WebhookManager
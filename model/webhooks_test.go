@@ -2,6 +2,7 @@ package model
 
 import (
 	"database/sql"
+	"regexp"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -19,10 +20,10 @@ func Test_CanCreateWebhook(t *testing.T) {
 			CallbackURL: "http://callback-url.com/callback",
 		}
 
-		expectedRows := []string{"ID", "city_id", "callback_url"}
+		expectedRows := []string{"ID", "city_id", "callback_url", "selector", "unit"}
 		mock.ExpectQuery("INSERT INTO webhooks").WillReturnRows(
 			sqlmock.NewRows(expectedRows).
-				AddRow(1, nw.CityID, nw.CallbackURL),
+				AddRow(1, nw.CityID, nw.CallbackURL, nil, "celsius"),
 		)
 
 		wh, err := wm.Create(nw)
@@ -58,10 +59,10 @@ func Test_CanDeleteWebhook(t *testing.T) {
 		r := require.New(t)
 		wm := NewWebhookManager(db)
 
-		expectedRows := []string{"ID", "city_id", "callback_url"}
+		expectedRows := []string{"ID", "city_id", "callback_url", "selector", "unit"}
 		mock.ExpectQuery("DELETE FROM webhooks").WillReturnRows(
 			sqlmock.NewRows(expectedRows).
-				AddRow(1, 1, "example.com/callback"),
+				AddRow(1, 1, "example.com/callback", nil, "celsius"),
 		)
 
 		wh, err := wm.Delete(1)
@@ -82,3 +83,89 @@ func Test_CannotDeleteWebhookThatDoesNotExist(t *testing.T) {
 		r.Equal(ErrNotFound, err)
 	}, t)
 }
+
+func Test_CanCreateWebhookForSelector(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		wm := NewWebhookManager(db)
+
+		nw := &NewWebhook{
+			Selector:    "env=prod",
+			CallbackURL: "http://callback-url.com/callback",
+		}
+
+		expectedRows := []string{"ID", "city_id", "callback_url", "selector", "unit"}
+		mock.ExpectQuery(`INSERT INTO webhooks \(selector, callback_url, unit, requirements\) VALUES`).
+			WithArgs(nw.Selector, nw.CallbackURL, "celsius", `[{"key":"env","operator":"=","values":["prod"]}]`).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, nil, nw.CallbackURL, nw.Selector, "celsius"),
+			)
+
+		wh, err := wm.Create(nw)
+		r.NoError(err)
+		r.Equal(int64(0), wh.CityID)
+		r.Equal("env=prod", wh.Selector)
+	}, t)
+}
+
+func Test_CanGetWebhooksOfCityAndMatchingSelectors(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		wm := NewWebhookManager(db)
+
+		expectedRows := []string{"ID", "city_id", "callback_url", "selector", "unit"}
+		mock.ExpectQuery(`SELECT (.+) FROM webhooks w JOIN cities c (.+) WHERE w.city_id = c.ID OR \(w.requirements IS NOT NULL AND NOT EXISTS (.+) c.labels @> (.+) ORDER BY w.ID`).
+			WithArgs(1).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, 1, "http://example.com/city", nil, "celsius").
+					AddRow(2, nil, "http://example.com/prod", "env=prod", "celsius"),
+			)
+
+		whs, err := wm.Get(1)
+		r.NoError(err)
+		r.Len(whs, 2)
+		r.Equal(int64(1), whs[0].ID)
+		r.Equal(int64(2), whs[1].ID)
+		r.Equal("env=prod", whs[1].Selector)
+	}, t)
+}
+
+func Test_CannotGetWebhooksOfCityWithoutAny(t *testing.T) {
+	withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		r := require.New(t)
+
+		wm := NewWebhookManager(db)
+
+		mock.ExpectQuery("SELECT (.+) FROM webhooks").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "city_id", "callback_url", "selector", "unit"}),
+		)
+
+		whs, err := wm.Get(1)
+		r.Equal(ErrNotFound, err)
+		r.Nil(whs)
+	}, t)
+}
+
+func Test_WebhooksAreMatchedOnEverySelectorOperator(t *testing.T) {
+	for _, op := range []SelectorOperator{Equals, NotEquals, In, NotIn, Exists, DoesNotExist} {
+		withTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+			r := require.New(t)
+
+			wm := NewWebhookManager(db)
+
+			mock.ExpectQuery(`CASE r."operator" (.*)WHEN '` + regexp.QuoteMeta(string(op)) + `' THEN (.+) END, false\)`).
+				WithArgs(1).
+				WillReturnRows(
+					sqlmock.NewRows([]string{"ID", "city_id", "callback_url", "selector", "unit"}).
+						AddRow(1, nil, "http://example.com/prod", "env=prod", "celsius"),
+				)
+
+			_, err := wm.Get(1)
+			r.NoError(err, op)
+		}, t)
+	}
+}
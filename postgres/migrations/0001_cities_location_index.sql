@@ -0,0 +1,5 @@
+-- Indexes the location of cities for nearby-city searches.
+CREATE EXTENSION IF NOT EXISTS cube;
+CREATE EXTENSION IF NOT EXISTS earthdistance;
+
+CREATE INDEX IF NOT EXISTS cities_location_idx ON cities USING gist (ll_to_earth(latitude, longitude));
@@ -0,0 +1,2 @@
+-- Indexes the coordinates of cities for bounding box and polygon searches.
+CREATE INDEX IF NOT EXISTS cities_latitude_longitude_idx ON cities (latitude, longitude);
@@ -0,0 +1,8 @@
+-- Qualifies cities by country and administrative region so that cities sharing a name can
+-- be told apart. Existing cities are left without a country or region, which keeps them
+-- unique by name until they are qualified.
+ALTER TABLE cities ADD COLUMN country_code VARCHAR(2) NOT NULL DEFAULT '';
+ALTER TABLE cities ADD COLUMN admin_region VARCHAR(100) NOT NULL DEFAULT '';
+
+ALTER TABLE cities DROP CONSTRAINT cities_name_key;
+ALTER TABLE cities ADD CONSTRAINT cities_name_country_code_admin_region_key UNIQUE (name, country_code, admin_region);
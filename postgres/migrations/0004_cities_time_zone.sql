@@ -0,0 +1,3 @@
+-- Gives every city the time zone its days are aggregated in. Existing cities default to UTC
+-- and should be updated with their actual time zone.
+ALTER TABLE cities ADD COLUMN time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC';
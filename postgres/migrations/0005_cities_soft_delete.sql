@@ -0,0 +1,6 @@
+-- Deletes cities softly by marking them as deleted. Only cities that are not deleted have to be
+-- unique, allowing a deleted city to be created anew.
+ALTER TABLE cities ADD COLUMN deleted_at TIMESTAMPTZ;
+
+ALTER TABLE cities DROP CONSTRAINT cities_name_country_code_admin_region_key;
+CREATE UNIQUE INDEX cities_name_country_code_admin_region_key ON cities (name, country_code, admin_region) WHERE deleted_at IS NULL;
@@ -0,0 +1,39 @@
+-- Records the previous and new values of a city every time its version changes.
+CREATE TABLE city_revisions (
+    ID BIGSERIAL PRIMARY KEY,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    version VARCHAR(40) NOT NULL,
+    previous_version VARCHAR(40) NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    country_code VARCHAR(2) NOT NULL,
+    admin_region VARCHAR(100) NOT NULL,
+    time_zone VARCHAR(64) NOT NULL,
+    previous_name VARCHAR(50) NOT NULL,
+    previous_latitude REAL NOT NULL,
+    previous_longitude REAL NOT NULL,
+    previous_country_code VARCHAR(2) NOT NULL,
+    previous_admin_region VARCHAR(100) NOT NULL,
+    previous_time_zone VARCHAR(64) NOT NULL,
+    revised_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (city_id, version)
+);
+
+CREATE FUNCTION record_city_revision() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO city_revisions
+    (city_id, version, previous_version,
+        name, latitude, longitude, country_code, admin_region, time_zone,
+        previous_name, previous_latitude, previous_longitude, previous_country_code, previous_admin_region, previous_time_zone)
+    VALUES (NEW.ID, NEW.version, OLD.version,
+        NEW.name, NEW.latitude, NEW.longitude, NEW.country_code, NEW.admin_region, NEW.time_zone,
+        OLD.name, OLD.latitude, OLD.longitude, OLD.country_code, OLD.admin_region, OLD.time_zone);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER cities_revision_trigger
+AFTER UPDATE OF version ON cities
+FOR EACH ROW WHEN (OLD.version IS DISTINCT FROM NEW.version)
+EXECUTE PROCEDURE record_city_revision();
@@ -0,0 +1,13 @@
+-- Groups cities into named regions. A city may be a member of any number of regions.
+CREATE TABLE regions (
+    ID BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE
+);
+
+CREATE TABLE region_cities (
+    region_id BIGINT NOT NULL REFERENCES regions (ID) ON DELETE CASCADE,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    PRIMARY KEY (region_id, city_id)
+);
+
+CREATE INDEX region_cities_city_id_idx ON region_cities (city_id);
@@ -0,0 +1,11 @@
+-- Labels cities with free-form key/value pairs, which listings, forecasts and webhooks select
+-- cities by. A webhook targets either a single city or every city its selector matches.
+ALTER TABLE cities ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX cities_labels_idx ON cities USING gin (labels);
+
+ALTER TABLE webhooks ALTER COLUMN city_id DROP NOT NULL;
+ALTER TABLE webhooks ADD COLUMN selector VARCHAR(255);
+ALTER TABLE webhooks ADD CONSTRAINT webhooks_target_check CHECK ((city_id IS NULL) <> (selector IS NULL));
+
+CREATE UNIQUE INDEX webhooks_callback_url_selector_key ON webhooks (callback_url, selector) WHERE selector IS NOT NULL;
@@ -0,0 +1,7 @@
+-- Temperatures keep the time they were observed at in timestamp, which may be given by the
+-- client, apart from the time they were received at. Both are Unix times.
+ALTER TABLE temperatures ALTER COLUMN timestamp TYPE BIGINT;
+
+ALTER TABLE temperatures ADD COLUMN received_at BIGINT;
+UPDATE temperatures SET received_at = timestamp;
+ALTER TABLE temperatures ALTER COLUMN received_at SET NOT NULL;
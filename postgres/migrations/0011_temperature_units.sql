@@ -0,0 +1,2 @@
+-- Temperatures are stored in Celsius; webhooks are posted temperatures in the unit of their choice.
+ALTER TABLE webhooks ADD COLUMN unit VARCHAR(10) NOT NULL DEFAULT 'celsius';
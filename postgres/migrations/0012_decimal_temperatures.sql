@@ -0,0 +1,4 @@
+-- Temperatures are kept in Celsius with two decimals rather than whole degrees.
+ALTER TABLE temperatures
+    ALTER COLUMN min TYPE NUMERIC(6, 2),
+    ALTER COLUMN max TYPE NUMERIC(6, 2);
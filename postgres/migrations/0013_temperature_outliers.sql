@@ -0,0 +1,13 @@
+-- New temperatures are scored against the recent temperatures of their city. Outliers are kept
+-- flagged, excluded from forecasts, or rejected according to the policy of the city.
+ALTER TABLE temperatures
+    ADD COLUMN flagged BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN outlier_score DOUBLE PRECISION;
+
+CREATE INDEX temperatures_flagged_idx ON temperatures (timestamp DESC) WHERE flagged;
+
+CREATE TABLE outlier_policies (
+    city_id BIGINT PRIMARY KEY REFERENCES cities (ID) ON DELETE CASCADE,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('accept', 'flag', 'reject')),
+    threshold DOUBLE PRECISION NOT NULL CHECK (threshold > 0)
+);
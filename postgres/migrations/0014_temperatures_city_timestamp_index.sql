@@ -0,0 +1,2 @@
+-- Pages through the temperatures of a city in the order they were observed in.
+CREATE INDEX temperatures_city_id_timestamp_idx ON temperatures (city_id, timestamp, ID);
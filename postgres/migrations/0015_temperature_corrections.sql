@@ -0,0 +1,18 @@
+-- Temperatures can be corrected or deleted; every change is kept for auditing along with the
+-- values the temperature had before it. Deleted temperatures leave their corrections behind.
+CREATE TABLE temperature_corrections (
+    ID SERIAL PRIMARY KEY,
+    temperature_id BIGINT NOT NULL,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('update', 'delete')),
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    previous_min NUMERIC(6, 2) NOT NULL,
+    previous_max NUMERIC(6, 2) NOT NULL,
+    previous_timestamp BIGINT NOT NULL,
+    min NUMERIC(6, 2),
+    max NUMERIC(6, 2),
+    timestamp BIGINT,
+    corrected_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX temperature_corrections_temperature_id_idx ON temperature_corrections (temperature_id);
@@ -0,0 +1,12 @@
+-- Stations observe other metrics besides temperatures, each stored in the base unit of its metric.
+CREATE TABLE observations (
+    ID SERIAL PRIMARY KEY,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    metric VARCHAR(20) NOT NULL CHECK (metric IN ('humidity', 'pressure', 'wind_speed', 'wind_direction', 'precipitation')),
+    value DOUBLE PRECISION NOT NULL,
+    timestamp BIGINT NOT NULL,
+    received_at BIGINT NOT NULL
+);
+
+CREATE INDEX observations_city_id_timestamp_idx ON observations (city_id, timestamp, ID);
+CREATE INDEX observations_city_id_metric_timestamp_idx ON observations (city_id, metric, timestamp, ID);
@@ -0,0 +1,21 @@
+-- Temperatures are attributed to the station that reported them. Stations authenticate with a
+-- token that is only stored hashed; deleted stations are kept so that their temperatures stay
+-- attributed to them.
+CREATE TABLE stations (
+    ID SERIAL PRIMARY KEY,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    elevation REAL,
+    owner VARCHAR(100) NOT NULL DEFAULT '',
+    weight DOUBLE PRECISION NOT NULL DEFAULT 1 CHECK (weight > 0),
+    token_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ
+);
+
+CREATE INDEX stations_city_id_idx ON stations (city_id) WHERE deleted_at IS NULL;
+
+ALTER TABLE temperatures ADD COLUMN station_id BIGINT REFERENCES stations (ID) ON DELETE CASCADE;
+
+CREATE INDEX temperatures_station_id_timestamp_idx ON temperatures (station_id, timestamp, ID) WHERE station_id IS NOT NULL;
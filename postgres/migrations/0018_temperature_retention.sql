@@ -0,0 +1,41 @@
+-- Temperatures older than the retention of their city are rolled into hourly aggregates, which
+-- in turn are rolled into daily aggregates. Aggregates are kept per station and start at the
+-- local hour or day of their city; daily aggregates are kept forever unless a daily retention is set.
+CREATE TABLE temperatures_hourly (
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    station_id BIGINT REFERENCES stations (ID) ON DELETE CASCADE,
+    timestamp BIGINT NOT NULL,
+    min NUMERIC(6, 2) NOT NULL,
+    max NUMERIC(6, 2) NOT NULL,
+    avg_min DOUBLE PRECISION NOT NULL,
+    avg_max DOUBLE PRECISION NOT NULL,
+    count BIGINT NOT NULL CHECK (count > 0)
+);
+
+CREATE UNIQUE INDEX temperatures_hourly_city_id_station_id_timestamp_key ON temperatures_hourly (city_id, (COALESCE(station_id, 0)), timestamp);
+CREATE INDEX temperatures_hourly_timestamp_idx ON temperatures_hourly (timestamp);
+
+CREATE TABLE temperatures_daily (
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    station_id BIGINT REFERENCES stations (ID) ON DELETE CASCADE,
+    timestamp BIGINT NOT NULL,
+    min NUMERIC(6, 2) NOT NULL,
+    max NUMERIC(6, 2) NOT NULL,
+    avg_min DOUBLE PRECISION NOT NULL,
+    avg_max DOUBLE PRECISION NOT NULL,
+    count BIGINT NOT NULL CHECK (count > 0)
+);
+
+CREATE UNIQUE INDEX temperatures_daily_city_id_station_id_timestamp_key ON temperatures_daily (city_id, (COALESCE(station_id, 0)), timestamp);
+CREATE INDEX temperatures_daily_timestamp_idx ON temperatures_daily (timestamp);
+
+-- Finds the temperatures due to be rolled up across all cities.
+CREATE INDEX temperatures_timestamp_idx ON temperatures (timestamp);
+
+-- Retentions are given in seconds; a daily retention of 0 keeps daily aggregates forever.
+CREATE TABLE retention_policies (
+    city_id BIGINT PRIMARY KEY REFERENCES cities (ID) ON DELETE CASCADE,
+    raw_retention BIGINT NOT NULL CHECK (raw_retention > 0),
+    hourly_retention BIGINT NOT NULL CHECK (hourly_retention > 0),
+    daily_retention BIGINT NOT NULL CHECK (daily_retention >= 0)
+);
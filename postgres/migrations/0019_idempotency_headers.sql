@@ -0,0 +1,8 @@
+-- Replays the whole header set of a stored response rather than only its content type, keyed by
+-- canonical header name like an http.Header.
+ALTER TABLE idempotency_keys ADD COLUMN headers JSONB NOT NULL DEFAULT '{}';
+
+UPDATE idempotency_keys SET headers = jsonb_build_object('Content-Type', jsonb_build_array(content_type))
+WHERE content_type <> '';
+
+ALTER TABLE idempotency_keys DROP COLUMN content_type;
@@ -0,0 +1,28 @@
+-- Stores the requirements of the selector of a webhook as a JSON array of {key, operator, values}
+-- objects, so that the webhooks of a city are matched against its labels in the database rather
+-- than by parsing every selector on each notification. Existing selectors are parsed the same as
+-- ParseSelector does, their keys and values never holding commas, parentheses, '=' or '!'.
+ALTER TABLE webhooks ADD COLUMN requirements JSONB;
+
+UPDATE webhooks w SET requirements = (
+    SELECT jsonb_agg(CASE
+        WHEN t.term LIKE '!%' AND t.term !~ '[=()]' THEN
+            jsonb_build_object('key', btrim(substr(t.term, 2)), 'operator', '!')
+        WHEN t.term LIKE '%!=%' THEN
+            jsonb_build_object('key', btrim(split_part(t.term, '!=', 1)), 'operator', '!=',
+                'values', jsonb_build_array(btrim(split_part(t.term, '!=', 2))))
+        WHEN t.term LIKE '%=%' THEN
+            jsonb_build_object('key', btrim(split_part(t.term, '=', 1)), 'operator', '=',
+                'values', jsonb_build_array(btrim(regexp_replace(t.term, '^[^=]*==?', ''))))
+        WHEN t.term LIKE '%(%' THEN
+            jsonb_build_object('key', (regexp_match(t.term, '^(\S+)'))[1], 'operator', (regexp_match(t.term, '^\S+\s+(in|notin)'))[1],
+                'values', to_jsonb(regexp_split_to_array(btrim((regexp_match(t.term, '\((.*)\)'))[1]), '\s*,\s*')))
+        ELSE
+            jsonb_build_object('key', t.term, 'operator', 'exists')
+        END ORDER BY m.n)
+    FROM regexp_matches(w.selector, '[^,(]+(?:\([^)]*\))?', 'g') WITH ORDINALITY AS m (parts, n),
+    LATERAL (SELECT btrim(m.parts[1]) AS term) AS t
+)
+WHERE selector IS NOT NULL;
+
+ALTER TABLE webhooks ADD CONSTRAINT webhooks_requirements_check CHECK ((selector IS NULL) = (requirements IS NULL));
@@ -0,0 +1,4 @@
+-- Records when an idempotency key was last claimed, so that a claim whose request never completed
+-- can be taken over by a retry once its lease has passed rather than answering 409 for the whole
+-- idempotency window.
+ALTER TABLE idempotency_keys ADD COLUMN claimed_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -1,22 +1,201 @@
+CREATE EXTENSION IF NOT EXISTS cube;
+CREATE EXTENSION IF NOT EXISTS earthdistance;
+
 CREATE TABLE cities (
     ID BIGSERIAL PRIMARY KEY,
-    name VARCHAR(50) NOT NULL UNIQUE,
+    name VARCHAR(50) NOT NULL,
     latitude REAL NOT NULL, 
     longitude REAL NOT NULL,
-    version VARCHAR(40) NOT NULL
+    version VARCHAR(40) NOT NULL,
+    country_code VARCHAR(2) NOT NULL DEFAULT '',
+    admin_region VARCHAR(100) NOT NULL DEFAULT '',
+    time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    labels JSONB NOT NULL DEFAULT '{}',
+    deleted_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX cities_name_country_code_admin_region_key ON cities (name, country_code, admin_region) WHERE deleted_at IS NULL;
+
+CREATE INDEX cities_location_idx ON cities USING gist (ll_to_earth(latitude, longitude));
+CREATE INDEX cities_latitude_longitude_idx ON cities (latitude, longitude);
+CREATE INDEX cities_labels_idx ON cities USING gin (labels);
+
+CREATE TABLE city_revisions (
+    ID BIGSERIAL PRIMARY KEY,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    version VARCHAR(40) NOT NULL,
+    previous_version VARCHAR(40) NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    country_code VARCHAR(2) NOT NULL,
+    admin_region VARCHAR(100) NOT NULL,
+    time_zone VARCHAR(64) NOT NULL,
+    previous_name VARCHAR(50) NOT NULL,
+    previous_latitude REAL NOT NULL,
+    previous_longitude REAL NOT NULL,
+    previous_country_code VARCHAR(2) NOT NULL,
+    previous_admin_region VARCHAR(100) NOT NULL,
+    previous_time_zone VARCHAR(64) NOT NULL,
+    revised_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (city_id, version)
+);
+
+CREATE FUNCTION record_city_revision() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO city_revisions
+    (city_id, version, previous_version,
+        name, latitude, longitude, country_code, admin_region, time_zone,
+        previous_name, previous_latitude, previous_longitude, previous_country_code, previous_admin_region, previous_time_zone)
+    VALUES (NEW.ID, NEW.version, OLD.version,
+        NEW.name, NEW.latitude, NEW.longitude, NEW.country_code, NEW.admin_region, NEW.time_zone,
+        OLD.name, OLD.latitude, OLD.longitude, OLD.country_code, OLD.admin_region, OLD.time_zone);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER cities_revision_trigger
+AFTER UPDATE OF version ON cities
+FOR EACH ROW WHEN (OLD.version IS DISTINCT FROM NEW.version)
+EXECUTE PROCEDURE record_city_revision();
+
+CREATE TABLE stations (
+    ID SERIAL PRIMARY KEY,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    latitude REAL NOT NULL,
+    longitude REAL NOT NULL,
+    elevation REAL,
+    owner VARCHAR(100) NOT NULL DEFAULT '',
+    weight DOUBLE PRECISION NOT NULL DEFAULT 1 CHECK (weight > 0),
+    token_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    deleted_at TIMESTAMPTZ
 );
 
+CREATE INDEX stations_city_id_idx ON stations (city_id) WHERE deleted_at IS NULL;
+
 CREATE TABLE temperatures (
     ID SERIAL PRIMARY KEY,
-    min INT NOT NULL,
-    max INT NOT NULL,
+    min NUMERIC(6, 2) NOT NULL,
+    max NUMERIC(6, 2) NOT NULL,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    timestamp BIGINT NOT NULL,
+    received_at BIGINT NOT NULL,
+    flagged BOOLEAN NOT NULL DEFAULT false,
+    outlier_score DOUBLE PRECISION,
+    station_id BIGINT REFERENCES stations (ID) ON DELETE CASCADE
+);
+
+CREATE INDEX temperatures_station_id_timestamp_idx ON temperatures (station_id, timestamp, ID) WHERE station_id IS NOT NULL;
+CREATE INDEX temperatures_city_id_timestamp_idx ON temperatures (city_id, timestamp, ID);
+CREATE INDEX temperatures_flagged_idx ON temperatures (timestamp DESC) WHERE flagged;
+CREATE INDEX temperatures_timestamp_idx ON temperatures (timestamp);
+
+CREATE TABLE temperatures_hourly (
     city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
-    timestamp int NOT NULL
+    station_id BIGINT REFERENCES stations (ID) ON DELETE CASCADE,
+    timestamp BIGINT NOT NULL,
+    min NUMERIC(6, 2) NOT NULL,
+    max NUMERIC(6, 2) NOT NULL,
+    avg_min DOUBLE PRECISION NOT NULL,
+    avg_max DOUBLE PRECISION NOT NULL,
+    count BIGINT NOT NULL CHECK (count > 0)
 );
 
+CREATE UNIQUE INDEX temperatures_hourly_city_id_station_id_timestamp_key ON temperatures_hourly (city_id, (COALESCE(station_id, 0)), timestamp);
+CREATE INDEX temperatures_hourly_timestamp_idx ON temperatures_hourly (timestamp);
+
+CREATE TABLE temperatures_daily (
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    station_id BIGINT REFERENCES stations (ID) ON DELETE CASCADE,
+    timestamp BIGINT NOT NULL,
+    min NUMERIC(6, 2) NOT NULL,
+    max NUMERIC(6, 2) NOT NULL,
+    avg_min DOUBLE PRECISION NOT NULL,
+    avg_max DOUBLE PRECISION NOT NULL,
+    count BIGINT NOT NULL CHECK (count > 0)
+);
+
+CREATE UNIQUE INDEX temperatures_daily_city_id_station_id_timestamp_key ON temperatures_daily (city_id, (COALESCE(station_id, 0)), timestamp);
+CREATE INDEX temperatures_daily_timestamp_idx ON temperatures_daily (timestamp);
+
+CREATE TABLE retention_policies (
+    city_id BIGINT PRIMARY KEY REFERENCES cities (ID) ON DELETE CASCADE,
+    raw_retention BIGINT NOT NULL CHECK (raw_retention > 0),
+    hourly_retention BIGINT NOT NULL CHECK (hourly_retention > 0),
+    daily_retention BIGINT NOT NULL CHECK (daily_retention >= 0)
+);
+
+CREATE TABLE outlier_policies (
+    city_id BIGINT PRIMARY KEY REFERENCES cities (ID) ON DELETE CASCADE,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('accept', 'flag', 'reject')),
+    threshold DOUBLE PRECISION NOT NULL CHECK (threshold > 0)
+);
+
+CREATE TABLE temperature_corrections (
+    ID SERIAL PRIMARY KEY,
+    temperature_id BIGINT NOT NULL,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('update', 'delete')),
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    previous_min NUMERIC(6, 2) NOT NULL,
+    previous_max NUMERIC(6, 2) NOT NULL,
+    previous_timestamp BIGINT NOT NULL,
+    min NUMERIC(6, 2),
+    max NUMERIC(6, 2),
+    timestamp BIGINT,
+    corrected_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX temperature_corrections_temperature_id_idx ON temperature_corrections (temperature_id);
+
+CREATE TABLE observations (
+    ID SERIAL PRIMARY KEY,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    metric VARCHAR(20) NOT NULL CHECK (metric IN ('humidity', 'pressure', 'wind_speed', 'wind_direction', 'precipitation')),
+    value DOUBLE PRECISION NOT NULL,
+    timestamp BIGINT NOT NULL,
+    received_at BIGINT NOT NULL
+);
+
+CREATE INDEX observations_city_id_timestamp_idx ON observations (city_id, timestamp, ID);
+CREATE INDEX observations_city_id_metric_timestamp_idx ON observations (city_id, metric, timestamp, ID);
+
 CREATE TABLE webhooks (
     ID SERIAL PRIMARY KEY,
     callback_url VARCHAR(255) NOT NULL, 
-    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE, 
-    UNIQUE (callback_url, city_id)
-);
\ No newline at end of file
+    city_id BIGINT REFERENCES cities (ID) ON DELETE CASCADE, 
+    selector VARCHAR(255),
+    unit VARCHAR(10) NOT NULL DEFAULT 'celsius',
+    requirements JSONB,
+    UNIQUE (callback_url, city_id),
+    CONSTRAINT webhooks_target_check CHECK ((city_id IS NULL) <> (selector IS NULL)),
+    CONSTRAINT webhooks_requirements_check CHECK ((selector IS NULL) = (requirements IS NULL))
+);
+
+CREATE UNIQUE INDEX webhooks_callback_url_selector_key ON webhooks (callback_url, selector) WHERE selector IS NOT NULL;
+
+CREATE TABLE regions (
+    ID BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL UNIQUE
+);
+
+CREATE TABLE region_cities (
+    region_id BIGINT NOT NULL REFERENCES regions (ID) ON DELETE CASCADE,
+    city_id BIGINT NOT NULL REFERENCES cities (ID) ON DELETE CASCADE,
+    PRIMARY KEY (region_id, city_id)
+);
+
+CREATE INDEX region_cities_city_id_idx ON region_cities (city_id);
+
+CREATE TABLE idempotency_keys (
+    key VARCHAR(255) PRIMARY KEY,
+    fingerprint VARCHAR(64) NOT NULL,
+    status INT,
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    headers JSONB NOT NULL DEFAULT '{}',
+    claimed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
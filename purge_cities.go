@@ -0,0 +1,41 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/shaybix/weather-monster/model"
+)
+
+// defaultPurgeRetention is how long deleted cities are kept before being purged if no retention is given
+const defaultPurgeRetention = 30 * 24 * time.Hour
+
+// purgeCities implements the purge-cities command, permanently deleting the cities that were
+// deleted longer than the retention period ago along with their temperatures and webhooks
+func purgeCities(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("purge-cities", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s purge-cities [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	retention := fs.Duration("retention", defaultPurgeRetention, "how long deleted cities are kept before being purged")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *retention < 0 {
+		return fmt.Errorf("retention must not be negative, got %v", *retention)
+	}
+
+	n, err := model.NewCityManager(db).Purge(*retention)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d cities purged\n", n)
+	return nil
+}
@@ -2,19 +2,98 @@ package service
 
 import (
 	"encoding/json"
-	"github.com/gorilla/mux"
-	"github.com/shaybix/weather-monster/model"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
 )
 
 // City describes a city and its' location in the world
 type City struct {
-	ID        int64   `json:"id"`
-	Name      string  `json:"name"`
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
-	Version   string  `json:"version"`
+	ID          int64   `json:"id"`
+	Name        string  `json:"name"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	Version     string  `json:"version"`
+	CountryCode string  `json:"country_code"`
+	AdminRegion string  `json:"admin_region"`
+	TimeZone    string  `json:"time_zone"`
+	// Labels is always an object, empty for a city without any labels
+	Labels map[string]string `json:"labels"`
+}
+
+// CityList describes a page of cities and the cursor to retrieve the next page
+type CityList struct {
+	Cities     []*City `json:"cities"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// NearbyCity describes a city and its distance to the location searched from
+type NearbyCity struct {
+	*City
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// NearbyCityList describes the cities found around a location, closest first
+type NearbyCityList struct {
+	Cities []*NearbyCity `json:"cities"`
+}
+
+const (
+	// defaultNearbyRadiusKm is the search radius of nearby cities if none is given
+	defaultNearbyRadiusKm = 100
+	// maxNearbyRadiusKm is half the circumference of the earth, covering the whole globe
+	maxNearbyRadiusKm = 20038
+	// defaultNearbyLimit is the number of nearby cities returned if no limit is given
+	defaultNearbyLimit = 10
+)
+
+// CityTemperature describes a city along with its most recent temperature
+type CityTemperature struct {
+	*City
+	LatestTemperature *Temperature `json:"latest_temperature"`
+}
+
+// CityTemperatureList describes a page of the cities found within an area and the cursor to
+// retrieve the next page
+type CityTemperatureList struct {
+	Cities     []*CityTemperature `json:"cities"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// geometry describes a GeoJSON Polygon geometry, or a Feature holding one
+type geometry struct {
+	Type        string        `json:"type"`
+	Coordinates model.Polygon `json:"coordinates"`
+	Geometry    *geometry     `json:"geometry"`
+}
+
+func newCity(c *model.City) *City {
+	return &City{
+		ID:          c.ID,
+		Name:        c.Name,
+		Latitude:    c.Latitude,
+		Longitude:   c.Longitude,
+		Version:     c.Version,
+		CountryCode: c.CountryCode,
+		AdminRegion: c.AdminRegion,
+		TimeZone:    c.TimeZone,
+		Labels:      labelsOrEmpty(c.Labels),
+	}
+}
+
+// labelsOrEmpty returns the labels, or an empty set of them in place of nil
+func labelsOrEmpty(labels model.Labels) map[string]string {
+	if labels == nil {
+		return map[string]string{}
+	}
+
+	return labels
 }
 
 // CreateCityHandler handles a POST request to create a city
@@ -26,26 +105,193 @@ func (m *Manager) CreateCityHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	latitude, err := strconv.ParseFloat(r.FormValue("latitude"), 64)
+	var ve model.ValidationError
+	nc := &model.NewCity{
+		Name:        r.FormValue("name"),
+		Latitude:    formFloat(&ve, r, "latitude"),
+		Longitude:   formFloat(&ve, r, "longitude"),
+		CountryCode: strings.ToUpper(strings.TrimSpace(r.FormValue("country_code"))),
+		AdminRegion: strings.TrimSpace(r.FormValue("admin_region")),
+		TimeZone:    strings.TrimSpace(r.FormValue("time_zone")),
+		Labels:      formLabels(&ve, r, "labels"),
+	}
+
+	if err := validate(&ve, nc); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	city, err := m.CM.Create(nc)
+	if err != nil {
+		if err == model.ErrAlreadyExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(newCity(city))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(resp)
+
+}
+
+// GetCityHandler handles a GET request to read a city, answering with 304 Not Modified
+// if the client already holds its current version
+func (m *Manager) GetCityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	longitude, err := strconv.ParseFloat(r.FormValue("longitude"), 64)
+	city, err := m.CM.Get(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag := cityETag(city.Version)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Accept-Patch", acceptPatch)
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	resp, err := json.Marshal(newCity(city))
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// cityETag returns the entity tag of a city at the given version
+func cityETag(version string) string {
+	return `"` + version + `"`
+}
+
+// etagMatches reports whether the entity tag is listed in an If-None-Match or If-Match header value.
+// Weak entity tags are compared by their opaque value only.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListCitiesHandler handles a GET request to list cities page by page
+func (m *Manager) ListCitiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := r.ParseForm(); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	city, err := m.CM.Create(&model.NewCity{
-		Name:      r.FormValue("name"),
-		Latitude:  latitude,
-		Longitude: longitude,
-	})
+	if v := r.FormValue("bbox"); v != "" {
+		bb, err := parseBoundingBox(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := rejectListingParams(r.Form, "bbox"); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		limit, err := parseCityLimit(r.FormValue("limit"), model.DefaultCityLimit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		unit, _, err := requestedUnit(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := m.CM.WithinBoundingBox(bb, limit, r.FormValue("cursor"))
+		if err != nil {
+			if err == model.ErrInvalidCursor {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		writeCityTemperatures(w, r, page, unit)
+		return
+	}
+
+	var limit int
+	if v := r.FormValue("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	selector, err := model.ParseSelector(r.FormValue("selector"))
 	if err != nil {
-		if err == model.ErrAlreadyExists {
-			http.Error(w, err.Error(), http.StatusConflict)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cq := &model.CityQuery{
+		NamePrefix:  r.FormValue("prefix"),
+		Name:        r.FormValue("name"),
+		CountryCode: strings.ToUpper(strings.TrimSpace(r.FormValue("country_code"))),
+		AdminRegion: r.FormValue("admin_region"),
+		Selector:    selector,
+		Sort:        model.CitySort(r.FormValue("sort")),
+		Limit:       limit,
+		Cursor:      r.FormValue("cursor"),
+	}
+	if err := validate(&model.ValidationError{}, cq); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	page, err := m.CM.List(cq)
+	if err != nil {
+		if err == model.ErrInvalidCursor || err == model.ErrInvalidSort {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -53,25 +299,43 @@ func (m *Manager) CreateCityHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := json.Marshal(&City{
-		ID:        city.ID,
-		Name:      city.Name,
-		Latitude:  city.Latitude,
-		Longitude: city.Longitude,
-		Version:   city.Version,
-	})
+	cl := &CityList{
+		Cities:     make([]*City, 0, len(page.Cities)),
+		NextCursor: page.NextCursor,
+	}
+	for _, city := range page.Cities {
+		cl.Cities = append(cl.Cities, newCity(city))
+	}
+
+	resp, err := json.Marshal(cl)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	if page.NextCursor != "" {
+		w.Header().Set("Link", nextLink(r.URL, page.NextCursor))
+	}
+
+	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
+}
 
+// nextLink returns a Link header value pointing to the page after the one requested in u
+func nextLink(u *url.URL, cursor string) string {
+	q := u.Query()
+	q.Set("cursor", cursor)
+
+	next := url.URL{
+		Path:     u.Path,
+		RawQuery: q.Encode(),
+	}
+
+	return "<" + next.String() + `>; rel="next"`
 }
 
-// UpdateCityHandler handles a PATCH request to update a city
-func (m *Manager) UpdateCityHandler(w http.ResponseWriter, r *http.Request) {
+// NearbyCitiesHandler handles a GET request to find the cities closest to a location
+func (m *Manager) NearbyCitiesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if err := r.ParseForm(); err != nil {
@@ -79,18 +343,235 @@ func (m *Manager) UpdateCityHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	latitude, err := strconv.ParseFloat(r.FormValue("latitude"), 64)
+	lat, err := strconv.ParseFloat(r.FormValue("lat"), 64)
+	if err != nil || !(lat >= -90 && lat <= 90) {
+		http.Error(w, "lat must be a number between -90 and 90", http.StatusBadRequest)
+		return
+	}
+
+	lon, err := strconv.ParseFloat(r.FormValue("lon"), 64)
+	if err != nil || !(lon >= -180 && lon <= 180) {
+		http.Error(w, "lon must be a number between -180 and 180", http.StatusBadRequest)
+		return
+	}
+
+	radius := float64(defaultNearbyRadiusKm)
+	if v := r.FormValue("radius_km"); v != "" {
+		radius, err = strconv.ParseFloat(v, 64)
+		if err != nil || !(radius > 0 && radius <= maxNearbyRadiusKm) {
+			http.Error(w, fmt.Sprintf("radius_km must be a number between 0 and %d", maxNearbyRadiusKm), http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit, err := parseCityLimit(r.FormValue("limit"), defaultNearbyLimit)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	longitude, err := strconv.ParseFloat(r.FormValue("longitude"), 64)
+	ncs, err := m.CM.Nearby(lat, lon, radius, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ncl := &NearbyCityList{
+		Cities: make([]*NearbyCity, 0, len(ncs)),
+	}
+	for _, nc := range ncs {
+		ncl.Cities = append(ncl.Cities, &NearbyCity{
+			City:       newCity(nc.City),
+			DistanceKm: nc.DistanceKm,
+		})
+	}
+
+	resp, err := json.Marshal(ncl)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// SearchCitiesHandler handles a POST request to find the cities within a GeoJSON polygon
+func (m *Manager) SearchCitiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var g geometry
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if g.Type == "Feature" && g.Geometry != nil {
+		g = *g.Geometry
+	}
+
+	if err := validatePolygon(&g); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := rejectListingParams(r.URL.Query(), "a polygon"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseCityLimit(r.URL.Query().Get("limit"), model.DefaultCityLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unit, _, err := requestedUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := m.CM.WithinPolygon(g.Coordinates, limit, r.URL.Query().Get("cursor"))
+	if err != nil {
+		if err == model.ErrInvalidCursor {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeCityTemperatures(w, r, page, unit)
+}
+
+// writeCityTemperatures answers with a page of cities and their most recent temperatures in
+// unit, linking to the next page of the request if there is one
+func writeCityTemperatures(w http.ResponseWriter, r *http.Request, page *model.CityTemperaturePage, unit model.Unit) {
+	ctl := &CityTemperatureList{
+		Cities:     make([]*CityTemperature, 0, len(page.Cities)),
+		NextCursor: page.NextCursor,
+	}
+	for _, ct := range page.Cities {
+		c := &CityTemperature{
+			City: newCity(ct.City),
+		}
+		if ct.Latest != nil {
+			c.LatestTemperature = newTemperature(ct.Latest, unit)
+		}
+
+		ctl.Cities = append(ctl.Cities, c)
+	}
+
+	resp, err := json.Marshal(ctl)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if page.NextCursor != "" {
+		w.Header().Set("Link", nextLink(r.URL, page.NextCursor))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// parseBoundingBox parses a bounding box given as minLon,minLat,maxLon,maxLat
+func parseBoundingBox(s string) (*model.BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bbox must be given as minLon,minLat,maxLon,maxLat")
+	}
+
+	var coords [4]float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bbox must be given as minLon,minLat,maxLon,maxLat: %v", err)
+		}
+		coords[i] = v
+	}
+
+	bb := &model.BoundingBox{
+		MinLon: coords[0],
+		MinLat: coords[1],
+		MaxLon: coords[2],
+		MaxLat: coords[3],
+	}
+
+	if !(bb.MinLon >= -180 && bb.MinLon <= 180 && bb.MaxLon >= -180 && bb.MaxLon <= 180) {
+		return nil, fmt.Errorf("bbox longitudes must be between -180 and 180, minLon above maxLon crossing the antimeridian")
+	}
+
+	if !(bb.MinLat >= -90 && bb.MaxLat <= 90 && bb.MinLat <= bb.MaxLat) {
+		return nil, fmt.Errorf("bbox latitudes must be between -90 and 90 with minLat not above maxLat")
+	}
+
+	return bb, nil
+}
+
+// rejectListingParams returns an error naming the first parameter of listings of cities given in
+// a search within an area, whose cities are neither filtered by name nor sorted other than by ID
+func rejectListingParams(params url.Values, area string) error {
+	for _, p := range []string{"prefix", "sort"} {
+		if _, ok := params[p]; ok {
+			return fmt.Errorf("%s cannot be combined with %s", p, area)
+		}
+	}
+
+	return nil
+}
+
+// parseCityLimit parses the number of cities searched for within an area or around a location,
+// which is def if it is not given
+func parseCityLimit(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > model.MaxCityLimit {
+		return 0, fmt.Errorf("limit must be an integer between 1 and %d", model.MaxCityLimit)
+	}
+
+	return limit, nil
+}
+
+// validatePolygon checks that a geometry is a GeoJSON Polygon of closed rings within valid coordinates
+func validatePolygon(g *geometry) error {
+	if g.Type != "Polygon" {
+		return fmt.Errorf("geometry must be a GeoJSON Polygon, got %q", g.Type)
+	}
+
+	if len(g.Coordinates) == 0 {
+		return fmt.Errorf("polygon must have at least one ring")
+	}
+
+	for _, ring := range g.Coordinates {
+		if len(ring) < 4 {
+			return fmt.Errorf("polygon rings must have at least four positions")
+		}
+
+		if ring[0] != ring[len(ring)-1] {
+			return fmt.Errorf("polygon rings must be closed")
+		}
+
+		for _, pos := range ring {
+			if pos[0] < -180 || pos[0] > 180 || pos[1] < -90 || pos[1] > 90 {
+				return fmt.Errorf("polygon position %v is out of range", pos)
+			}
+		}
+	}
+
+	return nil
+}
+
+// UpdateCityHandler handles a PATCH request to update a city
+func (m *Manager) UpdateCityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
 	vars := mux.Vars(r)
 
 	id, err := strconv.Atoi(vars["id"])
@@ -99,39 +580,117 @@ func (m *Manager) UpdateCityHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	city, err := m.CM.Update(&model.CityUpdate{
-		ID:        int64(id),
-		Name:      r.FormValue("name"),
-		Latitude:  latitude,
-		Longitude: longitude,
-		Version:   r.FormValue("version"),
-	})
+	p, err := decodeCityPatch(r)
+	if err != nil {
+		if err == errUnsupportedPatch {
+			w.Header().Set("Accept-Patch", acceptPatch)
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// a JSON Patch is only known to be valid once it is applied to the current city
+	if p.Ops == nil && writeInvalidPatch(w, p) {
+		return
+	}
+
+	vp, ok := requestedVersion(r)
+	if !ok && p.Version != "" {
+		vp, ok = &versionPrecondition{Versions: []string{p.Version}}, true
+	}
+	if !ok {
+		http.Error(w, "the version of the city has to be given in If-Match or the version field", http.StatusPreconditionRequired)
+		return
+	}
+
+	cu := &p.Update
+	cu.ID = int64(id)
+
+	if p.Ops == nil && !p.LookupTimeZone && !vp.Any && len(vp.Versions) == 1 {
+		cu.Version = vp.Versions[0]
+	} else {
+		// a JSON Patch is applied to the city at its current version, a removed time zone is
+		// looked up from its coordinates, and several or any versions are matched against it, so
+		// the update is made conditional on it
+		current, ok := m.matchCurrentVersion(w, cu.ID, vp)
+		if !ok {
+			return
+		}
+
+		if p.Ops != nil {
+			if err := applyJSONPatch(p, newCity(current)); err != nil {
+				if errors.Is(err, errPatchTestFailed) {
+					http.Error(w, err.Error(), http.StatusConflict)
+					return
+				}
+
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if p.LookupTimeZone {
+			p.lookupTimeZone(current)
+		}
+
+		cu.Version = current.Version
+	}
+
+	if p.Ops != nil && writeInvalidPatch(w, p) {
+		return
+	}
+
+	city, err := m.CM.Update(cu)
 	if err != nil {
 		if err == model.ErrNotFound {
 			http.Error(w, err.Error(), http.StatusNotFound)
 			return
 		}
 
+		if err == model.ErrAlreadyExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		if err == model.ErrVersionConflict {
+			m.writeVersionConflict(w, cu.ID)
+			return
+		}
+
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	resp, err := json.Marshal(&City{
-		ID:        city.ID,
-		Name:      city.Name,
-		Latitude:  city.Latitude,
-		Longitude: city.Longitude,
-		Version:   city.Version,
-	})
+	resp, err := json.Marshal(newCity(city))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("ETag", cityETag(city.Version))
 	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
 
+// writeInvalidPatch answers a patch of a city that is invalid or changes none of its fields with
+// 400 Bad Request, reporting whether it did
+func writeInvalidPatch(w http.ResponseWriter, p *cityPatch) bool {
+	if err := validate(&p.Invalid, &p.Update); err != nil {
+		writeValidationError(w, err)
+		return true
+	}
+
+	if p.empty() {
+		http.Error(w, "the update does not change any field of the city", http.StatusBadRequest)
+		return true
+	}
+
+	return false
+}
+
 // DeleteCityHandler handles a DELETE request to delete a city
 func (m *Manager) DeleteCityHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -144,23 +703,37 @@ func (m *Manager) DeleteCityHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	city, err := m.CM.Delete(int64(id))
+	// deleting without a precondition is allowed, hence an absent version is ignored
+	var version string
+	if vp, ok := requestedVersion(r); ok {
+		if !vp.Any && len(vp.Versions) == 1 {
+			version = vp.Versions[0]
+		} else {
+			current, ok := m.matchCurrentVersion(w, int64(id), vp)
+			if !ok {
+				return
+			}
+			version = current.Version
+		}
+	}
+
+	city, err := m.CM.Delete(int64(id), version)
 	if err != nil {
 		if err == model.ErrNotFound {
 			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err == model.ErrVersionConflict {
+			m.writeVersionConflict(w, int64(id))
+			return
 		}
 
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	resp, err := json.Marshal(&City{
-		ID:        city.ID,
-		Name:      city.Name,
-		Latitude:  city.Latitude,
-		Longitude: city.Longitude,
-		Version:   city.Version,
-	})
+	resp, err := json.Marshal(newCity(city))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -168,5 +741,143 @@ func (m *Manager) DeleteCityHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
+}
+
+// RestoreCityHandler handles a POST request to restore a deleted city
+func (m *Manager) RestoreCityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	city, err := m.CM.Restore(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err == model.ErrAlreadyExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
+	resp, err := json.Marshal(newCity(city))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", cityETag(city.Version))
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// versionPrecondition describes the versions of a city a write request is conditional on
+type versionPrecondition struct {
+	// Any is set by an If-Match of "*", which any current version of an existing city matches
+	Any      bool
+	Versions []string
+}
+
+// matches reports whether the current version of a city satisfies the precondition
+func (vp *versionPrecondition) matches(version string) bool {
+	if vp.Any {
+		return true
+	}
+
+	for _, v := range vp.Versions {
+		if v == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// requestedVersion returns the city versions a write request is conditional on, taken from the
+// comma separated ETags of the If-Match header or else the version field. ok is false if the
+// request names no version at all.
+func requestedVersion(r *http.Request) (vp *versionPrecondition, ok bool) {
+	if h := strings.TrimSpace(r.Header.Get("If-Match")); h != "" {
+		vp = &versionPrecondition{}
+		for _, tag := range strings.Split(h, ",") {
+			tag = strings.TrimSpace(tag)
+			switch {
+			case tag == "*":
+				vp.Any = true
+			case tag != "":
+				vp.Versions = append(vp.Versions, strings.Trim(strings.TrimPrefix(tag, "W/"), `"`))
+			}
+		}
+
+		return vp, vp.Any || len(vp.Versions) > 0
+	}
+
+	if version := r.FormValue("version"); version != "" {
+		return &versionPrecondition{Versions: []string{version}}, true
+	}
+
+	return nil, false
+}
+
+// matchCurrentVersion returns the current state of a city if its version satisfies the
+// precondition. Otherwise it answers 412 Precondition Failed, which is also answered if the city
+// does not exist although any version of it is required to.
+func (m *Manager) matchCurrentVersion(w http.ResponseWriter, id int64, vp *versionPrecondition) (*model.City, bool) {
+	current, err := m.CM.Get(id)
+	if err != nil {
+		if err == model.ErrNotFound {
+			status := http.StatusNotFound
+			if vp.Any {
+				status = http.StatusPreconditionFailed
+			}
+			http.Error(w, err.Error(), status)
+			return nil, false
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !vp.matches(current.Version) {
+		m.writeVersionConflict(w, id)
+		return nil, false
+	}
+
+	return current, true
+}
+
+// writeVersionConflict answers a write based on an outdated version with 412 Precondition Failed
+// and the current state of the city
+func (m *Manager) writeVersionConflict(w http.ResponseWriter, id int64) {
+	city, err := m.CM.Get(id)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(newCity(city))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", cityETag(city.Version))
+	w.WriteHeader(http.StatusPreconditionFailed)
+	w.Write(resp)
 }
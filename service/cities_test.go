@@ -2,6 +2,7 @@ package service
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -51,10 +52,10 @@ func Test_CanHandleCreateCityRequest(t *testing.T) {
 
 		client := &http.Client{}
 
-		expectedRows := []string{"ID", "name", "latitude", "longitude", "version"}
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
 		mock.ExpectQuery("INSERT").WillReturnRows(
 			sqlmock.NewRows(expectedRows).
-				AddRow(1, "berlin", 23.232, 34.2323, "random-version-string"),
+				AddRow(1, "berlin", 23.232, 34.2323, "random-version-string", "DE", "Berlin", "Europe/Berlin", `{}`),
 		)
 
 		resp, err := client.Do(req)
@@ -128,10 +129,10 @@ func Test_CanHandleUpdateCityRequest(t *testing.T) {
 
 		client := &http.Client{}
 
-		expectedRows := []string{"ID", "name", "latitude", "longitude", "version"}
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
 		mock.ExpectQuery("UPDATE").WillReturnRows(
 			sqlmock.NewRows(expectedRows).
-				AddRow(1, "berlin", 23.323, 34.1231, "random-versioin-string"),
+				AddRow(1, "berlin", 23.323, 34.1231, "random-versioin-string", "DE", "Berlin", "Europe/Berlin", `{}`),
 		)
 
 		resp, err := client.Do(req)
@@ -198,10 +199,10 @@ func Test_CanHandleDeleteCityRequest(t *testing.T) {
 
 		client := &http.Client{}
 
-		expectedRows := []string{"ID", "name", "latitude", "longitude", "version"}
-		mock.ExpectQuery("DELETE").WillReturnRows(
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("UPDATE cities SET deleted_at").WillReturnRows(
 			sqlmock.NewRows(expectedRows).
-				AddRow(1, "berlin", 34.131, 31.31312, "random-version-string"),
+				AddRow(1, "berlin", 34.131, 31.31312, "random-version-string", "DE", "Berlin", "Europe/Berlin", `{}`),
 		)
 		resp, err := client.Do(req)
 		if err != nil {
@@ -236,7 +237,7 @@ func Test_CannotHandleDeleteCityRequestWithNonExistentID(t *testing.T) {
 
 		client := &http.Client{}
 
-		mock.ExpectQuery("DELETE").WillReturnError(model.ErrNotFound)
+		mock.ExpectQuery("UPDATE cities SET deleted_at").WillReturnError(model.ErrNotFound)
 
 		resp, err := client.Do(req)
 		if err != nil {
@@ -249,3 +250,1229 @@ func Test_CannotHandleDeleteCityRequestWithNonExistentID(t *testing.T) {
 
 	}, t)
 }
+
+func Test_CanHandleDeleteCityRequestMatchingAnyListedVersion(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.DeleteCityHandler).Methods("DELETE")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/cities/1", ts.URL), nil)
+		if err != nil {
+			t.Fatalf("could not create delete request: %v", err)
+		}
+		req.Header.Set("If-Match", `"outdated-version", W/"current-version"`)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "Berlin", 52.52, 13.405, "current-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+		mock.ExpectQuery("UPDATE cities SET deleted_at").WithArgs(1, "current-version").WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "Berlin", 52.52, 13.405, "current-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make delete request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected http status ok but got %v", resp.StatusCode)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expected the city to be deleted at its current version: %v", err)
+		}
+	}, t)
+}
+
+func Test_CannotHandleDeleteCityRequestMatchingAnyVersionOfNonExistentCity(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.DeleteCityHandler).Methods("DELETE")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/cities/404", ts.URL), nil)
+		if err != nil {
+			t.Fatalf("could not create delete request: %v", err)
+		}
+		req.Header.Set("If-Match", "*")
+
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(404).WillReturnError(sql.ErrNoRows)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make delete request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Fatalf("expected status precondition failed, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleRestoreCityRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/restore", sm.RestoreCityHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("UPDATE cities SET deleted_at = NULL").WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "berlin", 52.52, 13.405, "new-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+
+		resp, err := http.Post(fmt.Sprintf("%s/cities/1/restore", ts.URL), "", nil)
+		if err != nil {
+			t.Fatalf("could not make restore request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected http status ok but got %v", resp.StatusCode)
+		}
+
+		if etag := resp.Header.Get("ETag"); etag != `"new-version"` {
+			t.Errorf("expected etag of the restored version, got %q", etag)
+		}
+	}, t)
+}
+
+func Test_CannotHandleRestoreCityRequestWhenNameTaken(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/restore", sm.RestoreCityHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("UPDATE cities SET deleted_at = NULL").WillReturnError(model.ErrAlreadyExists)
+
+		resp, err := http.Post(fmt.Sprintf("%s/cities/1/restore", ts.URL), "", nil)
+		if err != nil {
+			t.Fatalf("could not make restore request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("expected status conflict, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleListCitiesRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.ListCitiesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		url := fmt.Sprintf("%s/cities?prefix=Ber&limit=1", ts.URL)
+
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		client := &http.Client{}
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "Berlin", 52.52, 13.40, "random-version-string", "DE", "Berlin", "Europe/Berlin", `{}`).
+				AddRow(2, "Bern", 46.94, 7.44, "random-version-string", "CH", "Bern", "Europe/Zurich", `{}`),
+		)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok, got %v", resp.StatusCode)
+		}
+
+		var cl CityList
+		if err := json.NewDecoder(resp.Body).Decode(&cl); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+
+		if len(cl.Cities) != 1 || cl.NextCursor == "" {
+			t.Errorf("expected a single city and a next cursor, got %+v", cl)
+		}
+
+		if !strings.Contains(resp.Header.Get("Link"), `rel="next"`) {
+			t.Errorf("expected a link to the next page, got %q", resp.Header.Get("Link"))
+		}
+	}, t)
+}
+
+func Test_CannotHandleListCitiesRequestWithInvalidCursor(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.ListCitiesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		url := fmt.Sprintf("%s/cities?cursor=not-a-cursor", ts.URL)
+
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status bad request, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleGetCityRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.GetCityHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		url := fmt.Sprintf("%s/cities/%s", ts.URL, "1")
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "berlin", 52.52, 13.40, "random-version-string", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok, got %v", resp.StatusCode)
+		}
+
+		if etag := resp.Header.Get("ETag"); etag != `"random-version-string"` {
+			t.Errorf("expected etag of the city version, got %q", etag)
+		}
+	}, t)
+}
+
+func Test_CanHandleGetCityRequestWithMatchingETag(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.GetCityHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		url := fmt.Sprintf("%s/cities/%s", ts.URL, "1")
+
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Add("If-None-Match", `"random-version-string"`)
+
+		client := &http.Client{}
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "berlin", 52.52, 13.40, "random-version-string", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("expected status not modified, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CannotHandleGetCityRequestWithNonExistentID(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.GetCityHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		url := fmt.Sprintf("%s/cities/%s", ts.URL, "1")
+
+		mock.ExpectQuery("SELECT (.+) FROM cities").WillReturnError(sql.ErrNoRows)
+
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status not found, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CannotHandleUpdateCityRequestWithOutdatedVersion(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		form := url.Values{}
+		form.Add("name", "Berlin City")
+		form.Add("latitude", fmt.Sprintf("%f", 34.241))
+		form.Add("longitude", fmt.Sprintf("%f", 32.3421))
+
+		url := fmt.Sprintf("%s/cities/%s", ts.URL, "1")
+
+		req, err := http.NewRequest("PATCH", url, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("If-Match", `"outdated-version"`)
+
+		client := &http.Client{}
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("UPDATE").WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT version FROM cities").WillReturnRows(
+			sqlmock.NewRows([]string{"version"}).AddRow("current-version"),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM cities").WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "berlin", 23.323, 34.1231, "current-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("could not dial out: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusPreconditionFailed {
+			t.Fatalf("expected status precondition failed, got %v", resp.StatusCode)
+		}
+
+		if etag := resp.Header.Get("ETag"); etag != `"current-version"` {
+			t.Errorf("expected etag of the current version, got %q", etag)
+		}
+	}, t)
+}
+
+func Test_CannotHandleUpdateCityRequestWithoutVersion(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		form := url.Values{}
+		form.Add("name", "Berlin City")
+		form.Add("latitude", fmt.Sprintf("%f", 34.241))
+		form.Add("longitude", fmt.Sprintf("%f", 32.3421))
+
+		url := fmt.Sprintf("%s/cities/%s", ts.URL, "1")
+
+		req, err := http.NewRequest("PATCH", url, strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		client := &http.Client{}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("could not dial out: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusPreconditionRequired {
+			t.Fatalf("expected status precondition required, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleNearbyCitiesRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/nearby", sm.NearbyCitiesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		url := fmt.Sprintf("%s/cities/nearby?lat=52.4&lon=13.1&radius_km=50", ts.URL)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels", "distance"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(2, "Potsdam", 52.39, 13.06, "random-version-string", "DE", "Brandenburg", "Europe/Berlin", `{}`, 3.1),
+		)
+
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok, got %v", resp.StatusCode)
+		}
+
+		var ncl NearbyCityList
+		if err := json.NewDecoder(resp.Body).Decode(&ncl); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+
+		if len(ncl.Cities) != 1 || ncl.Cities[0].DistanceKm != 3.1 {
+			t.Errorf("expected a single city 3.1km away, got %+v", ncl.Cities)
+		}
+	}, t)
+}
+
+func Test_CannotHandleNearbyCitiesRequestWithInvalidLocation(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/nearby", sm.NearbyCitiesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		for _, query := range []string{"lat=95&lon=13.1", "lat=NaN&lon=13.1", "lat=52.5&lon=NaN", "lat=52.5&lon=13.1&radius_km=NaN"} {
+			resp, err := http.Get(fmt.Sprintf("%s/cities/nearby?%s", ts.URL, query))
+			if err != nil {
+				t.Fatalf("could not make request: %v", err)
+			}
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status bad request for %q, got %v", query, resp.StatusCode)
+			}
+		}
+	}, t)
+}
+
+func Test_CanHandleListCitiesRequestWithinBoundingBox(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.ListCitiesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		url := fmt.Sprintf("%s/cities?bbox=12.9,52.3,13.8,52.7", ts.URL)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels", "temperature_id", "min", "max", "timestamp", "received_at"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").
+			WithArgs(52.3, 52.7, 12.9, 13.8, model.DefaultCityLimit+1).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.40, "random-version-string", "DE", "Berlin", "Europe/Berlin", `{}`, 3, 12, 19, 1579000000, 1579000060),
+			)
+
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok, got %v", resp.StatusCode)
+		}
+
+		var ctl CityTemperatureList
+		if err := json.NewDecoder(resp.Body).Decode(&ctl); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+
+		if len(ctl.Cities) != 1 || ctl.Cities[0].LatestTemperature == nil {
+			t.Errorf("expected a single city with its latest temperature, got %+v", ctl.Cities)
+		}
+	}, t)
+}
+
+func Test_CanHandleListCitiesRequestWithinBoundingBoxPageByPage(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.ListCitiesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels", "temperature_id", "min", "max", "timestamp", "received_at"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").
+			WithArgs(52.3, 52.7, 12.9, 13.8, 2).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.40, "random-version-string", "DE", "Berlin", "Europe/Berlin", `{}`, nil, nil, nil, nil, nil).
+					AddRow(2, "Potsdam", 52.39, 13.06, "random-version-string", "DE", "Brandenburg", "Europe/Berlin", `{}`, nil, nil, nil, nil, nil),
+			)
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities?bbox=12.9,52.3,13.8,52.7&limit=1", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok, got %v", resp.StatusCode)
+		}
+
+		var ctl CityTemperatureList
+		if err := json.NewDecoder(resp.Body).Decode(&ctl); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+
+		if len(ctl.Cities) != 1 || ctl.NextCursor == "" {
+			t.Errorf("expected a single city and a next cursor, got %+v", ctl)
+		}
+
+		if link := resp.Header.Get("Link"); !strings.Contains(link, "bbox=") || !strings.Contains(link, "cursor="+ctl.NextCursor) {
+			t.Errorf("expected a link to the next page of the bounding box, got %q", link)
+		}
+	}, t)
+}
+
+func Test_CannotHandleListCitiesRequestWithinBoundingBoxAboveMaxLimit(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.ListCitiesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities?bbox=12.9,52.3,13.8,52.7&limit=%d", ts.URL, model.MaxCityLimit+1))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status bad request, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CannotHandleListCitiesRequestWithInvalidBoundingBox(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.ListCitiesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		for _, bbox := range []string{"12.9,52.3,13.8", "12.9,95,13.8,96", "NaN,52.3,13.8,52.7", "12.9,NaN,13.8,52.7", "12.9,52.3,13.8,NaN"} {
+			resp, err := http.Get(fmt.Sprintf("%s/cities?bbox=%s", ts.URL, bbox))
+			if err != nil {
+				t.Fatalf("could not make request: %v", err)
+			}
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status bad request for %q, got %v", bbox, resp.StatusCode)
+			}
+		}
+	}, t)
+}
+
+func Test_CannotHandleSearchCitiesRequestWithOpenPolygon(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/search", sm.SearchCitiesHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		url := fmt.Sprintf("%s/cities/search", ts.URL)
+		body := `{"type":"Polygon","coordinates":[[[12.9,52.3],[13.8,52.3],[13.8,52.7],[12.9,52.7]]]}`
+
+		resp, err := http.Post(url, "application/geo+json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status bad request, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CannotHandleSearchCitiesRequestWithListingParameters(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/search", sm.SearchCitiesHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		body := `{"type":"Polygon","coordinates":[[[12.9,52.3],[13.8,52.3],[13.8,52.7],[12.9,52.7],[12.9,52.3]]]}`
+		for _, query := range []string{"prefix=Ber", "sort=name", "cursor=abc", "limit=101"} {
+			resp, err := http.Post(fmt.Sprintf("%s/cities/search?%s", ts.URL, query), "application/geo+json", strings.NewReader(body))
+			if err != nil {
+				t.Fatalf("could not make request: %v", err)
+			}
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status bad request for %q, got %v", query, resp.StatusCode)
+			}
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateCityRequestWithInvalidCountryCode(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.CreateCityHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		form := url.Values{}
+		form.Add("name", "Paris")
+		form.Add("latitude", fmt.Sprintf("%f", 33.66))
+		form.Add("longitude", fmt.Sprintf("%f", -95.55))
+		form.Add("country_code", "USA")
+
+		url := fmt.Sprintf("%s/cities", ts.URL)
+
+		resp, err := http.PostForm(url, form)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status bad request, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateCityRequestWithUnknownTimeZone(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.CreateCityHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		form := url.Values{}
+		form.Add("name", "Berlin")
+		form.Add("latitude", fmt.Sprintf("%f", 52.52))
+		form.Add("longitude", fmt.Sprintf("%f", 13.40))
+		form.Add("time_zone", "Europe/Atlantis")
+
+		url := fmt.Sprintf("%s/cities", ts.URL)
+
+		resp, err := http.PostForm(url, form)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status bad request, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleMergePatchOfSingleCityField(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		body := `{"latitude": 52.52, "version": "current-version"}`
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/cities/1", ts.URL), strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("UPDATE cities SET version = (.+), latitude = (.+) WHERE").
+			WithArgs(sqlmock.AnyArg(), 52.52, 1, "current-version").
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.405, "new-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+			)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make patch request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected http status ok but got %v", resp.StatusCode)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expected only the latitude to be updated: %v", err)
+		}
+	}, t)
+}
+
+func Test_CannotHandleMergePatchRemovingCityName(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/cities/1", ts.URL), strings.NewReader(`{"name": null}`))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("If-Match", `"current-version"`)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make patch request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleMergePatchRemovingTimeZone(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		// the removed time zone is looked up again at the coordinates the city is moved to
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/cities/1", ts.URL), strings.NewReader(`{"time_zone": null, "longitude": 13.06}`))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("If-Match", `"current-version"`)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "Berlin", 52.52, 13.405, "current-version", "DE", "Berlin", "UTC", `{}`),
+		)
+		mock.ExpectQuery("UPDATE cities SET version = (.+), longitude = (.+), time_zone = (.+) WHERE").
+			WithArgs(sqlmock.AnyArg(), 13.06, "Europe/Berlin", 1, "current-version").
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.06, "new-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+			)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make patch request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected http status ok but got %v", resp.StatusCode)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expected the time zone to be looked up again: %v", err)
+		}
+	}, t)
+}
+
+func Test_CanHandleJSONPatchWithVersionTest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		body := `[
+			{"op": "test", "path": "/version", "value": "current-version"},
+			{"op": "replace", "path": "/name", "value": "Berlin"},
+			{"op": "remove", "path": "/admin_region"}
+		]`
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/cities/1", ts.URL), strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json-patch+json")
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "Berlinn", 52.52, 13.405, "current-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+		mock.ExpectQuery("UPDATE cities SET version = (.+), name = (.+), admin_region = (.+) WHERE").
+			WithArgs(sqlmock.AnyArg(), "Berlin", "", 1, "current-version").
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.405, "new-version", "DE", "", "Europe/Berlin", `{}`),
+			)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make patch request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected http status ok but got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CannotHandleJSONPatchWithFailingTest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		body := `[
+			{"op": "test", "path": "/name", "value": "Berlinn"},
+			{"op": "replace", "path": "/name", "value": "Berlin"}
+		]`
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/cities/1", ts.URL), strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		req.Header.Set("If-Match", "*")
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "Berlin", 52.52, 13.405, "current-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make patch request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("expected status conflict, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleJSONPatchTestingEarlierOperations(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		// the test sees the name replaced before it, and the removed time zone is looked up again
+		body := `[
+			{"op": "replace", "path": "/name", "value": "Potsdam"},
+			{"op": "test", "path": "/name", "value": "Potsdam"},
+			{"op": "remove", "path": "/time_zone"}
+		]`
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/cities/1", ts.URL), strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		req.Header.Set("If-Match", `"current-version"`)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "Berlin", 52.52, 13.405, "current-version", "DE", "Berlin", "UTC", `{}`),
+		)
+		mock.ExpectQuery("UPDATE cities SET version = (.+), name = (.+), time_zone = (.+) WHERE").
+			WithArgs(sqlmock.AnyArg(), "Potsdam", "Europe/Berlin", 1, "current-version").
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Potsdam", 52.52, 13.405, "new-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+			)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make patch request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected http status ok but got %v", resp.StatusCode)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expected the name and time zone to be updated: %v", err)
+		}
+	}, t)
+}
+
+func Test_CannotHandleJSONPatchRemovingCityName(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/cities/1", ts.URL), strings.NewReader(`[{"op": "remove", "path": "/name"}]`))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		req.Header.Set("If-Match", `"current-version"`)
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "Berlin", 52.52, 13.405, "current-version", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make patch request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request, got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CannotHandleUpdateCityRequestWithUnsupportedContentType(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/cities/1", ts.URL), strings.NewReader(`<city/>`))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/xml")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make patch request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Fatalf("expected status unsupported media type, got %v", resp.StatusCode)
+		}
+
+		if resp.Header.Get("Accept-Patch") == "" {
+			t.Errorf("expected the accepted patch types to be announced")
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateCityRequestWithInvalidFields(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.CreateCityHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		form := url.Values{}
+		form.Add("name", "")
+		form.Add("latitude", "500")
+		form.Add("longitude", "east")
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/cities", ts.URL), form)
+		if err != nil {
+			t.Fatalf("could not make create request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request, got %v", resp.StatusCode)
+		}
+
+		var body ValidationErrors
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("could not decode validation errors: %v", err)
+		}
+
+		fields := map[string]bool{}
+		for _, fe := range body.Errors {
+			fields[fe.Field] = true
+		}
+
+		for _, field := range []string{"name", "latitude", "longitude"} {
+			if !fields[field] {
+				t.Errorf("expected %s to be reported as invalid, got %+v", field, body.Errors)
+			}
+		}
+	}, t)
+}
+
+func Test_CannotHandleListCitiesRequestWithInvalidCountryCode(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.ListCitiesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities?country_code=deu", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request, got %v", resp.StatusCode)
+		}
+
+		var body ValidationErrors
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("could not decode validation errors: %v", err)
+		}
+
+		if len(body.Errors) != 1 || body.Errors[0].Field != "country_code" {
+			t.Errorf("expected country_code to be reported as invalid, got %+v", body.Errors)
+		}
+	}, t)
+}
+
+func Test_CanHandleCreateCityRequestWithLabels(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.CreateCityHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		form := url.Values{}
+		form.Add("name", "Berlin")
+		form.Add("latitude", "52.52")
+		form.Add("longitude", "13.405")
+		form.Add("labels", "tier=gold, customer=acme")
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("INSERT INTO cities").
+			WithArgs("Berlin", 52.52, 13.405, sqlmock.AnyArg(), "", "", sqlmock.AnyArg(), `{"customer":"acme","tier":"gold"}`).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.405, "version", "", "", "UTC", `{"customer":"acme","tier":"gold"}`),
+			)
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/cities", ts.URL), form)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status created, got %v", resp.StatusCode)
+		}
+
+		var c City
+		if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+			t.Fatalf("could not decode city: %v", err)
+		}
+
+		if c.Labels["tier"] != "gold" || c.Labels["customer"] != "acme" {
+			t.Errorf("expected the labels of the city, got %v", c.Labels)
+		}
+	}, t)
+}
+
+func Test_CanHandleMergePatchOfCityLabels(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		body := `{"labels": {"tier": "gold", "legacy": null}, "version": "current-version"}`
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/cities/1", ts.URL), strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery(`UPDATE cities SET version = \$1, labels = \(\(labels \|\| \$2::jsonb\) - \$3::text\[\]\) WHERE`).
+			WithArgs(sqlmock.AnyArg(), `{"tier":"gold"}`, sqlmock.AnyArg(), 1, "current-version").
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.405, "new-version", "DE", "Berlin", "Europe/Berlin", `{"tier":"gold"}`),
+			)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make patch request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected http status ok but got %v", resp.StatusCode)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expected the labels to be merged: %v", err)
+		}
+	}, t)
+}
+
+func Test_CanHandleJSONPatchOfSingleCityLabel(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}", sm.UpdateCityHandler).Methods("PATCH")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		body := `[
+			{"op": "test", "path": "/labels/example.com~1tier", "value": "free"},
+			{"op": "replace", "path": "/labels/example.com~1tier", "value": "gold"}
+		]`
+		req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/cities/1", ts.URL), strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		req.Header.Set("If-Match", "*")
+
+		expectedRows := []string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}
+		mock.ExpectQuery("SELECT (.+) FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, "Berlin", 52.52, 13.405, "current-version", "DE", "Berlin", "Europe/Berlin", `{"example.com/tier":"free"}`),
+		)
+		mock.ExpectQuery(`UPDATE cities SET version = \$1, labels = \(labels \|\| \$2::jsonb\) WHERE`).
+			WithArgs(sqlmock.AnyArg(), `{"example.com/tier":"gold"}`, 1, "current-version").
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, "Berlin", 52.52, 13.405, "new-version", "DE", "Berlin", "Europe/Berlin", `{"example.com/tier":"gold"}`),
+			)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make patch request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected http status ok but got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CannotHandleListCitiesRequestWithInvalidSelector(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities", sm.ListCitiesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities?selector=%s", ts.URL, url.QueryEscape("tier in gold")))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status bad request, got %v", resp.StatusCode)
+		}
+	}, t)
+}
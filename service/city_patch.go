@@ -0,0 +1,490 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shaybix/weather-monster/model"
+	"github.com/shaybix/weather-monster/timezone"
+)
+
+const (
+	// formContentType is the content type of a city update given as form values
+	formContentType = "application/x-www-form-urlencoded"
+	// mergePatchContentType is the content type of a JSON Merge Patch (RFC 7396)
+	mergePatchContentType = "application/merge-patch+json"
+	// jsonPatchContentType is the content type of a JSON Patch (RFC 6902)
+	jsonPatchContentType = "application/json-patch+json"
+)
+
+// acceptPatch lists the content types a city can be patched with, as announced in Accept-Patch
+var acceptPatch = strings.Join([]string{formContentType, mergePatchContentType, jsonPatchContentType}, ", ")
+
+var (
+	// errUnsupportedPatch describes a PATCH request whose body is of none of the acceptPatch types
+	errUnsupportedPatch = errors.New("unsupported patch content type, expected one of " + acceptPatch)
+	// errPatchTestFailed describes a JSON Patch whose test operation does not hold for the city
+	errPatchTestFailed = errors.New("json patch test operation failed")
+)
+
+// jsonPatchOperation describes a single operation of a JSON Patch
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// cityPatch describes a decoded update of a city. Version is the version a merge patch or a
+// JSON Patch test of /version is conditional on; Ops are the operations of a JSON Patch still to
+// be applied to the current city. LookupTimeZone is set if a merge patch removes the time zone,
+// which is then looked up again from the coordinates of the city. Invalid holds the fields that
+// could not be decoded.
+type cityPatch struct {
+	Update         model.CityUpdate
+	Version        string
+	Ops            []*jsonPatchOperation
+	LookupTimeZone bool
+	Invalid        model.ValidationError
+}
+
+// empty reports whether the patch changes no field of the city
+func (p *cityPatch) empty() bool {
+	cu := &p.Update
+	return cu.Name == nil && cu.Latitude == nil && cu.Longitude == nil &&
+		cu.CountryCode == nil && cu.AdminRegion == nil && cu.TimeZone == nil &&
+		cu.Labels == nil && len(cu.SetLabels) == 0 && len(cu.RemoveLabels) == 0 &&
+		!p.LookupTimeZone
+}
+
+// lookupTimeZone sets the time zone of a patch removing it to the one at the coordinates of the
+// city, taking those the patch changes into account
+func (p *cityPatch) lookupTimeZone(c *model.City) {
+	cu := &p.Update
+	lat, lon := c.Latitude, c.Longitude
+	if cu.Latitude != nil {
+		lat = *cu.Latitude
+	}
+	if cu.Longitude != nil {
+		lon = *cu.Longitude
+	}
+
+	tz := timezone.Lookup(lat, lon)
+	cu.TimeZone = &tz
+}
+
+// decodeCityPatch decodes the body of a PATCH request for a city according to its content type.
+// A request without a content type is taken to hold form values.
+func decodeCityPatch(r *http.Request) (*cityPatch, error) {
+	mediaType := formContentType
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return nil, err
+		}
+		mediaType = mt
+	}
+
+	switch mediaType {
+	case formContentType:
+		return decodeCityForm(r)
+	case mergePatchContentType:
+		return decodeMergePatch(r.Body)
+	case jsonPatchContentType:
+		return decodeJSONPatch(r.Body)
+	default:
+		return nil, errUnsupportedPatch
+	}
+}
+
+// decodeCityForm decodes the form values given for a city, leaving out the fields not given.
+// Labels given as comma separated key=value pairs replace all labels of the city.
+func decodeCityForm(r *http.Request) (*cityPatch, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+
+	p := &cityPatch{}
+	if _, ok := r.Form["labels"]; ok {
+		p.Update.Labels = formLabels(&p.Invalid, r, "labels")
+	}
+	for _, field := range []string{"name", "latitude", "longitude", "country_code", "admin_region", "time_zone"} {
+		if _, ok := r.Form[field]; !ok {
+			continue
+		}
+
+		var value interface{} = r.FormValue(field)
+		if field == "latitude" || field == "longitude" {
+			v, err := strconv.ParseFloat(r.FormValue(field), 64)
+			if err != nil {
+				p.Invalid.Add(field, "must be a number, got %q", r.FormValue(field))
+				continue
+			}
+			value = v
+		}
+
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		setCityField(p, field, raw)
+	}
+
+	return p, nil
+}
+
+// decodeMergePatch decodes a JSON Merge Patch of a city. A version member makes the update
+// conditional on that version, the same as the version form value. A labels member is merged
+// into the labels of the city, removing those whose value is null.
+func decodeMergePatch(body io.Reader) (*cityPatch, error) {
+	var members map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&members); err != nil {
+		return nil, fmt.Errorf("merge patch must be a JSON object: %v", err)
+	}
+
+	p := &cityPatch{}
+	for field, raw := range members {
+		if field == "version" {
+			if err := json.Unmarshal(raw, &p.Version); err != nil {
+				p.Invalid.Add(field, "must be a string")
+			}
+			continue
+		}
+
+		if field == "labels" {
+			mergeCityLabels(p, raw)
+			continue
+		}
+
+		setCityField(p, field, raw)
+	}
+
+	return p, nil
+}
+
+// mergeCityLabels merges the labels member of a JSON Merge Patch into the labels of a city.
+// A null member removes all labels.
+func mergeCityLabels(p *cityPatch, raw json.RawMessage) {
+	cu := &p.Update
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		cu.Labels = model.Labels{}
+		return
+	}
+
+	var members map[string]*string
+	if err := json.Unmarshal(raw, &members); err != nil {
+		p.Invalid.Add("labels", "must be an object of strings or null")
+		return
+	}
+
+	for k, v := range members {
+		if v == nil {
+			cu.RemoveLabels = append(cu.RemoveLabels, k)
+			continue
+		}
+
+		if cu.SetLabels == nil {
+			cu.SetLabels = model.Labels{}
+		}
+		cu.SetLabels[k] = *v
+	}
+}
+
+// decodeJSONPatch decodes a JSON Patch of a city, whose operations are kept to be applied to the
+// current city with applyJSONPatch. A test of /version makes the update conditional on that version.
+func decodeJSONPatch(body io.Reader) (*cityPatch, error) {
+	var ops []*jsonPatchOperation
+	if err := json.NewDecoder(body).Decode(&ops); err != nil {
+		return nil, fmt.Errorf("json patch must be an array of operations: %v", err)
+	}
+
+	p := &cityPatch{Ops: []*jsonPatchOperation{}}
+	for _, op := range ops {
+		if op == nil || !strings.HasPrefix(op.Path, "/") {
+			return nil, fmt.Errorf("json patch operation must have a path starting with /")
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("%s operation on %s is missing a value", op.Op, op.Path)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("json patch operation %q is not supported for cities", op.Op)
+		}
+
+		if op.Op == "test" && op.Path == "/version" && p.Version == "" && json.Unmarshal(op.Value, &p.Version) != nil {
+			p.Invalid.Add("version", "must be a string")
+		}
+		p.Ops = append(p.Ops, op)
+	}
+
+	return p, nil
+}
+
+// applyJSONPatch applies the operations of a JSON Patch in order to a working copy of the city,
+// so that every test operation sees the operations before it, and then updates the fields whose
+// values differ in the working copy. Fields are set as a whole or, for labels, under
+// /labels/<key>. Removing country_code or admin_region leaves them empty, removing labels removes
+// all of them and removing time_zone looks it up again from the coordinates of the city, while the
+// other fields cannot be removed. An error wrapping errPatchTestFailed is returned if a test does
+// not hold.
+func applyJSONPatch(p *cityPatch, c *City) error {
+	before, err := cityDocument(c)
+	if err != nil {
+		return err
+	}
+	doc, err := cityDocument(c)
+	if err != nil {
+		return err
+	}
+
+	for _, op := range p.Ops {
+		tokens := strings.Split(op.Path[1:], "/")
+		for i, token := range tokens {
+			tokens[i] = unescapePointer(token)
+		}
+
+		if op.Op == "test" {
+			ok, err := testDocument(doc, tokens, op.Value)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("%w: %s", errPatchTestFailed, op.Path)
+			}
+			continue
+		}
+
+		var value interface{}
+		if op.Op != "remove" {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return err
+			}
+		}
+
+		if len(tokens) == 2 && tokens[0] == "labels" {
+			labels, ok := doc["labels"].(map[string]interface{})
+			if !ok {
+				labels = map[string]interface{}{}
+				doc["labels"] = labels
+			}
+			if op.Op == "remove" {
+				delete(labels, tokens[1])
+			} else {
+				labels[tokens[1]] = value
+			}
+			continue
+		}
+
+		field := op.Path[1:]
+		if len(tokens) != 1 {
+			p.Invalid.Add(field, "is not a field of a city")
+			continue
+		}
+
+		if op.Op != "remove" {
+			doc[field] = value
+			continue
+		}
+
+		switch field {
+		case "country_code", "admin_region":
+			doc[field] = ""
+		case "labels":
+			doc[field] = map[string]interface{}{}
+		case "time_zone":
+			delete(doc, field)
+		case "name", "latitude", "longitude":
+			p.Invalid.Add(field, "cannot be removed")
+		default:
+			doc[field] = nil
+		}
+	}
+
+	if _, ok := doc["time_zone"]; !ok {
+		lat, _ := doc["latitude"].(float64)
+		lon, _ := doc["longitude"].(float64)
+		doc["time_zone"] = timezone.Lookup(lat, lon)
+	}
+
+	fields := make([]string, 0, len(doc))
+	for field := range doc {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		if reflect.DeepEqual(before[field], doc[field]) {
+			continue
+		}
+
+		if field == "labels" {
+			diffCityLabels(p, before[field], doc[field])
+			continue
+		}
+
+		raw, err := json.Marshal(doc[field])
+		if err != nil {
+			return err
+		}
+		setCityField(p, field, raw)
+	}
+
+	return nil
+}
+
+// cityDocument returns the JSON members of a city
+func cityDocument(c *City) (map[string]interface{}, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// diffCityLabels updates the labels of a city that differ between the labels of the city before
+// and after a JSON Patch, setting those that were added or changed and removing the others
+func diffCityLabels(p *cityPatch, before, after interface{}) {
+	labels, ok := after.(map[string]interface{})
+	if !ok {
+		raw, err := json.Marshal(after)
+		if err != nil {
+			p.Invalid.Add("labels", "must be an object of strings or null")
+			return
+		}
+		setCityField(p, "labels", raw)
+		return
+	}
+
+	cu := &p.Update
+	prev, _ := before.(map[string]interface{})
+	for k, v := range labels {
+		if prev[k] == v {
+			continue
+		}
+
+		s, ok := v.(string)
+		if !ok {
+			p.Invalid.Add("labels", "value of %q must be a string", k)
+			continue
+		}
+		if cu.SetLabels == nil {
+			cu.SetLabels = model.Labels{}
+		}
+		cu.SetLabels[k] = s
+	}
+	for k := range prev {
+		if _, ok := labels[k]; !ok {
+			cu.RemoveLabels = append(cu.RemoveLabels, k)
+		}
+	}
+	sort.Strings(cu.RemoveLabels)
+}
+
+// unescapePointer unescapes a reference token of a JSON Pointer (RFC 6901)
+func unescapePointer(token string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+}
+
+// setCityField sets a field of a patched city from its JSON value, recording the field as invalid
+// if the value is of the wrong type. A null value removes country_code and admin_region, leaving
+// them empty, all labels and the time zone, which is looked up again from the coordinates, but
+// is invalid for any other field. The values themselves are validated by the model.
+func setCityField(p *cityPatch, field string, raw json.RawMessage) {
+	cu := &p.Update
+	null := bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+
+	switch field {
+	case "name", "time_zone":
+		if null && field == "time_zone" {
+			cu.TimeZone, p.LookupTimeZone = nil, true
+			return
+		}
+
+		var s string
+		if null || json.Unmarshal(raw, &s) != nil {
+			p.Invalid.Add(field, "must be a string")
+			return
+		}
+		if field == "name" {
+			cu.Name = &s
+		} else {
+			s = strings.TrimSpace(s)
+			cu.TimeZone = &s
+		}
+	case "latitude", "longitude":
+		var v float64
+		if null || json.Unmarshal(raw, &v) != nil {
+			p.Invalid.Add(field, "must be a number")
+			return
+		}
+		if field == "latitude" {
+			cu.Latitude = &v
+		} else {
+			cu.Longitude = &v
+		}
+	case "country_code", "admin_region":
+		var s string
+		if !null && json.Unmarshal(raw, &s) != nil {
+			p.Invalid.Add(field, "must be a string or null")
+			return
+		}
+		s = strings.TrimSpace(s)
+		if field == "country_code" {
+			s = strings.ToUpper(s)
+			cu.CountryCode = &s
+		} else {
+			cu.AdminRegion = &s
+		}
+	case "labels":
+		labels := model.Labels{}
+		if !null && json.Unmarshal(raw, &labels) != nil {
+			p.Invalid.Add(field, "must be an object of strings or null")
+			return
+		}
+		cu.Labels = labels
+	case "id", "version":
+		p.Invalid.Add(field, "cannot be changed")
+	default:
+		p.Invalid.Add(field, "is not a field of a city")
+	}
+}
+
+// testDocument reports whether the member of a JSON document at the path given by the reference
+// tokens of a JSON Pointer holds the given JSON value
+func testDocument(doc map[string]interface{}, tokens []string, raw json.RawMessage) (bool, error) {
+	var current interface{} = doc
+	for _, token := range tokens {
+		members, ok := current.(map[string]interface{})
+		if !ok {
+			return false, nil
+		}
+		if current, ok = members[token]; !ok {
+			return false, nil
+		}
+	}
+
+	var want interface{}
+	if err := json.Unmarshal(raw, &want); err != nil {
+		return false, err
+	}
+
+	return reflect.DeepEqual(current, want), nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
+)
+
+// CityRevision describes a change of a city from its previous version to the current one
+type CityRevision struct {
+	CityID          int64     `json:"city_id"`
+	Version         string    `json:"version"`
+	PreviousVersion string    `json:"previous_version"`
+	RevisedAt       time.Time `json:"revised_at"`
+	Previous        *City     `json:"previous"`
+	Current         *City     `json:"current"`
+}
+
+// CityHistory describes the revisions of a city, most recent first
+type CityHistory struct {
+	CityID    int64           `json:"city_id"`
+	Revisions []*CityRevision `json:"revisions"`
+}
+
+func newCityRevision(cr *model.CityRevision) *CityRevision {
+	return &CityRevision{
+		CityID:          cr.CityID,
+		Version:         cr.Current.Version,
+		PreviousVersion: cr.Previous.Version,
+		RevisedAt:       cr.RevisedAt.UTC(),
+		Previous:        newCity(cr.Previous),
+		Current:         newCity(cr.Current),
+	}
+}
+
+// GetCityHistoryHandler handles a GET request for the revisions of a city
+func (m *Manager) GetCityHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	revisions, err := m.CM.History(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	history := &CityHistory{CityID: int64(id), Revisions: make([]*CityRevision, 0, len(revisions))}
+	for _, cr := range revisions {
+		history.Revisions = append(history.Revisions, newCityRevision(cr))
+	}
+
+	resp, err := json.Marshal(history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// GetCityRevisionHandler handles a GET request for the revision that changed a city to a version
+func (m *Manager) GetCityRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cr, err := m.CM.Revision(int64(id), vars["version"])
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(newCityRevision(cr))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+var cityRevisionRows = []string{"city_id", "version", "previous_version", "revised_at",
+	"name", "latitude", "longitude", "country_code", "admin_region", "time_zone",
+	"previous_name", "previous_latitude", "previous_longitude", "previous_country_code", "previous_admin_region", "previous_time_zone"}
+
+func Test_CanHandleGetCityHistoryRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/history", sm.GetCityHistoryHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("SELECT (.+) FROM cities").WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "name", "latitude", "longitude", "version", "country_code", "admin_region", "time_zone", "labels"}).
+				AddRow(1, "Berlin", 52.52, 13.405, "version-2", "DE", "Berlin", "Europe/Berlin", `{}`),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM city_revisions").WillReturnRows(
+			sqlmock.NewRows(cityRevisionRows).
+				AddRow(1, "version-2", "version-1", time.Now(), "Berlin", 52.52, 13.405, "DE", "Berlin", "Europe/Berlin", "Berlinn", 52.52, 13.405, "DE", "Berlin", "Europe/Berlin"),
+		)
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities/1/history", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make history request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected http status ok but got %v", resp.StatusCode)
+		}
+
+		var history CityHistory
+		if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+			t.Fatalf("could not decode history: %v", err)
+		}
+
+		if len(history.Revisions) != 1 {
+			t.Fatalf("expected 1 revision, got %v", len(history.Revisions))
+		}
+
+		rev := history.Revisions[0]
+		if rev.Version != "version-2" || rev.PreviousVersion != "version-1" {
+			t.Errorf("expected revision from version-1 to version-2, got %v to %v", rev.PreviousVersion, rev.Version)
+		}
+
+		if rev.Previous.Name != "Berlinn" || rev.Current.Name != "Berlin" {
+			t.Errorf("expected rename from Berlinn to Berlin, got %v to %v", rev.Previous.Name, rev.Current.Name)
+		}
+	}, t)
+}
+
+func Test_CannotHandleGetNonExistentCityRevisionRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/history/{version}", sm.GetCityRevisionHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("SELECT (.+) FROM city_revisions").WithArgs(1, "version-9").WillReturnError(sql.ErrNoRows)
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities/1/history/version-9", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make revision request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected status not found, got %v", resp.StatusCode)
+		}
+	}, t)
+}
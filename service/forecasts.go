@@ -2,6 +2,7 @@ package service
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -9,12 +10,116 @@ import (
 	"github.com/shaybix/weather-monster/model"
 )
 
-// Forecast describes the forecast of a given city in the last 24 hours
+// Forecast describes the forecast of a given city for a day in its local time zone, in Unit
 type Forecast struct {
-	CityID int64 `json:"city_id"`
-	Max    int64 `json:"max"`
-	Min    int64 `json:"min"`
-	Sample int64 `json:"sample"`
+	CityID   int64   `json:"city_id"`
+	Date     string  `json:"date"`
+	TimeZone string  `json:"time_zone"`
+	Max      float64 `json:"max"`
+	Min      float64 `json:"min"`
+	Unit     string  `json:"unit"`
+	Sample   int64   `json:"sample"`
+}
+
+// DailyForecast describes the forecasts of a given city for each of its last local days
+type DailyForecast struct {
+	CityID    int64       `json:"city_id"`
+	Forecasts []*Forecast `json:"forecasts"`
+}
+
+// SelectorForecast describes the forecast of the cities matching a label selector along with
+// the forecast of each of them
+type SelectorForecast struct {
+	Selector string      `json:"selector"`
+	Max      float64     `json:"max"`
+	Min      float64     `json:"min"`
+	Unit     string      `json:"unit"`
+	Sample   int64       `json:"sample"`
+	Cities   []*Forecast `json:"cities"`
+}
+
+// defaultForecastDays is the number of days of a daily forecast if none is given
+const defaultForecastDays = 7
+
+// forecastFormat describes how the averages of a forecast are answered: converted to unit and
+// rounded to decimals
+type forecastFormat struct {
+	unit     model.Unit
+	decimals int
+}
+
+// average converts an average temperature in Celsius to the unit of the format and rounds it
+func (ff *forecastFormat) average(c float64) float64 {
+	return model.Round(ff.unit.FromCelsius(c), ff.decimals)
+}
+
+// requestedForecastFormat returns the unit a forecast is requested in along with the number of
+// decimals its averages are rounded to, given by the decimals query parameter or the
+// ForecastDecimals of the manager otherwise
+func (m *Manager) requestedForecastFormat(r *http.Request) (*forecastFormat, error) {
+	unit, _, err := requestedUnit(r)
+	if err != nil {
+		return nil, err
+	}
+
+	decimals, err := m.requestedDecimals(r, model.TemperatureDecimals)
+	if err != nil {
+		return nil, err
+	}
+
+	return &forecastFormat{unit: unit, decimals: decimals}, nil
+}
+
+// requestedDecimals returns the number of decimals, at most max, averages are requested to be
+// rounded to in the decimals query parameter, or the ForecastDecimals of the manager otherwise
+func (m *Manager) requestedDecimals(r *http.Request, max int) (int, error) {
+	v := r.URL.Query().Get("decimals")
+	if v == "" {
+		return m.ForecastDecimals, nil
+	}
+
+	d, err := strconv.Atoi(v)
+	if err != nil || d < 0 || d > max {
+		return 0, fmt.Errorf("decimals must be between 0 and %d, got %q", max, v)
+	}
+
+	return d, nil
+}
+
+// requestedForecastFilter returns the temperatures a forecast is requested to be computed from:
+// those of the station given by station_id, if any, weighted by the weight of their station if
+// weighted=true is given
+func requestedForecastFilter(r *http.Request) (*model.ForecastFilter, error) {
+	filter := &model.ForecastFilter{}
+	if v := r.URL.Query().Get("station_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("station_id must be a positive integer, got %q", v)
+		}
+		filter.StationID = id
+	}
+
+	if v := r.URL.Query().Get("weighted"); v != "" {
+		weighted, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("weighted must be true or false, got %q", v)
+		}
+		filter.Weighted = weighted
+	}
+
+	return filter, nil
+}
+
+func newForecast(f *model.Forecast, ff *forecastFormat) *Forecast {
+	return &Forecast{
+		CityID:   f.CityID,
+		Date:     f.Date,
+		TimeZone: f.TimeZone,
+		Max:      ff.average(f.Max),
+		Min:      ff.average(f.Min),
+		Unit:     string(ff.unit),
+		Sample:   f.Sample,
+	}
 }
 
 // GetForecastHandler handles GET requests for forecasts for a specific city
@@ -28,7 +133,72 @@ func (m *Manager) GetForecastHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	f, err := m.FM.Get(int64(id))
+	ff, err := m.requestedForecastFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := requestedForecastFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := m.FM.Get(int64(id), filter)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(newForecast(f, ff))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// GetDailyForecastHandler handles GET requests for the forecasts of each of the last days of a specific city
+func (m *Manager) GetDailyForecastHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ff, err := m.requestedForecastFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	days := defaultForecastDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 1 || days > model.MaxForecastDays {
+			http.Error(w, fmt.Sprintf("days must be an integer between 1 and %d", model.MaxForecastDays), http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter, err := requestedForecastFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fs, err := m.FM.Daily(int64(id), days, filter)
 	if err != nil {
 		if err == model.ErrNotFound {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -39,14 +209,71 @@ func (m *Manager) GetForecastHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fc := &Forecast{
-		CityID: f.CityID,
-		Max:    f.Max,
-		Min:    f.Min,
-		Sample: f.Sample,
+	df := &DailyForecast{
+		CityID:    int64(id),
+		Forecasts: make([]*Forecast, 0, len(fs)),
+	}
+	for _, f := range fs {
+		df.Forecasts = append(df.Forecasts, newForecast(f, ff))
+	}
+
+	b, err := json.Marshal(df)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// GetSelectorForecastHandler handles GET requests for the forecast of the cities matching the
+// label selector given in the selector parameter, covering the current local day of each of them
+func (m *Manager) GetSelectorForecastHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	v := r.URL.Query().Get("selector")
+	if v == "" {
+		http.Error(w, "selector is required", http.StatusBadRequest)
+		return
+	}
+
+	sel, err := model.ParseSelector(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ff, err := m.requestedForecastFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sf, err := m.FM.Select(sel)
+	if err != nil {
+		if err == model.ErrTooManyCities {
+			http.Error(w, fmt.Sprintf("%v, at most %d are allowed", err, model.MaxSelectedCities), http.StatusBadRequest)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	forecast := &SelectorForecast{
+		Selector: sf.Selector,
+		Max:      ff.average(sf.Max),
+		Min:      ff.average(sf.Min),
+		Unit:     string(ff.unit),
+		Sample:   sf.Sample,
+		Cities:   make([]*Forecast, 0, len(sf.Cities)),
+	}
+	for _, f := range sf.Cities {
+		forecast.Cities = append(forecast.Cities, newForecast(f, ff))
 	}
 
-	b, err := json.Marshal(fc)
+	b, err := json.Marshal(forecast)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -2,10 +2,12 @@ package service
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gorilla/mux"
@@ -31,8 +33,12 @@ func Test_CannotHandleGetForecastRequestWithNonExistentTemperatures(t *testing.T
 
 		client := &http.Client{}
 
-		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id"}
-		mock.ExpectQuery("SELECT *").WithArgs(1).WillReturnRows(sqlmock.NewRows(expectedRows))
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("Europe/Berlin"),
+		)
+
+		expectedRows := []string{"min", "max", "timestamp", "count"}
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").WillReturnRows(sqlmock.NewRows(expectedRows))
 
 		resp, err := client.Do(req)
 		if err != nil {
@@ -76,3 +82,146 @@ func Test_CannotHandleGetForecastRequestWithNonExistentCity(t *testing.T) {
 		}
 	}, t)
 }
+
+func Test_CanHandleGetDailyForecastRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/forecasts/{id}/daily", sm.GetDailyForecastHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		url := fmt.Sprintf("%s/forecasts/%s/daily?days=3", ts.URL, "1")
+
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("Asia/Tokyo"),
+		)
+
+		expectedRows := []string{"min", "max", "timestamp", "count"}
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").WillReturnRows(sqlmock.NewRows(expectedRows))
+
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok got %v", resp.StatusCode)
+		}
+
+		var df DailyForecast
+		if err := json.NewDecoder(resp.Body).Decode(&df); err != nil {
+			t.Fatalf("could not decode response: %v", err)
+		}
+
+		if len(df.Forecasts) != 3 || df.Forecasts[2].TimeZone != "Asia/Tokyo" {
+			t.Errorf("expected forecasts of three days in Asia/Tokyo, got %+v", df.Forecasts)
+		}
+	}, t)
+}
+
+func Test_CanHandleGetSelectorForecastRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/forecasts", sm.GetSelectorForecastHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("SELECT ID, time_zone FROM cities").WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "time_zone"}).AddRow(1, "Europe/Berlin"),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").WillReturnRows(
+			sqlmock.NewRows([]string{"min", "max", "timestamp", "count"}),
+		)
+
+		resp, err := http.Get(fmt.Sprintf("%s/forecasts?selector=tier%%3Dgold", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok got %v", resp.StatusCode)
+		}
+
+		var sf SelectorForecast
+		if err := json.NewDecoder(resp.Body).Decode(&sf); err != nil {
+			t.Fatalf("could not decode forecast: %v", err)
+		}
+
+		if sf.Selector != "tier=gold" || len(sf.Cities) != 1 {
+			t.Errorf("expected the forecast of the single city labelled tier=gold, got %+v", sf)
+		}
+	}, t)
+}
+
+func Test_CannotHandleGetSelectorForecastRequestWithoutSelector(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/forecasts", sm.GetSelectorForecastHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		resp, err := http.Get(fmt.Sprintf("%s/forecasts", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status bad request got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleGetForecastRequestInRequestedUnit(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/forecasts/{id}", sm.GetForecastHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/forecasts/%s?decimals=2", ts.URL, "1"), nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Accept", "application/json; units=kelvin")
+
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("UTC"),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").WillReturnRows(
+			sqlmock.NewRows([]string{"min", "max", "timestamp", "count"}).AddRow(10, 20, time.Now().Unix(), 1),
+		)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok got %v", resp.StatusCode)
+		}
+
+		var f Forecast
+		if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+			t.Fatalf("could not decode forecast: %v", err)
+		}
+
+		if f.Unit != "kelvin" || f.Min != 283.15 || f.Max != 293.15 {
+			t.Errorf("expected the forecast in kelvin, got %+v", f)
+		}
+	}, t)
+}
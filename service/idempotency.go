@@ -0,0 +1,167 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/shaybix/weather-monster/model"
+)
+
+const (
+	// idempotencyKeyHeader carries the key a client identifies retries of a POST request by
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks a response replayed for a retried request
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotentBodyBytes is the maximum size of the body of a request with an idempotency key
+	maxIdempotentBodyBytes = 4 << 20
+)
+
+// hopByHopHeaders are the headers that only apply to a single connection, which are not stored
+// along with a response to be replayed on another
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// responseRecorder records the status and body of a response while writing it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader records the status of the response
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the body of the response
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// Idempotent is a middleware honouring the Idempotency-Key header of POST requests. The response
+// of the first request with a key is stored for the idempotency window and replayed to every
+// retry, without making the request again. Reusing a key for a different request, or retrying
+// before the first request completed, is answered with 409 Conflict. Failed requests answered
+// with a server error are not stored, so that they can be retried, and neither are responses
+// marked Cache-Control: no-store, such as those issuing credentials, whose retries are made again.
+func (m *Manager) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > model.MaxIdempotencyKeyLength {
+			http.Error(w, fmt.Sprintf("%s must not be longer than %d characters", idempotencyKeyHeader, model.MaxIdempotencyKeyLength), http.StatusBadRequest)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBodyBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		stored, err := m.IM.Claim(key, requestFingerprint(r, body), m.IdempotencyWindow)
+		if err != nil {
+			if err == model.ErrIdempotencyKeyReused || err == model.ErrIdempotencyKeyInProgress {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if stored != nil {
+			for name, values := range stored.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(idempotentReplayedHeader, "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		rr := &responseRecorder{ResponseWriter: w}
+		defer func() {
+			if rr.status == 0 || rr.status >= http.StatusInternalServerError || noStore(w.Header()) {
+				if err := m.IM.Release(key); err != nil {
+					log.Println(err)
+				}
+				return
+			}
+
+			resp := &model.IdempotentResponse{
+				Status: rr.status,
+				Header: endToEndHeader(w.Header()),
+				Body:   rr.body.Bytes(),
+			}
+			if err := m.IM.Complete(key, resp); err != nil {
+				log.Println(err)
+			}
+		}()
+
+		next.ServeHTTP(rr, r)
+	})
+}
+
+// endToEndHeader returns a copy of the header of a response without its hop-by-hop headers,
+// including those named by its Connection header
+func endToEndHeader(h http.Header) http.Header {
+	header := h.Clone()
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+
+	return header
+}
+
+// noStore reports whether the Cache-Control header of a response forbids storing it
+func noStore(h http.Header) bool {
+	for _, v := range h["Cache-Control"] {
+		for _, directive := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-store") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// requestFingerprint identifies a request by its method, URL, content type, credentials and body,
+// telling apart different requests made with the same idempotency key, so that a response is only
+// replayed to the credentials it was answered to
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n%s\n%s\n", r.Method, r.URL.RequestURI(), r.Header.Get("Content-Type"), r.Header.Get("Authorization"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
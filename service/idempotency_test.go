@@ -0,0 +1,170 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+func Test_CanReplayResponseOfRetriedRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		calls := 0
+		r := mux.NewRouter()
+		r.Use(sm.Idempotent)
+		r.HandleFunc("/temperatures", func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"version-1"`)
+			w.Header().Set("Keep-Alive", "timeout=5")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":1}`))
+		}).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		// the hop-by-hop Keep-Alive header is not stored
+		header := []byte(`{"Content-Type":["application/json"],"Etag":["\"version-1\""]}`)
+		mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("key-1"))
+		mock.ExpectExec("UPDATE idempotency_keys").
+			WithArgs("key-1", http.StatusCreated, header, []byte(`{"id":1}`)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnRows(sqlmock.NewRows([]string{"key"}))
+
+		for i := 0; i < 2; i++ {
+			req, err := http.NewRequest("POST", fmt.Sprintf("%s/temperatures", ts.URL), strings.NewReader("city_id=1&min=20&max=25"))
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Idempotency-Key", "key-1")
+
+			if i == 1 {
+				// the fingerprint of the retry is the same as the one of the first request
+				mock.ExpectQuery("SELECT (.+) FROM idempotency_keys").WillReturnRows(
+					sqlmock.NewRows([]string{"fingerprint", "status", "headers", "body"}).
+						AddRow(requestFingerprint(req, []byte("city_id=1&min=20&max=25")), http.StatusCreated, header, []byte(`{"id":1}`)),
+				)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("could not make request: %v", err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusCreated || string(body) != `{"id":1}` {
+				t.Errorf("expected the created temperature, got %v %s", resp.StatusCode, body)
+			}
+
+			if etag := resp.Header.Get("ETag"); etag != `"version-1"` {
+				t.Errorf("expected the ETag of the created temperature, got %q", etag)
+			}
+
+			if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected a JSON response, got %q", ct)
+			}
+		}
+
+		if calls != 1 {
+			t.Errorf("expected the request to be made once, got %d", calls)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expected the response to be stored and replayed: %v", err)
+		}
+	}, t)
+}
+
+func Test_CannotStoreStationTokenForRetriedRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+		sm.AdminToken = "admin-secret"
+
+		r := mux.NewRouter()
+		r.Use(sm.Idempotent)
+		r.HandleFunc("/stations", sm.CreateStationHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		// the claim of the key is released rather than completed with the body holding the token
+		mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("key-1"))
+		mock.ExpectQuery("INSERT INTO stations").WillReturnRows(
+			sqlmock.NewRows(stationRows).AddRow(1, 1, 52.52, 13.405, nil, "", 1.0, time.Now(), nil),
+		)
+		mock.ExpectExec("DELETE FROM idempotency_keys").WithArgs("key-1").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s/stations", ts.URL), strings.NewReader("city_id=1&latitude=52.52&longitude=13.405"))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+sm.AdminToken)
+		req.Header.Set("Idempotency-Key", "key-1")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status created got %v", resp.StatusCode)
+		}
+
+		if cc := resp.Header.Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("expected the credentials not to be stored, got Cache-Control %q", cc)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expected the token not to be stored: %v", err)
+		}
+	}, t)
+}
+
+func Test_CannotReuseIdempotencyKeyForDifferentRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.Use(sm.Idempotent)
+		r.HandleFunc("/temperatures", sm.CreateTemperatureHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("INSERT INTO idempotency_keys").WillReturnRows(sqlmock.NewRows([]string{"key"}))
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_keys").WillReturnRows(
+			sqlmock.NewRows([]string{"fingerprint", "status", "headers", "body"}).
+				AddRow("fingerprint-of-another-request", http.StatusCreated, []byte(`{"Content-Type":["application/json"]}`), []byte(`{"id":1}`)),
+		)
+
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s/temperatures", ts.URL), strings.NewReader("city_id=1&min=20&max=25"))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Idempotency-Key", "key-1")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusConflict {
+			t.Errorf("expected status conflict, got %v", resp.StatusCode)
+		}
+	}, t)
+}
@@ -0,0 +1,297 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
+)
+
+// Observation describes the value of a metric observed in a city at a specific point in time, in
+// Unit. Timestamp is the Unix time of ObservedAt.
+type Observation struct {
+	ID         int64   `json:"id"`
+	CityID     int64   `json:"city_id"`
+	Metric     string  `json:"metric"`
+	Value      float64 `json:"value"`
+	Unit       string  `json:"unit"`
+	Timestamp  int64   `json:"timestamp"`
+	ObservedAt string  `json:"observed_at"`
+	ReceivedAt string  `json:"received_at"`
+}
+
+// ObservationList describes a page of the observations of a city and the cursor to retrieve the next page
+type ObservationList struct {
+	Observations []*Observation `json:"observations"`
+	NextCursor   string         `json:"next_cursor,omitempty"`
+}
+
+// MetricsForecast describes the aggregates of the observations of each metric in a city on its
+// current local day
+type MetricsForecast struct {
+	CityID   int64              `json:"city_id"`
+	Date     string             `json:"date"`
+	TimeZone string             `json:"time_zone"`
+	Metrics  []*MetricAggregate `json:"metrics"`
+}
+
+// MetricAggregate describes the observations of a metric on a day in Unit. Total is only given
+// for cumulative metrics like precipitation.
+type MetricAggregate struct {
+	Metric  string   `json:"metric"`
+	Min     float64  `json:"min"`
+	Max     float64  `json:"max"`
+	Average float64  `json:"average"`
+	Total   *float64 `json:"total,omitempty"`
+	Unit    string   `json:"unit"`
+	Sample  int64    `json:"sample"`
+}
+
+// newObservation converts an observation to unit, which must be a unit of its metric, or to the
+// base unit of its metric if unit is empty
+func newObservation(o *model.Observation, unit model.MetricUnit) *Observation {
+	if unit == "" {
+		unit = o.Metric.BaseUnit()
+	}
+
+	return &Observation{
+		ID:         o.ID,
+		CityID:     o.CityID,
+		Metric:     string(o.Metric),
+		Value:      model.Round(unit.FromBase(o.Value), model.ObservationDecimals),
+		Unit:       string(unit),
+		Timestamp:  o.Timestamp,
+		ObservedAt: time.Unix(o.Timestamp, 0).UTC().Format(time.RFC3339),
+		ReceivedAt: time.Unix(o.ReceivedAt, 0).UTC().Format(time.RFC3339),
+	}
+}
+
+// newMetricAggregate converts the aggregate of a metric to unit, or to the base unit of the
+// metric if unit is empty, rounding it to decimals
+func newMetricAggregate(agg *model.MetricAggregate, unit model.MetricUnit, decimals int) *MetricAggregate {
+	if unit == "" {
+		unit = agg.Metric.BaseUnit()
+	}
+
+	round := func(v float64) float64 {
+		return model.Round(unit.FromBase(v), decimals)
+	}
+
+	ma := &MetricAggregate{
+		Metric:  string(agg.Metric),
+		Min:     round(agg.Min),
+		Max:     round(agg.Max),
+		Average: round(agg.Average),
+		Unit:    string(unit),
+		Sample:  agg.Sample,
+	}
+	if agg.Metric.Cumulative() {
+		total := round(agg.Total)
+		ma.Total = &total
+	}
+
+	return ma
+}
+
+// CreateObservationHandler creates an observation of a metric for a specific city. The
+// observation is answered in the unit it is given in.
+func (m *Manager) CreateObservationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ve model.ValidationError
+	no := &model.NewObservation{
+		CityID: formInt(&ve, r, "city_id"),
+		Metric: formMetric(&ve, r, "metric"),
+		Value:  formFloat(&ve, r, "value"),
+	}
+	no.Unit = formMetricUnit(&ve, r, "unit", no.Metric)
+	no.ObservedAt = formTime(&ve, r, "observed_at")
+
+	if err := validate(&ve, no); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	o, err := m.OM.Create(no)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(newObservation(o, no.Unit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(resp)
+}
+
+// ListObservationsHandler handles a GET request for a page of the observations of a city observed
+// within the RFC 3339 times from and to, ordered by the time they were observed at. Observations
+// are answered in the base unit of their metric, unless the metric is given along with a unit.
+func (m *Manager) ListObservationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var limit int
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > model.MaxObservationLimit {
+			http.Error(w, fmt.Sprintf("limit must be an integer between 1 and %d", model.MaxObservationLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	var ve model.ValidationError
+	oq := &model.ObservationQuery{
+		CityID: int64(id),
+		From:   formTime(&ve, r, "from"),
+		To:     formTime(&ve, r, "to"),
+		Limit:  limit,
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+	if !oq.From.IsZero() && !oq.To.IsZero() && !oq.From.Before(oq.To) {
+		ve.Add("to", "must be after from")
+	}
+	unit := requestedMetricUnit(&ve, r, &oq.Metric)
+
+	if err := ve.Err(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	page, err := m.OM.List(oq)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err == model.ErrInvalidCursor {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ol := &ObservationList{
+		Observations: make([]*Observation, 0, len(page.Observations)),
+		NextCursor:   page.NextCursor,
+	}
+	for _, o := range page.Observations {
+		ol.Observations = append(ol.Observations, newObservation(o, unit))
+	}
+
+	resp, err := json.Marshal(ol)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if page.NextCursor != "" {
+		w.Header().Set("Link", nextLink(r.URL, page.NextCursor))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// GetMetricsForecastHandler handles GET requests for the aggregates of the observations of each
+// metric of a specific city on its current local day, or of the single metric given
+func (m *Manager) GetMetricsForecastHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ve model.ValidationError
+	var metric model.Metric
+	unit := requestedMetricUnit(&ve, r, &metric)
+	decimals, err := m.requestedDecimals(r, model.ObservationDecimals)
+	if err != nil {
+		ve.Add("decimals", "%v", err)
+	}
+
+	if err := ve.Err(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	mf, err := m.FM.Metrics(int64(id), metric)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	forecast := &MetricsForecast{
+		CityID:   mf.CityID,
+		Date:     mf.Date,
+		TimeZone: mf.TimeZone,
+		Metrics:  make([]*MetricAggregate, 0, len(mf.Metrics)),
+	}
+	for _, agg := range mf.Metrics {
+		forecast.Metrics = append(forecast.Metrics, newMetricAggregate(agg, unit, decimals))
+	}
+
+	b, err := json.Marshal(forecast)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// requestedMetricUnit parses the optional metric query parameter into metric and returns the unit
+// of the metric given in the unit query parameter, which is empty if the unit is not given.
+// A unit requires the metric it belongs to.
+func requestedMetricUnit(ve *model.ValidationError, r *http.Request, metric *model.Metric) model.MetricUnit {
+	if r.URL.Query().Get("metric") != "" {
+		*metric = formMetric(ve, r, "metric")
+	}
+
+	if r.URL.Query().Get("unit") == "" {
+		return ""
+	}
+	if *metric == "" {
+		if !ve.Has("metric") {
+			ve.Add("unit", "requires a metric")
+		}
+		return ""
+	}
+
+	return formMetricUnit(ve, r, "unit", *metric)
+}
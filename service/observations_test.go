@@ -0,0 +1,171 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
+)
+
+var observationRows = []string{"ID", "city_id", "metric", "value", "timestamp", "received_at"}
+
+func Test_CanHandleCreateObservationRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/observations", sm.CreateObservationHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("INSERT INTO observations").
+			WithArgs(1, "wind_speed", 10.0, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(observationRows).AddRow(1, 1, "wind_speed", 10.0, time.Now().Unix(), time.Now().Unix()))
+
+		f := url.Values{}
+		f.Add("city_id", "1")
+		f.Add("metric", "wind_speed")
+		f.Add("value", "36")
+		f.Add("unit", "km/h")
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/observations", ts.URL), f)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status created got %v", resp.StatusCode)
+		}
+
+		var o Observation
+		if err := json.NewDecoder(resp.Body).Decode(&o); err != nil {
+			t.Fatalf("could not decode observation: %v", err)
+		}
+
+		if o.Value != 36 || o.Unit != "km/h" {
+			t.Errorf("expected the observation in the unit it was given in, got %+v", o)
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateObservationRequestWithInvalidUnit(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/observations", sm.CreateObservationHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		f := url.Values{}
+		f.Add("city_id", "1")
+		f.Add("metric", "humidity")
+		f.Add("value", "55")
+		f.Add("unit", "mm")
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/observations", ts.URL), f)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request got %v", resp.StatusCode)
+		}
+
+		var body ValidationErrors
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("could not decode errors: %v", err)
+		}
+
+		if len(body.Errors) != 1 || body.Errors[0].Field != "unit" {
+			t.Errorf("expected only the unit to be invalid, got %+v", body.Errors)
+		}
+	}, t)
+}
+
+func Test_CanHandleListObservationsRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/observations", sm.ListObservationsHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		now := time.Now().Unix()
+		mock.ExpectQuery("SELECT (.+) FROM observations").WithArgs(1, "precipitation", model.DefaultObservationLimit+1).
+			WillReturnRows(sqlmock.NewRows(observationRows).AddRow(1, 1, "precipitation", 25.4, now, now))
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities/1/observations?metric=precipitation&unit=in", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok got %v", resp.StatusCode)
+		}
+
+		var list ObservationList
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatalf("could not decode observations: %v", err)
+		}
+
+		if len(list.Observations) != 1 || list.Observations[0].Value != 1 || list.Observations[0].Unit != "in" {
+			t.Errorf("expected a single observation of an inch, got %+v", list.Observations)
+		}
+	}, t)
+}
+
+func Test_CanHandleGetMetricsForecastRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/forecasts/{id}/metrics", sm.GetMetricsForecastHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("UTC"),
+		)
+		mock.ExpectQuery("SELECT metric, value FROM observations").
+			WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), "precipitation").
+			WillReturnRows(sqlmock.NewRows([]string{"metric", "value"}).
+				AddRow("precipitation", 1.5).
+				AddRow("precipitation", 2.5))
+
+		resp, err := http.Get(fmt.Sprintf("%s/forecasts/1/metrics?metric=precipitation", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok got %v", resp.StatusCode)
+		}
+
+		var f MetricsForecast
+		if err := json.NewDecoder(resp.Body).Decode(&f); err != nil {
+			t.Fatalf("could not decode forecast: %v", err)
+		}
+
+		if len(f.Metrics) != 1 || f.Metrics[0].Total == nil || *f.Metrics[0].Total != 4 || f.Metrics[0].Average != 2 {
+			t.Errorf("expected the precipitation to total 4 mm, got %+v", f.Metrics)
+		}
+	}, t)
+}
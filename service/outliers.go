@@ -0,0 +1,161 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
+)
+
+// OutlierPolicy describes how the new temperatures of a city scoring above Threshold against its
+// recent temperatures are handled: accepted, flagged or rejected
+type OutlierPolicy struct {
+	CityID    int64   `json:"city_id"`
+	Action    string  `json:"action"`
+	Threshold float64 `json:"threshold"`
+}
+
+// FlaggedTemperatureList describes the most recent temperatures flagged as outliers
+type FlaggedTemperatureList struct {
+	Temperatures []*Temperature `json:"temperatures"`
+}
+
+// GetOutlierPolicyHandler handles a GET request for the outlier policy of a city
+func (m *Manager) GetOutlierPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := m.TM.OutlierPolicy(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeOutlierPolicy(w, p)
+}
+
+// SetOutlierPolicyHandler handles a PUT request to set the outlier policy of a city. The
+// threshold defaults to model.DefaultOutlierThreshold if not given.
+func (m *Manager) SetOutlierPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ve model.ValidationError
+	p := &model.OutlierPolicy{
+		CityID:    int64(id),
+		Action:    model.OutlierAction(strings.ToLower(strings.TrimSpace(r.FormValue("action")))),
+		Threshold: model.DefaultOutlierThreshold,
+	}
+	if r.FormValue("threshold") != "" {
+		p.Threshold = formFloat(&ve, r, "threshold")
+	}
+
+	if err := validate(&ve, p); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	p, err = m.TM.SetOutlierPolicy(p)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeOutlierPolicy(w, p)
+}
+
+// ListFlaggedTemperaturesHandler handles a GET request for the most recent temperatures flagged
+// as outliers, of all cities or of the one given by city_id
+func (m *Manager) ListFlaggedTemperaturesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var cid int64
+	if v := r.URL.Query().Get("city_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "city_id must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		cid = id
+	}
+
+	var limit int
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > model.MaxFlaggedLimit {
+			http.Error(w, fmt.Sprintf("limit must be an integer between 1 and %d", model.MaxFlaggedLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	unit, _, err := requestedUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	temps, err := m.TM.Flagged(cid, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := &FlaggedTemperatureList{Temperatures: make([]*Temperature, 0, len(temps))}
+	for _, temp := range temps {
+		list.Temperatures = append(list.Temperatures, newTemperature(temp, unit))
+	}
+
+	resp, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+func writeOutlierPolicy(w http.ResponseWriter, p *model.OutlierPolicy) {
+	resp, err := json.Marshal(&OutlierPolicy{
+		CityID:    p.CityID,
+		Action:    string(p.Action),
+		Threshold: p.Threshold,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
+)
+
+func Test_CannotHandleCreateTemperatureRequestRejectedAsOutlier(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures", sm.CreateTemperatureHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		history := sqlmock.NewRows(outlierRows)
+		for i := 0; i < model.MinOutlierHistory; i++ {
+			history.AddRow("reject", model.DefaultOutlierThreshold, 10, 20)
+		}
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WillReturnRows(history)
+
+		f := url.Values{}
+		f.Add("city_id", "1")
+		f.Add("min", "900")
+		f.Add("max", "905")
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/temperatures", ts.URL), f)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("expected status unprocessable entity got %v", resp.StatusCode)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expected the temperature not to be stored: %v", err)
+		}
+	}, t)
+}
+
+func Test_CanHandleSetOutlierPolicyRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/outlier_policy", sm.SetOutlierPolicyHandler).Methods("PUT")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("INSERT INTO outlier_policies").WithArgs(1, "flag", model.DefaultOutlierThreshold).WillReturnRows(
+			sqlmock.NewRows([]string{"city_id", "action", "threshold"}).AddRow(1, "flag", model.DefaultOutlierThreshold),
+		)
+
+		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/cities/1/outlier_policy", ts.URL), strings.NewReader("action=flag"))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok got %v", resp.StatusCode)
+		}
+
+		var p OutlierPolicy
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatalf("could not decode policy: %v", err)
+		}
+
+		if p.Action != "flag" || p.Threshold != model.DefaultOutlierThreshold {
+			t.Errorf("expected outliers above the default threshold to be flagged, got %+v", p)
+		}
+	}, t)
+}
+
+func Test_CannotHandleSetOutlierPolicyRequestWithInvalidAction(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/outlier_policy", sm.SetOutlierPolicyHandler).Methods("PUT")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/cities/1/outlier_policy", ts.URL), strings.NewReader("action=ignore&threshold=-1"))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status bad request got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleListFlaggedTemperaturesRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures/flagged", sm.ListFlaggedTemperaturesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("SELECT (.+) FROM temperatures t JOIN cities c (.+) WHERE t.flagged").WithArgs(model.DefaultFlaggedLimit).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}).
+				AddRow(7, 900, 905, time.Now().Unix(), 1, time.Now().Unix(), true, 597.01, nil),
+		)
+
+		resp, err := http.Get(fmt.Sprintf("%s/temperatures/flagged", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok got %v", resp.StatusCode)
+		}
+
+		var list FlaggedTemperatureList
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatalf("could not decode temperatures: %v", err)
+		}
+
+		if len(list.Temperatures) != 1 || !list.Temperatures[0].Flagged || list.Temperatures[0].OutlierScore == nil {
+			t.Errorf("expected a single flagged temperature with its score, got %+v", list.Temperatures)
+		}
+	}, t)
+}
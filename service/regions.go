@@ -0,0 +1,307 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
+)
+
+// Region describes a named group of cities and the IDs of its members
+type Region struct {
+	ID      int64   `json:"id"`
+	Name    string  `json:"name"`
+	CityIDs []int64 `json:"city_ids"`
+}
+
+// RegionList describes all regions
+type RegionList struct {
+	Regions []*Region `json:"regions"`
+}
+
+// RegionForecast describes the forecast of a region across its member cities along with the
+// forecast of each of them
+type RegionForecast struct {
+	RegionID int64       `json:"region_id"`
+	Max      float64     `json:"max"`
+	Min      float64     `json:"min"`
+	Unit     string      `json:"unit"`
+	Sample   int64       `json:"sample"`
+	Cities   []*Forecast `json:"cities"`
+}
+
+func newRegion(r *model.Region) *Region {
+	ids := r.CityIDs
+	if ids == nil {
+		ids = []int64{}
+	}
+
+	return &Region{
+		ID:      r.ID,
+		Name:    r.Name,
+		CityIDs: ids,
+	}
+}
+
+// CreateRegionHandler handles a POST request to create a region, naming its member cities
+// in any number of city_id fields
+func (m *Manager) CreateRegionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ve model.ValidationError
+	nr := &model.NewRegion{Name: strings.TrimSpace(r.FormValue("name"))}
+	for _, v := range r.Form["city_id"] {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			ve.Add("city_id", "must be an integer, got %q", v)
+			continue
+		}
+		nr.CityIDs = append(nr.CityIDs, id)
+	}
+
+	if err := validate(&ve, nr); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	region, err := m.RM.Create(nr)
+	if err != nil {
+		if err == model.ErrAlreadyExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		if _, ok := err.(*model.ValidationError); ok {
+			writeValidationError(w, err)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeRegion(w, http.StatusCreated, region)
+}
+
+// ListRegionsHandler handles a GET request to list all regions
+func (m *Manager) ListRegionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	regions, err := m.RM.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := &RegionList{Regions: make([]*Region, 0, len(regions))}
+	for _, region := range regions {
+		list.Regions = append(list.Regions, newRegion(region))
+	}
+
+	resp, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// GetRegionHandler handles a GET request to read a region
+func (m *Manager) GetRegionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	region, err := m.RM.Get(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeRegion(w, http.StatusOK, region)
+}
+
+// UpdateRegionHandler handles a PATCH request to rename a region
+func (m *Manager) UpdateRegionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	region, err := m.RM.Rename(int64(id), strings.TrimSpace(r.FormValue("name")))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err == model.ErrAlreadyExists {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		if _, ok := err.(*model.ValidationError); ok {
+			writeValidationError(w, err)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeRegion(w, http.StatusOK, region)
+}
+
+// DeleteRegionHandler handles a DELETE request to delete a region, leaving its cities as they are
+func (m *Manager) DeleteRegionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	region, err := m.RM.Delete(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeRegion(w, http.StatusOK, region)
+}
+
+// AddRegionCityHandler handles a PUT request to make a city a member of a region
+func (m *Manager) AddRegionCityHandler(w http.ResponseWriter, r *http.Request) {
+	m.changeRegionMembership(w, r, m.RM.AddCity)
+}
+
+// RemoveRegionCityHandler handles a DELETE request to remove a city from the members of a region
+func (m *Manager) RemoveRegionCityHandler(w http.ResponseWriter, r *http.Request) {
+	m.changeRegionMembership(w, r, m.RM.RemoveCity)
+}
+
+// changeRegionMembership adds or removes the city of a /regions/{id}/cities/{city_id} request
+func (m *Manager) changeRegionMembership(w http.ResponseWriter, r *http.Request, change func(id, cityID int64) (*model.Region, error)) {
+	w.Header().Set("Content-Type", "application/json")
+
+	vars := mux.Vars(r)
+
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cid, err := strconv.Atoi(vars["city_id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	region, err := change(int64(id), int64(cid))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeRegion(w, http.StatusOK, region)
+}
+
+// GetRegionForecastHandler handles a GET request for the forecast of a region, covering the
+// current local day of each of its member cities
+func (m *Manager) GetRegionForecastHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ff, err := m.requestedForecastFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rf, err := m.FM.Region(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	forecast := &RegionForecast{
+		RegionID: rf.RegionID,
+		Max:      ff.average(rf.Max),
+		Min:      ff.average(rf.Min),
+		Unit:     string(ff.unit),
+		Sample:   rf.Sample,
+		Cities:   make([]*Forecast, 0, len(rf.Cities)),
+	}
+	for _, f := range rf.Cities {
+		forecast.Cities = append(forecast.Cities, newForecast(f, ff))
+	}
+
+	resp, err := json.Marshal(forecast)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+func writeRegion(w http.ResponseWriter, status int, region *model.Region) {
+	resp, err := json.Marshal(newRegion(region))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(status)
+	w.Write(resp)
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+func Test_CanHandleCreateRegionRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/regions", sm.CreateRegionHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO regions").WillReturnRows(sqlmock.NewRows([]string{"ID"}).AddRow(1))
+		mock.ExpectExec("INSERT INTO region_cities").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+		mock.ExpectQuery("SELECT (.+) FROM regions r").WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "name", "city_ids"}).AddRow(1, "Brandenburg", "{3,4}"),
+		)
+
+		form := url.Values{"name": {"Brandenburg"}, "city_id": {"3", "4"}}
+		resp, err := http.PostForm(fmt.Sprintf("%s/regions", ts.URL), form)
+		if err != nil {
+			t.Fatalf("could not make create request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status created, got %v", resp.StatusCode)
+		}
+
+		var region Region
+		if err := json.NewDecoder(resp.Body).Decode(&region); err != nil {
+			t.Fatalf("could not decode region: %v", err)
+		}
+
+		if len(region.CityIDs) != 2 {
+			t.Errorf("expected 2 member cities, got %v", region.CityIDs)
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateRegionRequestWithoutName(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/regions", sm.CreateRegionHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/regions", ts.URL), url.Values{"city_id": {"x"}})
+		if err != nil {
+			t.Fatalf("could not make create request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request, got %v", resp.StatusCode)
+		}
+
+		var body ValidationErrors
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("could not decode validation errors: %v", err)
+		}
+
+		if len(body.Errors) != 2 {
+			t.Errorf("expected name and city_id to be reported as invalid, got %+v", body.Errors)
+		}
+	}, t)
+}
+
+func Test_CanHandleGetRegionForecastRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/regions/{id}/forecast", sm.GetRegionForecastHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("SELECT c.ID, c.time_zone FROM regions").WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "time_zone"}).AddRow(1, "Europe/Berlin"),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").WillReturnRows(
+			sqlmock.NewRows([]string{"min", "max", "timestamp", "count"}).AddRow(10, 20, time.Now().Unix(), 1),
+		)
+
+		resp, err := http.Get(fmt.Sprintf("%s/regions/1/forecast", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make forecast request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok, got %v", resp.StatusCode)
+		}
+
+		var rf RegionForecast
+		if err := json.NewDecoder(resp.Body).Decode(&rf); err != nil {
+			t.Fatalf("could not decode forecast: %v", err)
+		}
+
+		if rf.Sample != 1 || len(rf.Cities) != 1 || rf.Cities[0].CityID != 1 {
+			t.Errorf("expected the forecast of the single member city, got %+v", rf)
+		}
+	}, t)
+}
+
+func Test_CannotHandleGetNonExistentRegionRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/regions/{id}", sm.GetRegionHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("SELECT (.+) FROM regions r").WillReturnError(sql.ErrNoRows)
+
+		resp, err := http.Get(fmt.Sprintf("%s/regions/1", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make get request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected status not found, got %v", resp.StatusCode)
+		}
+	}, t)
+}
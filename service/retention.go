@@ -0,0 +1,279 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
+)
+
+// RetentionPolicy describes how long the temperatures of a city are kept at each resolution, as
+// durations such as "168h0m0s": Raw before they are rolled into hourly aggregates, Hourly before
+// those are rolled into daily aggregates and Daily before those are deleted, "0s" keeping them forever
+type RetentionPolicy struct {
+	CityID int64  `json:"city_id"`
+	Raw    string `json:"raw"`
+	Hourly string `json:"hourly"`
+	Daily  string `json:"daily"`
+}
+
+// TemperatureHistory describes a page of the history of the temperatures of a city at a
+// resolution, in Unit, and the cursor to retrieve the next page
+type TemperatureHistory struct {
+	CityID     int64                   `json:"city_id"`
+	Resolution string                  `json:"resolution"`
+	TimeZone   string                  `json:"time_zone"`
+	Unit       string                  `json:"unit"`
+	Aggregates []*TemperatureAggregate `json:"aggregates"`
+	NextCursor string                  `json:"next_cursor,omitempty"`
+}
+
+// TemperatureAggregate describes the temperatures of a city observed in the local hour or day
+// starting at Start, given in the time zone of the city, Timestamp being its Unix time
+type TemperatureAggregate struct {
+	Timestamp  int64   `json:"timestamp"`
+	Start      string  `json:"start"`
+	Min        float64 `json:"min"`
+	Max        float64 `json:"max"`
+	AverageMin float64 `json:"average_min"`
+	AverageMax float64 `json:"average_max"`
+	Sample     int64   `json:"sample"`
+}
+
+// GetRetentionPolicyHandler handles a GET request for the retention policy of a city
+func (m *Manager) GetRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, err := m.TM.RetentionPolicy(int64(id), m.Retention)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeRetentionPolicy(w, p)
+}
+
+// SetRetentionPolicyHandler handles a PUT request to set the retention policy of a city. The
+// retentions not given are those of the manager.
+func (m *Manager) SetRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ve model.ValidationError
+	p := &model.RetentionPolicy{
+		CityID: int64(id),
+		Raw:    formDuration(&ve, r, "raw", m.Retention.Raw),
+		Hourly: formDuration(&ve, r, "hourly", m.Retention.Hourly),
+		Daily:  formDuration(&ve, r, "daily", m.Retention.Daily),
+	}
+
+	if err := validate(&ve, p); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	p, err = m.TM.SetRetentionPolicy(p)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeRetentionPolicy(w, p)
+}
+
+// ResetRetentionPolicyHandler handles a DELETE request for the retention policy of a city, so
+// that the retention policy of the manager applies to it again, answering the policy now in effect
+func (m *Manager) ResetRetentionPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := m.TM.ResetRetentionPolicy(int64(id)); err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p, err := m.TM.RetentionPolicy(int64(id), m.Retention)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeRetentionPolicy(w, p)
+}
+
+// GetTemperatureHistoryHandler handles a GET request for a page of the hourly or daily aggregates
+// of the temperatures of a city whose periods start within the RFC 3339 times from and to,
+// optionally only of those reported by the station given by station_id. Unless a resolution is
+// given, the finest one the retention policy of the city keeps for the whole range is answered.
+func (m *Manager) GetTemperatureHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var limit int
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > model.MaxTemperatureLimit {
+			http.Error(w, fmt.Sprintf("limit must be an integer between 1 and %d", model.MaxTemperatureLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	var ve model.ValidationError
+	hq := &model.TemperatureHistoryQuery{
+		CityID:     int64(id),
+		Resolution: formResolution(&ve, r, "resolution"),
+		From:       formTime(&ve, r, "from"),
+		To:         formTime(&ve, r, "to"),
+		Limit:      limit,
+		Cursor:     r.URL.Query().Get("cursor"),
+	}
+	if r.URL.Query().Get("station_id") != "" {
+		if hq.StationID = formInt(&ve, r, "station_id"); hq.StationID < 0 {
+			ve.Add("station_id", "must be a positive ID")
+		}
+	}
+	if hq.Resolution == model.Raw {
+		ve.Add("resolution", "must be one of hourly or daily, got %q", hq.Resolution)
+	}
+	if !hq.From.IsZero() && !hq.To.IsZero() && !hq.From.Before(hq.To) {
+		ve.Add("to", "must be after from")
+	}
+
+	unit, _, err := requestedUnit(r)
+	if err != nil {
+		ve.Add("units", "%v", err)
+	}
+
+	if err := ve.Err(); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	history, err := m.TM.History(hq, m.Retention, time.Now())
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err == model.ErrInvalidCursor {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	th := &TemperatureHistory{
+		CityID:     history.CityID,
+		Resolution: string(history.Resolution),
+		TimeZone:   history.TimeZone,
+		Unit:       string(unit),
+		Aggregates: make([]*TemperatureAggregate, 0, len(history.Aggregates)),
+		NextCursor: history.NextCursor,
+	}
+	loc, err := time.LoadLocation(history.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	for _, agg := range history.Aggregates {
+		th.Aggregates = append(th.Aggregates, newTemperatureAggregate(agg, unit, loc))
+	}
+
+	resp, err := json.Marshal(th)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if history.NextCursor != "" {
+		w.Header().Set("Link", nextLink(r.URL, history.NextCursor))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+func newTemperatureAggregate(agg *model.TemperatureAggregate, unit model.Unit, loc *time.Location) *TemperatureAggregate {
+	convert := func(c float64) float64 {
+		return model.Round(unit.FromCelsius(c), model.TemperatureDecimals)
+	}
+
+	return &TemperatureAggregate{
+		Timestamp:  agg.Timestamp,
+		Start:      time.Unix(agg.Timestamp, 0).In(loc).Format(time.RFC3339),
+		Min:        convert(agg.Min),
+		Max:        convert(agg.Max),
+		AverageMin: convert(agg.AverageMin),
+		AverageMax: convert(agg.AverageMax),
+		Sample:     agg.Sample,
+	}
+}
+
+func writeRetentionPolicy(w http.ResponseWriter, p *model.RetentionPolicy) {
+	resp, err := json.Marshal(&RetentionPolicy{
+		CityID: p.CityID,
+		Raw:    p.Raw.String(),
+		Hourly: p.Hourly.String(),
+		Daily:  p.Daily.String(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+func Test_CanHandleSetRetentionPolicyRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/retention_policy", sm.SetRetentionPolicyHandler).Methods("PUT")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		// the hourly and daily retentions not given are those of the manager
+		mock.ExpectQuery("INSERT INTO retention_policies").
+			WithArgs(1, int64(48*3600), int64(90*24*3600), int64(0)).
+			WillReturnRows(sqlmock.NewRows([]string{"city_id", "raw_retention", "hourly_retention", "daily_retention"}).AddRow(1, 48*3600, 90*24*3600, 0))
+
+		f := url.Values{}
+		f.Add("raw", "48h")
+
+		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/cities/1/retention_policy", ts.URL), strings.NewReader(f.Encode()))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status OK got %v", resp.StatusCode)
+		}
+
+		var p RetentionPolicy
+		if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+			t.Fatalf("could not decode policy: %v", err)
+		}
+
+		if p.Raw != "48h0m0s" || p.Hourly != "2160h0m0s" || p.Daily != "0s" {
+			t.Errorf("expected the policy as set, got %+v", p)
+		}
+	}, t)
+}
+
+func Test_CannotHandleSetRetentionPolicyRequestWithInvalidDuration(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/retention_policy", sm.SetRetentionPolicyHandler).Methods("PUT")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		f := url.Values{}
+		f.Add("raw", "a week")
+		f.Add("hourly", "10m")
+
+		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/cities/1/retention_policy", ts.URL), strings.NewReader(f.Encode()))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request got %v", resp.StatusCode)
+		}
+
+		var ve ValidationErrors
+		if err := json.NewDecoder(resp.Body).Decode(&ve); err != nil {
+			t.Fatalf("could not decode errors: %v", err)
+		}
+
+		if len(ve.Errors) != 2 || ve.Errors[0].Field != "raw" || ve.Errors[1].Field != "hourly" {
+			t.Errorf("expected errors of raw and hourly, got %+v", ve.Errors)
+		}
+	}, t)
+}
+
+func Test_CanHandleGetTemperatureHistoryRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/temperatures/history", sm.GetTemperatureHistoryHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("SELECT time_zone FROM cities").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"time_zone"}).AddRow("UTC"),
+		)
+		mock.ExpectQuery("SELECT period, (.+) FROM temperatures_daily (.+) GROUP BY period").
+			WithArgs("UTC", 1, day.Unix(), day.Unix(), 101).
+			WillReturnRows(
+				sqlmock.NewRows([]string{"period", "min", "max", "avg_min", "avg_max", "count"}).
+					AddRow(day.Unix(), 0, 10, 2.5, 7.5, 24),
+			)
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities/1/temperatures/history?resolution=daily&from=2020-01-01T00:00:00Z&units=fahrenheit", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status OK got %v", resp.StatusCode)
+		}
+
+		var th TemperatureHistory
+		if err := json.NewDecoder(resp.Body).Decode(&th); err != nil {
+			t.Fatalf("could not decode history: %v", err)
+		}
+
+		if th.Resolution != "daily" || len(th.Aggregates) != 1 {
+			t.Fatalf("expected a single day, got %+v", th)
+		}
+
+		if agg := th.Aggregates[0]; agg.Min != 32 || agg.Max != 50 || agg.Sample != 24 || agg.Start != "2020-01-01T00:00:00Z" {
+			t.Errorf("expected the day in fahrenheit, got %+v", agg)
+		}
+	}, t)
+}
+
+func Test_CannotHandleGetTemperatureHistoryRequestWithInvalidResolution(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/temperatures/history", sm.GetTemperatureHistoryHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities/1/temperatures/history?resolution=weekly", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request got %v", resp.StatusCode)
+		}
+	}, t)
+}
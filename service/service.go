@@ -3,6 +3,7 @@ package service
 import (
 	"database/sql"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/shaybix/weather-monster/model"
@@ -22,7 +23,23 @@ type Manager struct {
 	CM *model.CityManager
 	FM *model.ForecastManager
 	TM *model.TemperatureManager
+	OM *model.ObservationManager
+	SM *model.StationManager
 	WM *model.WebhookManager
+	RM *model.RegionManager
+	IM *model.IdempotencyManager
+
+	// IdempotencyWindow is how long responses are replayed to retries of their idempotency key
+	IdempotencyWindow time.Duration
+	// ForecastDecimals is the number of decimals forecast averages are rounded to unless requested otherwise
+	ForecastDecimals int
+	// RequireStation rejects temperatures that are not reported by an authenticated station
+	RequireStation bool
+	// Retention is the retention policy of the cities without one of their own
+	Retention *model.RetentionPolicy
+	// AdminToken is the bearer token creating stations and rotating the token of any station
+	// requires. Stations cannot be created while it is empty.
+	AdminToken string
 }
 
 // NewServiceManager ...
@@ -31,6 +48,18 @@ func NewServiceManager(db *sql.DB) *Manager {
 		CM: model.NewCityManager(db),
 		FM: model.NewForecastManager(db),
 		TM: model.NewTemperatureManager(db),
+		OM: model.NewObservationManager(db),
+		SM: model.NewStationManager(db),
 		WM: model.NewWebhookManager(db),
+		RM: model.NewRegionManager(db),
+		IM: model.NewIdempotencyManager(db),
+
+		IdempotencyWindow: model.DefaultIdempotencyWindow,
+		ForecastDecimals:  model.DefaultForecastDecimals,
+		Retention: &model.RetentionPolicy{
+			Raw:    model.DefaultRawRetention,
+			Hourly: model.DefaultHourlyRetention,
+			Daily:  model.DefaultDailyRetention,
+		},
 	}
 }
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
+)
+
+// Station describes a weather station of a city. Elevation is given in metres above sea level.
+// The temperatures of a station count Weight times in weighted forecasts.
+type Station struct {
+	ID        int64    `json:"id"`
+	CityID    int64    `json:"city_id"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Elevation *float64 `json:"elevation,omitempty"`
+	Owner     string   `json:"owner,omitempty"`
+	Weight    float64  `json:"weight"`
+	CreatedAt string   `json:"created_at"`
+	DeletedAt string   `json:"deleted_at,omitempty"`
+}
+
+// StationCredentials describes a station along with the token it authenticates with, which is
+// only answered when the station is created or its token is rotated
+type StationCredentials struct {
+	*Station
+	Token string `json:"token"`
+}
+
+// StationList describes the stations of a city
+type StationList struct {
+	Stations []*Station `json:"stations"`
+}
+
+func newStation(st *model.Station) *Station {
+	s := &Station{
+		ID:        st.ID,
+		CityID:    st.CityID,
+		Latitude:  st.Latitude,
+		Longitude: st.Longitude,
+		Elevation: st.Elevation,
+		Owner:     st.Owner,
+		Weight:    st.Weight,
+		CreatedAt: st.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if st.DeletedAt != nil {
+		s.DeletedAt = st.DeletedAt.UTC().Format(time.RFC3339)
+	}
+
+	return s
+}
+
+// CreateStationHandler handles a POST request to create a station of a city, answering the
+// token the station authenticates its temperatures with. It requires the admin token.
+func (m *Manager) CreateStationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !m.isAdmin(r) {
+		writeUnauthorized(w, "admin")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ve model.ValidationError
+	ns := &model.NewStation{
+		CityID:    formInt(&ve, r, "city_id"),
+		Latitude:  formFloat(&ve, r, "latitude"),
+		Longitude: formFloat(&ve, r, "longitude"),
+		Owner:     strings.TrimSpace(r.FormValue("owner")),
+		Weight:    model.DefaultStationWeight,
+	}
+	if r.FormValue("elevation") != "" {
+		elevation := formFloat(&ve, r, "elevation")
+		ns.Elevation = &elevation
+	}
+	if r.FormValue("weight") != "" {
+		ns.Weight = formFloat(&ve, r, "weight")
+	}
+
+	if err := validate(&ve, ns); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	st, token, err := m.SM.Create(ns)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeStationCredentials(w, st, token, http.StatusCreated)
+}
+
+// GetStationHandler handles a GET request for a station, including a deleted one
+func (m *Manager) GetStationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	st, err := m.SM.Get(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeStation(w, st)
+}
+
+// ListCityStationsHandler handles a GET request for the stations of a city that are not deleted
+func (m *Manager) ListCityStationsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sts, err := m.SM.List(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := &StationList{Stations: make([]*Station, 0, len(sts))}
+	for _, st := range sts {
+		list.Stations = append(list.Stations, newStation(st))
+	}
+
+	resp, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// DeleteStationHandler handles a DELETE request for a station, revoking its token. The
+// temperatures it reported stay attributed to it.
+func (m *Manager) DeleteStationHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	st, err := m.SM.Delete(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeStation(w, st)
+}
+
+// RotateStationTokenHandler handles a POST request to replace the token of a station, revoking the
+// previous one, answering the new token. It requires either the current token of the station or
+// the admin token.
+func (m *Manager) RotateStationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !m.isAdmin(r) {
+		if _, ok := m.authenticateStation(w, r, int64(id)); !ok {
+			return
+		}
+	}
+
+	token, err := m.SM.RotateToken(int64(id))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	st, err := m.SM.Get(int64(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeStationCredentials(w, st, token, http.StatusOK)
+}
+
+// authenticateStation authenticates the station a request reports temperatures of by the bearer
+// token of its Authorization header, answering the request with 401 Unauthorized and returning
+// false if the token is missing or wrong
+func (m *Manager) authenticateStation(w http.ResponseWriter, r *http.Request, id int64) (*model.Station, bool) {
+	st, err := m.SM.Authenticate(id, bearerToken(r))
+	if err != nil {
+		if err == model.ErrInvalidCredentials {
+			writeUnauthorized(w, "stations")
+			return nil, false
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return st, true
+}
+
+// isAdmin reports whether a request gives the admin token of the manager as its bearer token
+func (m *Manager) isAdmin(r *http.Request) bool {
+	return m.AdminToken != "" && subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(m.AdminToken)) == 1
+}
+
+// bearerToken returns the bearer token of the Authorization header of a request, if any
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+
+	return ""
+}
+
+// writeUnauthorized answers a request with 401 Unauthorized, challenging it for a bearer token of realm
+func writeUnauthorized(w http.ResponseWriter, realm string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+	http.Error(w, model.ErrInvalidCredentials.Error(), http.StatusUnauthorized)
+}
+
+// writeStationCredentials answers a station along with its token, forbidding the response to be
+// stored anywhere, including for replaying it to retries of its idempotency key
+func writeStationCredentials(w http.ResponseWriter, st *model.Station, token string, status int) {
+	resp, err := json.Marshal(&StationCredentials{Station: newStation(st), Token: token})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+func writeStation(w http.ResponseWriter, st *model.Station) {
+	resp, err := json.Marshal(newStation(st))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
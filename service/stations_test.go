@@ -0,0 +1,310 @@
+package service
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+var stationRows = []string{"ID", "city_id", "latitude", "longitude", "elevation", "owner", "weight", "created_at", "deleted_at"}
+
+func Test_CanHandleCreateStationRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+		sm.AdminToken = "admin-secret"
+
+		r := mux.NewRouter()
+		r.HandleFunc("/stations", sm.CreateStationHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("INSERT INTO stations").
+			WithArgs(1, 52.52, 13.405, nil, "acme", 2.0, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(stationRows).AddRow(1, 1, 52.52, 13.405, nil, "acme", 2.0, time.Now(), nil))
+
+		f := url.Values{}
+		f.Add("city_id", "1")
+		f.Add("latitude", "52.52")
+		f.Add("longitude", "13.405")
+		f.Add("owner", "acme")
+		f.Add("weight", "2")
+
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s/stations", ts.URL), strings.NewReader(f.Encode()))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+sm.AdminToken)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status created got %v", resp.StatusCode)
+		}
+
+		var sc StationCredentials
+		if err := json.NewDecoder(resp.Body).Decode(&sc); err != nil {
+			t.Fatalf("could not decode station: %v", err)
+		}
+
+		if sc.Token == "" || sc.Station == nil || sc.ID != 1 || sc.Weight != 2 {
+			t.Errorf("expected the station along with its token, got %+v", sc)
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateStationRequestWithoutAdminToken(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+		sm.AdminToken = "admin-secret"
+
+		r := mux.NewRouter()
+		r.HandleFunc("/stations", sm.CreateStationHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		for _, token := range []string{"", "guess"} {
+			req, err := http.NewRequest("POST", fmt.Sprintf("%s/stations", ts.URL), strings.NewReader("city_id=1&latitude=52.52&longitude=13.405"))
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("could not make request: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("expected status unauthorized with token %q got %v", token, resp.StatusCode)
+			}
+		}
+	}, t)
+}
+
+func Test_CanHandleRotateStationTokenRequestWithCurrentToken(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/stations/{id}/token", sm.RotateStationTokenHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		token := "secret"
+		hash := fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+		mock.ExpectQuery("SELECT (.+), token_hash FROM stations").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(append(stationRows, "token_hash")).AddRow(1, 1, 52.52, 13.405, nil, "", 1.0, time.Now(), nil, hash),
+		)
+		mock.ExpectExec("UPDATE stations SET token_hash").WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT (.+) FROM stations").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(stationRows).AddRow(1, 1, 52.52, 13.405, nil, "", 1.0, time.Now(), nil),
+		)
+
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s/stations/1/token", ts.URL), nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok got %v", resp.StatusCode)
+		}
+
+		var sc StationCredentials
+		if err := json.NewDecoder(resp.Body).Decode(&sc); err != nil {
+			t.Fatalf("could not decode station: %v", err)
+		}
+
+		if sc.Token == "" || sc.Token == token {
+			t.Errorf("expected a new token, got %q", sc.Token)
+		}
+	}, t)
+}
+
+func Test_CannotHandleRotateStationTokenRequestWithoutToken(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/stations/{id}/token", sm.RotateStationTokenHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		resp, err := http.Post(fmt.Sprintf("%s/stations/1/token", ts.URL), "", nil)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status unauthorized got %v", resp.StatusCode)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("expected the token not to be rotated: %v", err)
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateTemperatureRequestWithoutStationToken(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures", sm.CreateTemperatureHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		f := url.Values{}
+		f.Add("city_id", "1")
+		f.Add("min", "10")
+		f.Add("max", "20")
+		f.Add("station_id", "1")
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/temperatures", ts.URL), f)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status unauthorized got %v", resp.StatusCode)
+		}
+
+		if resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("expected a WWW-Authenticate header")
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateTemperatureRequestOfStationOfAnotherCity(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures", sm.CreateTemperatureHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		token := "secret"
+		hash := fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+		mock.ExpectQuery("SELECT (.+), token_hash FROM stations").WithArgs(1).WillReturnRows(
+			sqlmock.NewRows(append(stationRows, "token_hash")).AddRow(1, 2, 52.52, 13.405, nil, "", 1.0, time.Now(), nil, hash),
+		)
+
+		f := url.Values{}
+		f.Add("city_id", "1")
+		f.Add("min", "10")
+		f.Add("max", "20")
+		f.Add("station_id", "1")
+
+		req, err := http.NewRequest("POST", fmt.Sprintf("%s/temperatures", ts.URL), strings.NewReader(f.Encode()))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request got %v", resp.StatusCode)
+		}
+
+		var ve ValidationErrors
+		if err := json.NewDecoder(resp.Body).Decode(&ve); err != nil {
+			t.Fatalf("could not decode errors: %v", err)
+		}
+
+		if len(ve.Errors) != 1 || ve.Errors[0].Field != "station_id" {
+			t.Errorf("expected an error of station_id, got %+v", ve.Errors)
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateTemperatureRequestWithoutRequiredStation(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+		sm.RequireStation = true
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures", sm.CreateTemperatureHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		f := url.Values{}
+		f.Add("city_id", "1")
+		f.Add("min", "10")
+		f.Add("max", "20")
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/temperatures", ts.URL), f)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleListCityStationsRequestOfMissingCity(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/stations", sm.ListCityStationsHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WithArgs(404).WillReturnRows(sqlmock.NewRows(stationRows))
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities/404/stations", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected status not found got %v", resp.StatusCode)
+		}
+	}, t)
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shaybix/weather-monster/model"
+)
+
+const (
+	// ndjsonContentType is the content type of a batch given as newline delimited JSON objects
+	ndjsonContentType = "application/x-ndjson"
+	// maxTemperatureBatchBytes is the maximum size of the body of a batch of temperatures
+	maxTemperatureBatchBytes = 4 << 20
+)
+
+// TemperatureBatchItem describes the outcome of a single temperature of a batch, by its index in
+// the batch. Status is the HTTP status the temperature would have been answered with on its own.
+type TemperatureBatchItem struct {
+	Index       int           `json:"index"`
+	Status      int           `json:"status"`
+	Temperature *Temperature  `json:"temperature,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Errors      []*FieldError `json:"errors,omitempty"`
+}
+
+// TemperatureBatch describes the outcome of a batch of temperatures, item by item
+type TemperatureBatch struct {
+	Created int                     `json:"created"`
+	Failed  int                     `json:"failed"`
+	Results []*TemperatureBatchItem `json:"results"`
+}
+
+// TemperatureBatchEvent describes the temperatures of a single city created in a batch, as they
+// are posted to its webhooks
+type TemperatureBatchEvent struct {
+	CityID       int64          `json:"city_id"`
+	Temperatures []*Temperature `json:"temperatures"`
+}
+
+// CreateTemperatureBatchHandler handles a POST request to create many temperatures at once, given
+// as a JSON array or as newline delimited JSON objects. The batch is stored all or nothing unless
+// partial=true is given, in which case every valid temperature is stored regardless of the others.
+// Temperatures without a unit of their own are taken to be in the unit query parameter, if given.
+// A batch reported by a station gives its station_id as a query parameter and authenticates with
+// the token of the station, all of its temperatures then being of the city of the station.
+// Webhooks are notified once per city of all of its temperatures.
+func (m *Manager) CreateTemperatureBatchHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	partial := false
+	if v := r.URL.Query().Get("partial"); v != "" {
+		p, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("partial must be true or false, got %q", v), http.StatusBadRequest)
+			return
+		}
+		partial = p
+	}
+
+	defaultUnit, err := model.ParseUnit(r.URL.Query().Get("unit"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unit, _, err := requestedUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var station *model.Station
+	if v := r.URL.Query().Get("station_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, fmt.Sprintf("station_id must be a positive integer, got %q", v), http.StatusBadRequest)
+			return
+		}
+
+		var ok bool
+		if station, ok = m.authenticateStation(w, r, id); !ok {
+			return
+		}
+	} else if m.RequireStation {
+		http.Error(w, "station_id is required", http.StatusBadRequest)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxTemperatureBatchBytes)
+	items, err := decodeTemperatureBatch(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(items) == 0 || len(items) > model.MaxTemperatureBatch {
+		http.Error(w, fmt.Sprintf("batch must hold between 1 and %d temperatures", model.MaxTemperatureBatch), http.StatusBadRequest)
+		return
+	}
+
+	results := make([]*model.TemperatureResult, len(items))
+	var valid []*model.NewTemperature
+	var validIdx []int
+	for i, raw := range items {
+		nt, err := decodeBatchTemperature(raw, defaultUnit, station)
+		if err != nil {
+			results[i] = &model.TemperatureResult{Err: err}
+			continue
+		}
+
+		valid = append(valid, nt)
+		validIdx = append(validIdx, i)
+	}
+
+	switch {
+	case len(valid) < len(items) && !partial:
+		for i, res := range results {
+			if res == nil {
+				results[i] = &model.TemperatureResult{Err: model.ErrBatchAborted}
+			}
+		}
+	case len(valid) > 0:
+		created, err := m.TM.CreateBatch(valid, partial)
+		if err != nil && err != model.ErrBatchFailed {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for j, res := range created {
+			results[validIdx[j]] = res
+		}
+	}
+
+	batch := &TemperatureBatch{Results: make([]*TemperatureBatchItem, 0, len(results))}
+	var cities []int64
+	byCity := make(map[int64][]*model.Temperature)
+	for i, res := range results {
+		item := newTemperatureBatchItem(i, res, unit)
+		batch.Results = append(batch.Results, item)
+		if res.Temperature == nil {
+			batch.Failed++
+			continue
+		}
+
+		batch.Created++
+		cid := res.Temperature.CityID
+		if _, ok := byCity[cid]; !ok {
+			cities = append(cities, cid)
+		}
+		byCity[cid] = append(byCity[cid], res.Temperature)
+	}
+
+	if batch.Created > 0 {
+		go m.notifyTemperatureBatch(cities, byCity)
+	}
+
+	resp, err := json.Marshal(batch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusCreated
+	if batch.Failed > 0 {
+		status = http.StatusMultiStatus
+		if !partial {
+			status = http.StatusBadRequest
+		}
+	}
+
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// notifyTemperatureBatch notifies the webhooks of each city once of all of its new temperatures
+func (m *Manager) notifyTemperatureBatch(cities []int64, byCity map[int64][]*model.Temperature) {
+	for _, cid := range cities {
+		whs, err := m.WM.Get(cid)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		temps := byCity[cid]
+		notifyWebhooks(whs, temperatureBatchCreatedEvent, func(unit model.Unit) interface{} {
+			ev := &TemperatureBatchEvent{CityID: cid, Temperatures: make([]*Temperature, 0, len(temps))}
+			for _, temp := range temps {
+				ev.Temperatures = append(ev.Temperatures, newTemperature(temp, unit))
+			}
+			return ev
+		})
+	}
+}
+
+// newTemperatureBatchItem describes the result of the temperature at index i of a batch, giving
+// a created temperature in unit
+func newTemperatureBatchItem(i int, res *model.TemperatureResult, unit model.Unit) *TemperatureBatchItem {
+	item := &TemperatureBatchItem{Index: i}
+	switch err := res.Err.(type) {
+	case nil:
+		item.Status = http.StatusCreated
+		item.Temperature = newTemperature(res.Temperature, unit)
+	case *model.ValidationError:
+		item.Status = http.StatusBadRequest
+		item.Error = err.Error()
+		for _, fe := range err.Errors {
+			item.Errors = append(item.Errors, &FieldError{Field: fe.Field, Message: fe.Message})
+		}
+	case *model.OutlierError:
+		item.Status = http.StatusUnprocessableEntity
+		item.Error = err.Error()
+	default:
+		item.Status = http.StatusInternalServerError
+		switch err {
+		case model.ErrNotFound:
+			item.Status = http.StatusNotFound
+		case model.ErrBatchAborted:
+			item.Status = http.StatusFailedDependency
+		}
+		item.Error = err.Error()
+	}
+
+	return item
+}
+
+// decodeTemperatureBatch splits the body of a batch into its items according to its content type.
+// A request without a content type is taken to hold a JSON array.
+func decodeTemperatureBatch(r *http.Request) ([]json.RawMessage, error) {
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return nil, err
+		}
+		mediaType = mt
+	}
+
+	switch mediaType {
+	case "application/json":
+		var items []json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			return nil, fmt.Errorf("batch must be a JSON array of temperatures: %v", err)
+		}
+		return items, nil
+	case ndjsonContentType:
+		return splitNDJSON(r.Body)
+	default:
+		return nil, fmt.Errorf("unsupported batch content type, expected application/json or %s", ndjsonContentType)
+	}
+}
+
+// splitNDJSON splits newline delimited JSON into its lines, skipping blank ones
+func splitNDJSON(body io.Reader) ([]json.RawMessage, error) {
+	var items []json.RawMessage
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxTemperatureBatchBytes)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		items = append(items, json.RawMessage(append([]byte(nil), line...)))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// decodeBatchTemperature decodes a single temperature of a batch, taken to be in defaultUnit
+// unless it has a unit member and to be reported by station if given, returning a
+// *model.ValidationError listing all of its invalid fields
+func decodeBatchTemperature(raw json.RawMessage, defaultUnit model.Unit, station *model.Station) (*model.NewTemperature, error) {
+	var ve model.ValidationError
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil || members == nil {
+		ve.Add("temperature", "must be a JSON object")
+		return nil, &ve
+	}
+
+	nt := &model.NewTemperature{
+		CityID: jsonInt(&ve, members, "city_id"),
+		Min:    jsonFloat(&ve, members, "min"),
+		Max:    jsonFloat(&ve, members, "max"),
+	}
+	nt.ObservedAt = jsonTime(&ve, members, "observed_at")
+	nt.Unit = defaultUnit
+	if raw, ok := members["unit"]; ok {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			ve.Add("unit", "must be a string")
+		} else if nt.Unit, err = model.ParseUnit(s); err != nil {
+			ve.Add("unit", "must be one of celsius, fahrenheit or kelvin, got %q", s)
+		}
+	}
+	if station != nil {
+		nt.StationID = station.ID
+		if nt.CityID != station.CityID && !ve.Has("city_id") {
+			ve.Add("city_id", "must be the city %d of station %d", station.CityID, station.ID)
+		}
+	}
+
+	if err := validate(&ve, nt); err != nil {
+		return nil, err
+	}
+
+	return nt, nil
+}
+
+// jsonInt reads a required integer member of a JSON object, recording the field as invalid
+// if it is missing or not an integer
+func jsonInt(ve *model.ValidationError, members map[string]json.RawMessage, field string) int64 {
+	raw, ok := members[field]
+	if !ok || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		ve.Add(field, "is required")
+		return 0
+	}
+
+	var v int64
+	if err := json.Unmarshal(raw, &v); err != nil {
+		ve.Add(field, "must be an integer, got %s", raw)
+		return 0
+	}
+
+	return v
+}
+
+// jsonFloat reads a required number member of a JSON object, recording the field as invalid
+// if it is missing or not a number
+func jsonFloat(ve *model.ValidationError, members map[string]json.RawMessage, field string) float64 {
+	raw, ok := members[field]
+	if !ok || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		ve.Add(field, "is required")
+		return 0
+	}
+
+	var v float64
+	if err := json.Unmarshal(raw, &v); err != nil {
+		ve.Add(field, "must be a number, got %s", raw)
+		return 0
+	}
+
+	return v
+}
+
+// jsonTime reads an optional RFC 3339 time member of a JSON object, recording the field as
+// invalid if it is not a time. The zero time is returned if the member is not given.
+func jsonTime(ve *model.ValidationError, members map[string]json.RawMessage, field string) time.Time {
+	raw, ok := members[field]
+	if !ok || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return time.Time{}
+	}
+
+	var v time.Time
+	if err := json.Unmarshal(raw, &v); err != nil {
+		ve.Add(field, "must be an RFC 3339 time, got %s", raw)
+		return time.Time{}
+	}
+
+	return v
+}
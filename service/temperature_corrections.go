@@ -0,0 +1,206 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/shaybix/weather-monster/model"
+)
+
+// TemperatureCorrection describes a correction of a temperature, giving the temperature before
+// and after it was corrected. Current is left out if the temperature was deleted.
+type TemperatureCorrection struct {
+	ID            int64        `json:"id"`
+	TemperatureID int64        `json:"temperature_id"`
+	CityID        int64        `json:"city_id"`
+	Action        string       `json:"action"`
+	Reason        string       `json:"reason,omitempty"`
+	CorrectedAt   string       `json:"corrected_at"`
+	Previous      *Temperature `json:"previous"`
+	Current       *Temperature `json:"current,omitempty"`
+}
+
+// TemperatureCorrectionList describes the corrections of a temperature, most recent first
+type TemperatureCorrectionList struct {
+	Corrections []*TemperatureCorrection `json:"corrections"`
+}
+
+func newTemperatureCorrection(tc *model.TemperatureCorrection, unit model.Unit) *TemperatureCorrection {
+	c := &TemperatureCorrection{
+		ID:            tc.ID,
+		TemperatureID: tc.TemperatureID,
+		CityID:        tc.CityID,
+		Action:        string(tc.Action),
+		Reason:        tc.Reason,
+		CorrectedAt:   tc.CorrectedAt.UTC().Format(time.RFC3339),
+		Previous:      newTemperature(tc.Previous, unit),
+	}
+	if tc.Current != nil {
+		c.Current = newTemperature(tc.Current, unit)
+	}
+
+	return c
+}
+
+// UpdateTemperatureHandler handles a PUT request correcting the min and max of a temperature and
+// optionally the time it was observed at, for the reason given. The corrected temperature is
+// answered in the unit it is given in unless another one is requested.
+func (m *Manager) UpdateTemperatureHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ve model.ValidationError
+	tu := &model.TemperatureUpdate{
+		ID:     int64(id),
+		Min:    formFloat(&ve, r, "min"),
+		Max:    formFloat(&ve, r, "max"),
+		Reason: strings.TrimSpace(r.FormValue("reason")),
+	}
+	tu.ObservedAt = formTime(&ve, r, "observed_at")
+	tu.Unit = formUnit(&ve, r, "unit")
+
+	unit, ok, err := requestedUnit(r)
+	if err != nil {
+		ve.Add("units", "%v", err)
+	}
+	if !ok {
+		unit = tu.Unit
+	}
+
+	if err := validate(&ve, tu); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+
+	tc, err := m.TM.Correct(tu)
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m.notifyTemperatureCorrection(tc)
+
+	resp, err := json.Marshal(newTemperature(tc.Current, unit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// DeleteTemperatureHandler handles a DELETE request for a temperature, for the reason optionally
+// given, answering the deleted temperature
+func (m *Manager) DeleteTemperatureHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unit, _, err := requestedUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tc, err := m.TM.Delete(int64(id), strings.TrimSpace(r.URL.Query().Get("reason")))
+	if err != nil {
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if _, ok := err.(*model.ValidationError); ok {
+			writeValidationError(w, err)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m.notifyTemperatureCorrection(tc)
+
+	resp, err := json.Marshal(newTemperature(tc.Previous, unit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// ListTemperatureCorrectionsHandler handles a GET request for the corrections of a temperature,
+// which are kept after the temperature is deleted
+func (m *Manager) ListTemperatureCorrectionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	unit, _, err := requestedUnit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tcs, err := m.TM.Corrections(int64(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	list := &TemperatureCorrectionList{Corrections: make([]*TemperatureCorrection, 0, len(tcs))}
+	for _, tc := range tcs {
+		list.Corrections = append(list.Corrections, newTemperatureCorrection(tc, unit))
+	}
+
+	resp, err := json.Marshal(list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// notifyTemperatureCorrection notifies the webhooks of the city of a corrected temperature
+func (m *Manager) notifyTemperatureCorrection(tc *model.TemperatureCorrection) {
+	whs, err := m.WM.Get(tc.CityID)
+	if err != nil {
+		log.Println(err)
+	}
+
+	go notifyWebhooks(whs, temperatureCorrectedEvent, func(unit model.Unit) interface{} {
+		return newTemperatureCorrection(tc, unit)
+	})
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+var temperatureRows = []string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}
+
+func Test_CanHandleUpdateTemperatureRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures/{id}", sm.UpdateTemperatureHandler).Methods("PUT")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		observed := time.Now().Add(-time.Hour).Unix()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM temperatures (.+) FOR UPDATE").WithArgs(7).WillReturnRows(
+			sqlmock.NewRows(temperatureRows).AddRow(7, 900, 905, observed, 1, observed, true, 597.01, nil),
+		)
+		mock.ExpectQuery("UPDATE temperatures").WithArgs(7, 10.0, 15.0, observed).WillReturnRows(
+			sqlmock.NewRows(temperatureRows).AddRow(7, 10, 15, observed, 1, observed, false, nil, nil),
+		)
+		mock.ExpectQuery("INSERT INTO temperature_corrections").WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "corrected_at"}).AddRow(1, time.Now()),
+		)
+		mock.ExpectCommit()
+
+		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/temperatures/7", ts.URL), strings.NewReader("min=50&max=59&unit=fahrenheit&reason=typo"))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok got %v", resp.StatusCode)
+		}
+
+		var temp Temperature
+		if err := json.NewDecoder(resp.Body).Decode(&temp); err != nil {
+			t.Fatalf("could not decode temperature: %v", err)
+		}
+
+		if temp.Min != 50 || temp.Max != 59 || temp.Unit != "fahrenheit" || temp.Flagged {
+			t.Errorf("expected the corrected temperature in fahrenheit, got %+v", temp)
+		}
+	}, t)
+}
+
+func Test_CannotHandleUpdateTemperatureRequestWithInvalidValues(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures/{id}", sm.UpdateTemperatureHandler).Methods("PUT")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/temperatures/7", ts.URL), strings.NewReader("min=20&max=ten"))
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status bad request got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CanHandleDeleteTemperatureRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures/{id}", sm.DeleteTemperatureHandler).Methods("DELETE")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		observed := time.Now().Unix()
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM temperatures").WithArgs(7).WillReturnRows(
+			sqlmock.NewRows(temperatureRows).AddRow(7, 10, 15, observed, 1, observed, false, nil, nil),
+		)
+		mock.ExpectQuery("INSERT INTO temperature_corrections").WithArgs(7, 1, "delete", "duplicate",
+			10.0, 15.0, observed, nil, nil, nil).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "corrected_at"}).AddRow(2, time.Now()),
+		)
+		mock.ExpectCommit()
+
+		req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/temperatures/7?reason=duplicate", ts.URL), nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected status ok got %v", resp.StatusCode)
+		}
+	}, t)
+}
+
+func Test_CannotHandleDeleteNonExistentTemperatureRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures/{id}", sm.DeleteTemperatureHandler).Methods("DELETE")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("DELETE FROM temperatures").WithArgs(404).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/temperatures/404", ts.URL), nil)
+		if err != nil {
+			t.Fatalf("could not create request: %v", err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status not found got %v", resp.StatusCode)
+		}
+	}, t)
+}
@@ -3,22 +3,75 @@ package service
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/shaybix/weather-monster/model"
 )
 
-// Temperature describes a temperature of a given city at a specific point in time
+const (
+	// eventHeader names the event a webhook is notified of
+	eventHeader = "X-Weather-Monster-Event"
+	// temperatureCreatedEvent is the event of a single new temperature, posted as a Temperature
+	temperatureCreatedEvent = "temperature.created"
+	// temperatureBatchCreatedEvent is the event of the new temperatures of a city created in a
+	// batch, posted as a TemperatureBatchEvent
+	temperatureBatchCreatedEvent = "temperature.batch_created"
+	// temperatureCorrectedEvent is the event of a temperature corrected or deleted, posted as a
+	// TemperatureCorrection
+	temperatureCorrectedEvent = "temperature.corrected"
+)
+
+// Temperature describes a temperature of a given city at a specific point in time, in Unit.
+// Timestamp is the Unix time of ObservedAt. Flagged temperatures are outliers excluded from forecasts.
+// StationID is the station that reported the temperature, if any.
 type Temperature struct {
-	ID     int64 `json:"id"`
-	CityID int64 `json:"city_id"`
-	Min    int64 `json:"min"`
-	Max    int64 `json:"max"`
+	ID           int64    `json:"id"`
+	CityID       int64    `json:"city_id"`
+	Min          float64  `json:"min"`
+	Max          float64  `json:"max"`
+	Unit         string   `json:"unit"`
+	Timestamp    int64    `json:"timestamp"`
+	ObservedAt   string   `json:"observed_at"`
+	ReceivedAt   string   `json:"received_at"`
+	Flagged      bool     `json:"flagged"`
+	OutlierScore *float64 `json:"outlier_score,omitempty"`
+	StationID    int64    `json:"station_id,omitempty"`
+}
+
+// TemperatureList describes a page of the temperatures of a city, or of their aggregates unless
+// Resolution is raw, and the cursor to retrieve the next page
+type TemperatureList struct {
+	Resolution   string                  `json:"resolution"`
+	TimeZone     string                  `json:"time_zone,omitempty"`
+	Temperatures []*Temperature          `json:"temperatures"`
+	Aggregates   []*TemperatureAggregate `json:"aggregates,omitempty"`
+	NextCursor   string                  `json:"next_cursor,omitempty"`
+}
+
+func newTemperature(t *model.Temperature, unit model.Unit) *Temperature {
+	return &Temperature{
+		ID:           t.ID,
+		CityID:       t.CityID,
+		Min:          model.Round(unit.FromCelsius(t.Min), model.TemperatureDecimals),
+		Max:          model.Round(unit.FromCelsius(t.Max), model.TemperatureDecimals),
+		Unit:         string(unit),
+		Timestamp:    t.Timestamp,
+		ObservedAt:   time.Unix(t.Timestamp, 0).UTC().Format(time.RFC3339),
+		ReceivedAt:   time.Unix(t.ReceivedAt, 0).UTC().Format(time.RFC3339),
+		Flagged:      t.Flagged,
+		OutlierScore: t.OutlierScore,
+		StationID:    t.StationID,
+	}
 }
 
-// CreateTemperatureHandler creates temperature for a specific city
+// CreateTemperatureHandler creates temperature for a specific city. A temperature reported by a
+// station gives its station_id and authenticates with the token of the station; unless the manager
+// requires a station, temperatures may be reported without one.
 func (m *Manager) CreateTemperatureHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -27,70 +80,205 @@ func (m *Manager) CreateTemperatureHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	min, err := strconv.Atoi(r.FormValue("min"))
+	var ve model.ValidationError
+	nt := &model.NewTemperature{
+		CityID: formInt(&ve, r, "city_id"),
+		Min:    formFloat(&ve, r, "min"),
+		Max:    formFloat(&ve, r, "max"),
+	}
+	nt.ObservedAt = formTime(&ve, r, "observed_at")
+	nt.Unit = formUnit(&ve, r, "unit")
+	if r.FormValue("station_id") != "" {
+		nt.StationID = formInt(&ve, r, "station_id")
+	} else if m.RequireStation {
+		ve.Add("station_id", "is required")
+	}
+
+	if nt.StationID > 0 {
+		st, ok := m.authenticateStation(w, r, nt.StationID)
+		if !ok {
+			return
+		}
+		if st.CityID != nt.CityID && !ve.Has("city_id") {
+			ve.Add("station_id", "must be a station of city %d", nt.CityID)
+		}
+	}
+
+	// the temperature is answered in the unit it is given in unless another one is requested
+	unit, ok, err := requestedUnit(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		ve.Add("units", "%v", err)
+	}
+	if !ok {
+		unit = nt.Unit
+	}
+
+	if err := validate(&ve, nt); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
-	max, err := strconv.Atoi(r.FormValue("max"))
+	temp, err := m.TM.Create(nt)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if _, ok := err.(*model.OutlierError); ok {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	cid, err := strconv.Atoi(r.FormValue("city_id"))
+	whs, err := m.WM.Get(temp.CityID)
+	if err != nil {
+		log.Println(err)
+	}
+
+	go m.NotifyWebhooks(whs, temp)
+
+	resp, err := json.Marshal(newTemperature(temp, unit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(resp)
+}
+
+// ListTemperaturesHandler handles a GET request for a page of the temperatures of a city observed
+// within the RFC 3339 times from and to, ordered by the time they were observed at, optionally
+// only those reported by the station given by station_id. A range of which some temperatures have
+// been rolled up is answered as the aggregates of the resolution holding them, unless resolution
+// is given.
+func (m *Manager) ListTemperaturesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	nt := &model.NewTemperature{
-		CityID: int64(cid),
-		Min:    int64(min),
-		Max:    int64(max),
+	var limit int
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > model.MaxTemperatureLimit {
+			http.Error(w, fmt.Sprintf("limit must be an integer between 1 and %d", model.MaxTemperatureLimit), http.StatusBadRequest)
+			return
+		}
+		limit = l
 	}
 
-	temp, err := m.TM.Create(nt)
+	var ve model.ValidationError
+	tq := &model.TemperatureQuery{
+		CityID:     int64(id),
+		Resolution: formResolution(&ve, r, "resolution"),
+		From:       formTime(&ve, r, "from"),
+		To:         formTime(&ve, r, "to"),
+		Limit:      limit,
+		Cursor:     r.URL.Query().Get("cursor"),
+	}
+	if r.URL.Query().Get("station_id") != "" {
+		if tq.StationID = formInt(&ve, r, "station_id"); tq.StationID < 0 {
+			ve.Add("station_id", "must be a positive ID")
+		}
+	}
+	if !tq.From.IsZero() && !tq.To.IsZero() && !tq.From.Before(tq.To) {
+		ve.Add("to", "must be after from")
+	}
+
+	unit, _, err := requestedUnit(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		ve.Add("units", "%v", err)
+	}
+
+	if err := ve.Err(); err != nil {
+		writeValidationError(w, err)
 		return
 	}
 
-	whs, err := m.WM.Get(temp.CityID)
+	page, err := m.TM.List(tq)
 	if err != nil {
-		log.Println(err)
-	}
+		if err == model.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err == model.ErrInvalidCursor {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-	t := &Temperature{
-		ID:     temp.ID,
-		CityID: temp.CityID,
-		Min:    temp.Min,
-		Max:    temp.Max,
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	go m.NotifyWebhooks(whs, t)
+	tl := &TemperatureList{
+		Resolution:   string(page.Resolution),
+		TimeZone:     page.TimeZone,
+		Temperatures: make([]*Temperature, 0, len(page.Temperatures)),
+		NextCursor:   page.NextCursor,
+	}
+	for _, temp := range page.Temperatures {
+		tl.Temperatures = append(tl.Temperatures, newTemperature(temp, unit))
+	}
+	if page.Resolution != model.Raw {
+		loc, err := time.LoadLocation(page.TimeZone)
+		if err != nil {
+			loc = time.UTC
+		}
+		tl.Aggregates = make([]*TemperatureAggregate, 0, len(page.Aggregates))
+		for _, agg := range page.Aggregates {
+			tl.Aggregates = append(tl.Aggregates, newTemperatureAggregate(agg, unit, loc))
+		}
+	}
 
-	resp, err := json.Marshal(t)
+	resp, err := json.Marshal(tl)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	if page.NextCursor != "" {
+		w.Header().Set("Link", nextLink(r.URL, page.NextCursor))
+	}
+
+	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
 
-// NotifyWebhooks notifies all
-func (m *Manager) NotifyWebhooks(whs []*model.Webhook, temp *Temperature) {
+// NotifyWebhooks notifies all webhooks of a single new temperature
+func (m *Manager) NotifyWebhooks(whs []*model.Webhook, temp *model.Temperature) {
+	notifyWebhooks(whs, temperatureCreatedEvent, func(unit model.Unit) interface{} {
+		return newTemperature(temp, unit)
+	})
+}
 
+// notifyWebhooks posts the payload of an event to every webhook in the unit of the webhook,
+// naming the event in the eventHeader so that receivers can tell single temperatures from batches
+func notifyWebhooks(whs []*model.Webhook, event string, payload func(unit model.Unit) interface{}) {
 	client := &http.Client{}
 
+	byUnit := make(map[model.Unit][]byte)
 	for _, wh := range whs {
-		b, err := json.Marshal(temp)
-		if err != nil {
-			log.Println(err)
-			continue
+		unit := wh.Unit
+		if unit == "" {
+			unit = model.Celsius
+		}
+
+		b, ok := byUnit[unit]
+		if !ok {
+			var err error
+			if b, err = json.Marshal(payload(unit)); err != nil {
+				log.Println(err)
+				continue
+			}
+			byUnit[unit] = b
 		}
 
 		req, err := http.NewRequest("POST", wh.CallbackURL, bytes.NewReader(b))
@@ -98,12 +286,14 @@ func (m *Manager) NotifyWebhooks(whs []*model.Webhook, temp *Temperature) {
 			log.Println(err)
 			continue
 		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(eventHeader, event)
 
-		_, err = client.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
-
+		resp.Body.Close()
 	}
 }
@@ -2,6 +2,7 @@ package service
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -15,6 +16,16 @@ import (
 	"github.com/shaybix/weather-monster/model"
 )
 
+// outlierRows are the columns of the policy and recent temperatures a new temperature is scored against
+var outlierRows = []string{"action", "threshold", "min", "max"}
+
+// expectNoOutlierHistory expects a new temperature to be scored against a city without any
+// temperatures and the default policy
+func expectNoOutlierHistory(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT (.+) FROM cities c").
+		WillReturnRows(sqlmock.NewRows(outlierRows).AddRow("accept", model.DefaultOutlierThreshold, nil, nil))
+}
+
 func Test_CanHandleCreateTemperatureRequest(t *testing.T) {
 	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
 		sm := NewServiceManager(db)
@@ -39,10 +50,11 @@ func Test_CanHandleCreateTemperatureRequest(t *testing.T) {
 
 		client := &http.Client{}
 
-		expectedRows := []string{"ID", "min", "max", "city_id", "timestamp"}
+		expectedRows := []string{"ID", "min", "max", "city_id", "timestamp", "received_at", "flagged", "outlier_score", "station_id"}
+		expectNoOutlierHistory(mock)
 		mock.ExpectQuery("INSERT").WillReturnRows(
 			sqlmock.NewRows(expectedRows).
-				AddRow(1, 20, 24, 1, time.Now().Unix()),
+				AddRow(1, 20, 24, 1, time.Now().Unix(), time.Now().Unix(), false, nil, nil),
 		)
 		resp, err := client.Do(req)
 		if err != nil {
@@ -81,16 +93,343 @@ func Test_CannotHandleCreateTemperatureRequestWithNonExistentCity(t *testing.T)
 
 		client := &http.Client{}
 
-		mock.ExpectQuery("INSERT").WillReturnError(model.ErrNotFound)
+		mock.ExpectQuery("SELECT (.+) FROM cities c").WillReturnRows(sqlmock.NewRows(outlierRows))
 
 		resp, err := client.Do(req)
 		if err != nil {
 			t.Fatalf("could not make request: %v", err)
 		}
 
-		if resp.StatusCode != http.StatusInternalServerError {
-			t.Errorf("expected status internal server error, got %v", resp.StatusCode)
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status not found, got %v", resp.StatusCode)
+		}
+
+	}, t)
+}
+
+func Test_CannotHandleCreateTemperatureRequestWithMinAboveMax(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		m := NewServiceManager(db)
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures", m.CreateTemperatureHandler).Methods("POST")
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		form := url.Values{}
+		form.Add("city_id", "1")
+		form.Add("min", "30")
+		form.Add("max", "20")
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/temperatures", ts.URL), form)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request, got %v", resp.StatusCode)
+		}
+
+		var body ValidationErrors
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("could not decode validation errors: %v", err)
+		}
+
+		if len(body.Errors) != 1 || body.Errors[0].Field != "min" {
+			t.Errorf("expected min to be reported as invalid, got %+v", body.Errors)
+		}
+	}, t)
+}
+
+func Test_CanHandleCreateTemperatureBatchRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures/batch", sm.CreateTemperatureBatchHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}
+		mock.ExpectBegin()
+		prep := mock.ExpectPrepare("INSERT INTO temperatures")
+		expectNoOutlierHistory(mock)
+		prep.ExpectQuery().WithArgs(1, 20.0, 25.0, sqlmock.AnyArg(), sqlmock.AnyArg(), false, nil, nil).WillReturnRows(
+			sqlmock.NewRows(expectedRows).AddRow(1, 20, 25, time.Now().Unix(), 1, time.Now().Unix(), false, nil, nil),
+		)
+		expectNoOutlierHistory(mock)
+		prep.ExpectQuery().WithArgs(1, 18.0, 22.0, sqlmock.AnyArg(), sqlmock.AnyArg(), false, nil, nil).WillReturnRows(
+			sqlmock.NewRows(expectedRows).AddRow(2, 18, 22, time.Now().Unix(), 1, time.Now().Unix(), false, nil, nil),
+		)
+		mock.ExpectCommit()
+
+		body := `[{"city_id": 1, "min": 20, "max": 25}, {"city_id": 1, "min": 18, "max": 22}]`
+		resp, err := http.Post(fmt.Sprintf("%s/temperatures/batch", ts.URL), "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status created got %v", resp.StatusCode)
+		}
+
+		var batch TemperatureBatch
+		if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+			t.Fatalf("could not decode batch: %v", err)
+		}
+
+		if batch.Created != 2 || batch.Failed != 0 || len(batch.Results) != 2 {
+			t.Errorf("expected both temperatures to be created, got %+v", batch)
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateTemperatureBatchRequestWithInvalidItem(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures/batch", sm.CreateTemperatureBatchHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		body := "{\"city_id\": 1, \"min\": 20, \"max\": 25}\n{\"city_id\": 1, \"min\": \"warm\"}\n"
+		resp, err := http.Post(fmt.Sprintf("%s/temperatures/batch", ts.URL), "application/x-ndjson", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("expected status bad request got %v", resp.StatusCode)
+		}
+
+		var batch TemperatureBatch
+		if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+			t.Fatalf("could not decode batch: %v", err)
+		}
+
+		if batch.Results[0].Status != http.StatusFailedDependency || batch.Results[1].Status != http.StatusBadRequest {
+			t.Errorf("expected the valid temperature to be aborted by the invalid one, got %+v", batch.Results)
+		}
+		if len(batch.Results[1].Errors) != 2 {
+			t.Errorf("expected min and max to be reported invalid, got %+v", batch.Results[1].Errors)
+		}
+	}, t)
+}
+
+func Test_CanHandlePartialTemperatureBatchRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures/batch", sm.CreateTemperatureBatchHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		expectedRows := []string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}
+		mock.ExpectBegin()
+		prep := mock.ExpectPrepare("INSERT INTO temperatures")
+		expectNoOutlierHistory(mock)
+		prep.ExpectQuery().WithArgs(1, 20.0, 25.0, sqlmock.AnyArg(), sqlmock.AnyArg(), false, nil, nil).WillReturnRows(
+			sqlmock.NewRows(expectedRows).AddRow(1, 20, 25, time.Now().Unix(), 1, time.Now().Unix(), false, nil, nil),
+		)
+		mock.ExpectCommit()
+
+		body := "{\"city_id\": 1, \"min\": 20, \"max\": 25}\n\n{\"city_id\": 1, \"min\": 30, \"max\": 25}\n"
+		resp, err := http.Post(fmt.Sprintf("%s/temperatures/batch?partial=true", ts.URL), "application/x-ndjson", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusMultiStatus {
+			t.Fatalf("expected status multi-status got %v", resp.StatusCode)
+		}
+
+		var batch TemperatureBatch
+		if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+			t.Fatalf("could not decode batch: %v", err)
+		}
+
+		if batch.Created != 1 || batch.Failed != 1 {
+			t.Errorf("expected one temperature to be created and one to fail, got %+v", batch)
+		}
+	}, t)
+}
+
+func Test_CanHandlePartialTemperatureBatchRequestWithoutValidItems(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures/batch", sm.CreateTemperatureBatchHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		// nothing is stored, so no webhooks are notified of the batch
+		body := "{\"city_id\": 1, \"min\": 30, \"max\": 25}\n{\"city_id\": 1, \"min\": \"warm\"}\n"
+		resp, err := http.Post(fmt.Sprintf("%s/temperatures/batch?partial=true", ts.URL), "application/x-ndjson", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusMultiStatus {
+			t.Fatalf("expected status multi-status got %v", resp.StatusCode)
+		}
+
+		var batch TemperatureBatch
+		if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+			t.Fatalf("could not decode batch: %v", err)
+		}
+
+		if batch.Created != 0 || batch.Failed != 2 {
+			t.Errorf("expected both temperatures to fail, got %+v", batch)
+		}
+	}, t)
+}
+
+func Test_CannotHandleCreateTemperatureRequestWithInvalidObservationTime(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures", sm.CreateTemperatureHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		for _, observedAt := range []string{"yesterday", time.Now().Add(time.Hour).Format(time.RFC3339)} {
+			f := url.Values{}
+			f.Add("city_id", "1")
+			f.Add("min", "20")
+			f.Add("max", "25")
+			f.Add("observed_at", observedAt)
+
+			resp, err := http.PostForm(fmt.Sprintf("%s/temperatures", ts.URL), f)
+			if err != nil {
+				t.Fatalf("could not make request: %v", err)
+			}
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected status bad request for observed_at %q, got %v", observedAt, resp.StatusCode)
+			}
+		}
+	}, t)
+}
+
+func Test_CanHandleCreateTemperatureRequestInFahrenheit(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/temperatures", sm.CreateTemperatureHandler).Methods("POST")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		f := url.Values{}
+		f.Add("city_id", "1")
+		f.Add("min", "71.06")
+		f.Add("max", "77")
+		f.Add("unit", "fahrenheit")
+
+		expectedRows := []string{"ID", "min", "max", "city_id", "timestamp", "received_at", "flagged", "outlier_score", "station_id"}
+		expectNoOutlierHistory(mock)
+		mock.ExpectQuery("INSERT").WithArgs(1, 21.7, 25.0, sqlmock.AnyArg(), sqlmock.AnyArg(), false, nil, nil).WillReturnRows(
+			sqlmock.NewRows(expectedRows).
+				AddRow(1, 21.7, 25, 1, time.Now().Unix(), time.Now().Unix(), false, nil, nil),
+		)
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/temperatures", ts.URL), f)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("expected status created got %v", resp.StatusCode)
+		}
+
+		var temp Temperature
+		if err := json.NewDecoder(resp.Body).Decode(&temp); err != nil {
+			t.Fatalf("could not decode temperature: %v", err)
+		}
+
+		if temp.Unit != "fahrenheit" || temp.Min != 71.06 || temp.Max != 77 {
+			t.Errorf("expected the temperature to be answered in fahrenheit, got %+v", temp)
+		}
+	}, t)
+}
+
+func Test_CanHandleListTemperaturesRequest(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/temperatures", sm.ListTemperaturesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("SELECT CASE").WithArgs(1, from.Unix(), from.AddDate(0, 0, 1).Unix(), "daily", "hourly", "raw").WillReturnRows(
+			sqlmock.NewRows([]string{"resolution"}).AddRow("raw"),
+		)
+		mock.ExpectQuery("SELECT (.+) FROM temperatures").WithArgs(1, from.Unix(), from.AddDate(0, 0, 1).Unix(), 2).WillReturnRows(
+			sqlmock.NewRows([]string{"ID", "min", "max", "timestamp", "city_id", "received_at", "flagged", "outlier_score", "station_id"}).
+				AddRow(1, 10, 20, from.Unix(), 1, from.Unix(), false, nil, nil).
+				AddRow(2, 11, 21, from.Unix()+60, 1, from.Unix(), false, nil, nil),
+		)
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities/1/temperatures?from=2020-01-01T00:00:00Z&to=2020-01-02T00:00:00Z&limit=1", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status ok got %v", resp.StatusCode)
+		}
+
+		var tl TemperatureList
+		if err := json.NewDecoder(resp.Body).Decode(&tl); err != nil {
+			t.Fatalf("could not decode temperatures: %v", err)
+		}
+
+		if tl.Resolution != "raw" || len(tl.Temperatures) != 1 || tl.NextCursor == "" {
+			t.Errorf("expected a single raw temperature and the cursor of the next page, got %+v", tl)
+		}
+
+		if link := resp.Header.Get("Link"); !strings.Contains(link, "cursor="+tl.NextCursor) {
+			t.Errorf("expected a Link header pointing to the next page, got %q", link)
+		}
+	}, t)
+}
+
+func Test_CannotHandleListTemperaturesRequestWithInvalidRange(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		sm := NewServiceManager(db)
+
+		r := mux.NewRouter()
+		r.HandleFunc("/cities/{id}/temperatures", sm.ListTemperaturesHandler).Methods("GET")
+
+		ts := httptest.NewServer(r)
+		defer ts.Close()
+
+		resp, err := http.Get(fmt.Sprintf("%s/cities/1/temperatures?from=2020-01-02T00:00:00Z&to=2020-01-01T00:00:00Z", ts.URL))
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
 		}
 
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status bad request got %v", resp.StatusCode)
+		}
 	}, t)
 }
@@ -0,0 +1,36 @@
+package service
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/shaybix/weather-monster/model"
+)
+
+// requestedUnit returns the unit temperatures are requested in, given either in the units query
+// parameter or as the units parameter of the Accept header, e.g.
+// "Accept: application/json; units=fahrenheit". The query parameter takes precedence. ok is
+// false if no unit is requested, in which case Celsius is returned.
+func requestedUnit(r *http.Request) (unit model.Unit, ok bool, err error) {
+	if v := r.URL.Query().Get("units"); v != "" {
+		unit, err := model.ParseUnit(v)
+		return unit, err == nil, err
+	}
+
+	for _, accept := range r.Header["Accept"] {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			_, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+
+			if v, found := params["units"]; found {
+				unit, err := model.ParseUnit(v)
+				return unit, err == nil, err
+			}
+		}
+	}
+
+	return model.Celsius, false, nil
+}
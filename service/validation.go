@@ -0,0 +1,212 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shaybix/weather-monster/model"
+)
+
+// FieldError describes why the value of a single field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors describes every invalid field of a request
+type ValidationErrors struct {
+	Errors []*FieldError `json:"errors"`
+}
+
+// validate completes the field errors found while parsing a request with those of validating
+// the parsed value, leaving out fields that could not be parsed in the first place. It returns
+// a *model.ValidationError if any field is invalid.
+func validate(ve *model.ValidationError, v interface{ Validate() error }) error {
+	if err := v.Validate(); err != nil {
+		verr, ok := err.(*model.ValidationError)
+		if !ok {
+			return err
+		}
+
+		for _, fe := range verr.Errors {
+			if !ve.Has(fe.Field) {
+				ve.Errors = append(ve.Errors, fe)
+			}
+		}
+	}
+
+	return ve.Err()
+}
+
+// writeValidationError answers a request with invalid fields with 400 Bad Request, listing
+// every invalid field in the body
+func writeValidationError(w http.ResponseWriter, err error) {
+	verr, ok := err.(*model.ValidationError)
+	if !ok {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body := &ValidationErrors{Errors: make([]*FieldError, 0, len(verr.Errors))}
+	for _, fe := range verr.Errors {
+		body.Errors = append(body.Errors, &FieldError{Field: fe.Field, Message: fe.Message})
+	}
+
+	resp, err := json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(resp)
+}
+
+// formFloat parses a required number from the form values, recording the field as invalid
+// if it is missing or not a number
+func formFloat(ve *model.ValidationError, r *http.Request, field string) float64 {
+	value := r.FormValue(field)
+	if value == "" {
+		ve.Add(field, "is required")
+		return 0
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		ve.Add(field, "must be a number, got %q", value)
+		return 0
+	}
+
+	return v
+}
+
+// formInt parses a required integer from the form values, recording the field as invalid
+// if it is missing or not an integer
+func formInt(ve *model.ValidationError, r *http.Request, field string) int64 {
+	value := r.FormValue(field)
+	if value == "" {
+		ve.Add(field, "is required")
+		return 0
+	}
+
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		ve.Add(field, "must be an integer, got %q", value)
+		return 0
+	}
+
+	return v
+}
+
+// formLabels parses optional labels given as comma separated key=value pairs from the form
+// values, recording the field as invalid if they cannot be parsed
+func formLabels(ve *model.ValidationError, r *http.Request, field string) model.Labels {
+	labels, err := model.ParseLabels(r.FormValue(field))
+	if err != nil {
+		ve.Add(field, "%v", err)
+		return nil
+	}
+
+	return labels
+}
+
+// formTime parses an optional RFC 3339 time from the form values, recording the field as invalid
+// if it is not a time. The zero time is returned if the field is not given.
+func formTime(ve *model.ValidationError, r *http.Request, field string) time.Time {
+	value := r.FormValue(field)
+	if value == "" {
+		return time.Time{}
+	}
+
+	v, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		ve.Add(field, "must be an RFC 3339 time, got %q", value)
+		return time.Time{}
+	}
+
+	return v
+}
+
+// formUnit parses the optional unit temperatures are given in from the form values, recording
+// the field as invalid if it is not a unit. Celsius is returned if the field is not given.
+func formUnit(ve *model.ValidationError, r *http.Request, field string) model.Unit {
+	unit, err := model.ParseUnit(r.FormValue(field))
+	if err != nil {
+		ve.Add(field, "must be one of celsius, fahrenheit or kelvin, got %q", r.FormValue(field))
+		return model.Celsius
+	}
+
+	return unit
+}
+
+// formMetric parses the required metric of an observation from the form values, recording the
+// field as invalid if it is missing or not a metric
+func formMetric(ve *model.ValidationError, r *http.Request, field string) model.Metric {
+	value := r.FormValue(field)
+	if value == "" {
+		ve.Add(field, "is required")
+		return ""
+	}
+
+	metric, err := model.ParseMetric(value)
+	if err != nil {
+		ve.Add(field, "%v", err)
+		return ""
+	}
+
+	return metric
+}
+
+// formMetricUnit parses the optional unit a value of metric is given in from the form values,
+// recording the field as invalid if it is not a unit of the metric. The base unit of the metric
+// is returned if the field is not given.
+func formMetricUnit(ve *model.ValidationError, r *http.Request, field string, metric model.Metric) model.MetricUnit {
+	if metric == "" {
+		return ""
+	}
+
+	unit, err := metric.ParseUnit(r.FormValue(field))
+	if err != nil {
+		ve.Add(field, "%v", err)
+		return metric.BaseUnit()
+	}
+
+	return unit
+}
+
+// formDuration parses an optional duration such as "720h" from the form values, recording the
+// field as invalid if it is not a duration. def is returned if the field is not given.
+func formDuration(ve *model.ValidationError, r *http.Request, field string, def time.Duration) time.Duration {
+	value := r.FormValue(field)
+	if value == "" {
+		return def
+	}
+
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		ve.Add(field, "must be a duration such as 720h, got %q", value)
+		return def
+	}
+
+	return v
+}
+
+// formResolution parses the optional resolution of a history from the form values, recording the
+// field as invalid if it is not a resolution. An empty resolution is returned if the field is not given.
+func formResolution(ve *model.ValidationError, r *http.Request, field string) model.Resolution {
+	value := r.FormValue(field)
+	if value == "" {
+		return ""
+	}
+
+	res, err := model.ParseResolution(value)
+	if err != nil {
+		ve.Add(field, "%v", err)
+		return ""
+	}
+
+	return res
+}
@@ -4,19 +4,34 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/shaybix/weather-monster/model"
 )
 
-// Webhook describes a webhook that is created
+// Webhook describes a webhook that is created, targeting either a single city or every city
+// matching its label selector, in the unit temperatures are posted to it in
 type Webhook struct {
 	ID          int64  `json:"id"`
-	CityID      int64  `json:"city_id"`
+	CityID      int64  `json:"city_id,omitempty"`
+	Selector    string `json:"selector,omitempty"`
 	CallbackURL string `json:"callback_url"`
+	Unit        string `json:"unit"`
 }
 
-// CreateWebhookHandler describes an endpoint that creates a webhook for a specified city
+func newWebhook(wh *model.Webhook) *Webhook {
+	return &Webhook{
+		ID:          wh.ID,
+		CityID:      wh.CityID,
+		Selector:    wh.Selector,
+		CallbackURL: wh.CallbackURL,
+		Unit:        string(wh.Unit),
+	}
+}
+
+// CreateWebhookHandler describes an endpoint that creates a webhook for a specified city, or for
+// every city matching the label selector given in place of the city
 func (m *Manager) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -25,15 +40,23 @@ func (m *Manager) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cid, err := strconv.Atoi(r.FormValue("city_id"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	var ve model.ValidationError
+	nw := &model.NewWebhook{
+		Selector:    strings.TrimSpace(r.FormValue("selector")),
+		CallbackURL: strings.TrimSpace(r.FormValue("callback_url")),
+		Unit:        formUnit(&ve, r, "unit"),
+	}
+	if nw.Selector == "" || r.FormValue("city_id") != "" {
+		nw.CityID = formInt(&ve, r, "city_id")
+	}
+	if sel, err := model.ParseSelector(nw.Selector); err == nil && len(sel) > 0 {
+		// selectors are stored normalised, so that the same one is not subscribed to twice
+		nw.Selector = sel.String()
 	}
 
-	nw := &model.NewWebhook{
-		CityID:      int64(cid),
-		CallbackURL: r.FormValue("callback_url"),
+	if err := validate(&ve, nw); err != nil {
+		writeValidationError(w, err)
+		return
 	}
 
 	wh, err := m.WM.Create(nw)
@@ -52,11 +75,7 @@ func (m *Manager) CreateWebhookHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	b, err := json.Marshal(&Webhook{
-		ID:          wh.ID,
-		CityID:      wh.CityID,
-		CallbackURL: wh.CallbackURL,
-	})
+	b, err := json.Marshal(newWebhook(wh))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -88,11 +107,7 @@ func (m *Manager) DeleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	b, err := json.Marshal(&Webhook{
-		ID:          wh.ID,
-		CityID:      wh.CityID,
-		CallbackURL: wh.CallbackURL,
-	})
+	b, err := json.Marshal(newWebhook(wh))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -34,10 +34,10 @@ func Test_CanHandleCreateWebhookRequest(t *testing.T) {
 
 		client := &http.Client{}
 
-		expectedRows := []string{"ID", "city_id", "callback_url"}
+		expectedRows := []string{"ID", "city_id", "callback_url", "selector", "unit"}
 		mock.ExpectQuery("INSERT INTO").WillReturnRows(
 			sqlmock.NewRows(expectedRows).
-				AddRow(1, 1, "example.com/webhook"),
+				AddRow(1, 1, "example.com/webhook", nil, "celsius"),
 		)
 		resp, err := client.Do(req)
 		if err != nil {
@@ -131,12 +131,14 @@ func Test_CanHandleDeleteWebhookRequest(t *testing.T) {
 
 		client := &http.Client{}
 
-		expectedRows := []string{"ID", "city_id", "callback_urlr"}
+		expectedRows := []string{"ID", "city_id", "callback_urlr", "selector", "unit"}
 		mock.ExpectQuery("DELETE").WillReturnRows(
 			sqlmock.NewRows(expectedRows).AddRow(
 				1,
 				1,
 				"http://example.com/callback",
+				nil,
+				"celsius",
 			),
 		)
 
@@ -178,3 +180,34 @@ func Test_CannotHandleDeleteWebhookRequestWithNonExistentWebhook(t *testing.T) {
 		}
 	}, t)
 }
+
+func Test_CanHandleCreateWebhookRequestWithSelector(t *testing.T) {
+	withServiceTestDB(func(db *sql.DB, mock sqlmock.Sqlmock, t *testing.T) {
+		m := NewServiceManager(db)
+		router := mux.NewRouter()
+		router.HandleFunc("/webhooks", m.CreateWebhookHandler).Methods("POST")
+		ts := httptest.NewServer(router)
+
+		form := url.Values{}
+		form.Add("selector", "tier = gold, region in (eu,us)")
+		form.Add("callback_url", "http://example.com/temps")
+		form.Add("unit", "F")
+
+		expectedRows := []string{"ID", "city_id", "callback_url", "selector", "unit"}
+		mock.ExpectQuery("INSERT INTO webhooks").
+			WithArgs("tier=gold,region in (eu,us)", "http://example.com/temps", "fahrenheit", `[{"key":"tier","operator":"=","values":["gold"]},{"key":"region","operator":"in","values":["eu","us"]}]`).
+			WillReturnRows(
+				sqlmock.NewRows(expectedRows).
+					AddRow(1, nil, "http://example.com/temps", "tier=gold,region in (eu,us)", "fahrenheit"),
+			)
+
+		resp, err := http.PostForm(fmt.Sprintf("%s/webhooks", ts.URL), form)
+		if err != nil {
+			t.Fatalf("could not make request: %v", err)
+		}
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("expected Status created, got %v", resp.StatusCode)
+		}
+	}, t)
+}
@@ -0,0 +1,333 @@
+package timezone
+
+// references are locations with a known time zone, mostly the major cities of each zone.
+// Zones covering large areas are given several locations across their extent.
+var references = []reference{
+	// Europe
+	{"Europe/London", 51.507, -0.128},
+	{"Europe/London", 53.480, -2.243},
+	{"Europe/London", 55.953, -3.188},
+	{"Europe/Dublin", 53.350, -6.260},
+	{"Europe/Lisbon", 38.722, -9.139},
+	{"Europe/Lisbon", 41.158, -8.629},
+	{"Atlantic/Reykjavik", 64.147, -21.942},
+	{"Atlantic/Canary", 28.124, -15.430},
+	{"Atlantic/Azores", 37.741, -25.676},
+	{"Europe/Madrid", 40.417, -3.704},
+	{"Europe/Madrid", 41.385, 2.173},
+	{"Europe/Madrid", 37.389, -5.984},
+	{"Europe/Paris", 48.857, 2.352},
+	{"Europe/Paris", 43.296, 5.370},
+	{"Europe/Paris", 44.838, -0.579},
+	{"Europe/Brussels", 50.850, 4.352},
+	{"Europe/Amsterdam", 52.370, 4.895},
+	{"Europe/Luxembourg", 49.612, 6.130},
+	{"Europe/Berlin", 52.520, 13.405},
+	{"Europe/Berlin", 48.135, 11.582},
+	{"Europe/Berlin", 53.551, 9.994},
+	{"Europe/Berlin", 50.938, 6.960},
+	{"Europe/Zurich", 47.377, 8.541},
+	{"Europe/Vienna", 48.208, 16.374},
+	{"Europe/Rome", 41.903, 12.496},
+	{"Europe/Rome", 45.464, 9.190},
+	{"Europe/Rome", 38.116, 13.361},
+	{"Europe/Malta", 35.899, 14.514},
+	{"Europe/Copenhagen", 55.676, 12.568},
+	{"Europe/Oslo", 59.914, 10.752},
+	{"Europe/Oslo", 63.431, 10.395},
+	{"Europe/Oslo", 69.649, 18.956},
+	{"Europe/Stockholm", 59.329, 18.069},
+	{"Europe/Stockholm", 65.584, 22.155},
+	{"Europe/Helsinki", 60.170, 24.938},
+	{"Europe/Helsinki", 65.012, 25.465},
+	{"Europe/Tallinn", 59.437, 24.754},
+	{"Europe/Riga", 56.950, 24.105},
+	{"Europe/Vilnius", 54.687, 25.280},
+	{"Europe/Warsaw", 52.230, 21.012},
+	{"Europe/Warsaw", 50.065, 19.945},
+	{"Europe/Prague", 50.076, 14.438},
+	{"Europe/Bratislava", 48.149, 17.107},
+	{"Europe/Budapest", 47.498, 19.040},
+	{"Europe/Ljubljana", 46.057, 14.506},
+	{"Europe/Zagreb", 45.815, 15.982},
+	{"Europe/Belgrade", 44.787, 20.457},
+	{"Europe/Sarajevo", 43.856, 18.413},
+	{"Europe/Podgorica", 42.441, 19.263},
+	{"Europe/Tirane", 41.328, 19.818},
+	{"Europe/Skopje", 41.998, 21.425},
+	{"Europe/Sofia", 42.698, 23.322},
+	{"Europe/Bucharest", 44.427, 26.103},
+	{"Europe/Chisinau", 47.011, 28.864},
+	{"Europe/Athens", 37.984, 23.728},
+	{"Asia/Nicosia", 35.186, 33.382},
+	{"Europe/Istanbul", 41.008, 28.978},
+	{"Europe/Istanbul", 39.933, 32.860},
+	{"Europe/Istanbul", 39.905, 41.265},
+	{"Europe/Kiev", 50.450, 30.523},
+	{"Europe/Kiev", 46.482, 30.723},
+	{"Europe/Kiev", 49.839, 24.030},
+	{"Europe/Minsk", 53.904, 27.562},
+	{"Europe/Kaliningrad", 54.710, 20.452},
+	{"Europe/Moscow", 55.756, 37.617},
+	{"Europe/Moscow", 59.931, 30.361},
+	{"Europe/Moscow", 45.035, 38.975},
+	{"Europe/Moscow", 56.327, 44.006},
+	{"Europe/Moscow", 68.970, 33.075},
+	{"Europe/Samara", 53.195, 50.101},
+	{"Europe/Volgograd", 48.708, 44.513},
+
+	// Caucasus, Middle East and Central Asia
+	{"Asia/Tbilisi", 41.716, 44.783},
+	{"Asia/Yerevan", 40.179, 44.499},
+	{"Asia/Baku", 40.409, 49.867},
+	{"Asia/Jerusalem", 31.768, 35.214},
+	{"Asia/Beirut", 33.894, 35.502},
+	{"Asia/Damascus", 33.513, 36.276},
+	{"Asia/Amman", 31.954, 35.911},
+	{"Asia/Baghdad", 33.315, 44.366},
+	{"Asia/Riyadh", 24.713, 46.675},
+	{"Asia/Riyadh", 21.543, 39.173},
+	{"Asia/Kuwait", 29.376, 47.977},
+	{"Asia/Qatar", 25.285, 51.531},
+	{"Asia/Dubai", 25.205, 55.271},
+	{"Asia/Muscat", 23.588, 58.383},
+	{"Asia/Aden", 12.786, 45.019},
+	{"Asia/Tehran", 35.689, 51.389},
+	{"Asia/Tehran", 29.591, 52.584},
+	{"Asia/Tehran", 36.297, 59.606},
+	{"Asia/Kabul", 34.555, 69.207},
+	{"Asia/Karachi", 24.861, 67.010},
+	{"Asia/Karachi", 31.520, 74.359},
+	{"Asia/Tashkent", 41.299, 69.240},
+	{"Asia/Ashgabat", 37.960, 58.326},
+	{"Asia/Dushanbe", 38.560, 68.774},
+	{"Asia/Bishkek", 42.875, 74.570},
+	{"Asia/Almaty", 43.222, 76.851},
+	{"Asia/Almaty", 51.169, 71.449},
+	{"Asia/Aqtobe", 50.283, 57.167},
+	{"Asia/Yekaterinburg", 56.838, 60.597},
+	{"Asia/Yekaterinburg", 55.160, 61.403},
+	{"Asia/Omsk", 54.989, 73.369},
+	{"Asia/Novosibirsk", 55.008, 82.935},
+	{"Asia/Krasnoyarsk", 56.015, 92.893},
+	{"Asia/Krasnoyarsk", 69.349, 88.201},
+	{"Asia/Irkutsk", 52.287, 104.305},
+	{"Asia/Yakutsk", 62.035, 129.675},
+	{"Asia/Vladivostok", 43.115, 131.886},
+	{"Asia/Magadan", 59.568, 150.808},
+	{"Asia/Kamchatka", 53.024, 158.643},
+	{"Asia/Anadyr", 64.734, 177.515},
+
+	// South and East Asia
+	{"Asia/Kolkata", 28.614, 77.209},
+	{"Asia/Kolkata", 19.076, 72.878},
+	{"Asia/Kolkata", 12.972, 77.595},
+	{"Asia/Kolkata", 22.573, 88.364},
+	{"Asia/Kolkata", 13.083, 80.271},
+	{"Asia/Colombo", 6.927, 79.861},
+	{"Asia/Kathmandu", 27.717, 85.324},
+	{"Asia/Thimphu", 27.472, 89.639},
+	{"Asia/Dhaka", 23.810, 90.413},
+	{"Asia/Yangon", 16.867, 96.195},
+	{"Asia/Bangkok", 13.756, 100.502},
+	{"Asia/Vientiane", 17.975, 102.633},
+	{"Asia/Phnom_Penh", 11.556, 104.928},
+	{"Asia/Ho_Chi_Minh", 10.823, 106.630},
+	{"Asia/Ho_Chi_Minh", 21.028, 105.834},
+	{"Asia/Kuala_Lumpur", 3.139, 101.687},
+	{"Asia/Kuching", 1.553, 110.359},
+	{"Asia/Singapore", 1.352, 103.820},
+	{"Asia/Jakarta", -6.208, 106.846},
+	{"Asia/Jakarta", -7.257, 112.752},
+	{"Asia/Jakarta", 3.595, 98.672},
+	{"Asia/Makassar", -5.148, 119.432},
+	{"Asia/Makassar", -8.650, 115.217},
+	{"Asia/Jayapura", -2.533, 140.717},
+	{"Asia/Manila", 14.600, 120.984},
+	{"Asia/Manila", 7.190, 125.455},
+	{"Asia/Shanghai", 31.230, 121.474},
+	{"Asia/Shanghai", 39.904, 116.407},
+	{"Asia/Shanghai", 23.129, 113.264},
+	{"Asia/Shanghai", 30.573, 104.066},
+	{"Asia/Shanghai", 45.803, 126.535},
+	{"Asia/Shanghai", 36.061, 103.834},
+	{"Asia/Urumqi", 43.825, 87.617},
+	{"Asia/Hong_Kong", 22.320, 114.169},
+	{"Asia/Taipei", 25.033, 121.565},
+	{"Asia/Ulaanbaatar", 47.886, 106.906},
+	{"Asia/Hovd", 48.005, 91.642},
+	{"Asia/Pyongyang", 39.039, 125.762},
+	{"Asia/Seoul", 37.567, 126.978},
+	{"Asia/Seoul", 35.180, 129.075},
+	{"Asia/Tokyo", 35.690, 139.692},
+	{"Asia/Tokyo", 34.694, 135.502},
+	{"Asia/Tokyo", 43.062, 141.354},
+	{"Asia/Tokyo", 26.212, 127.681},
+
+	// Africa
+	{"Africa/Cairo", 30.044, 31.236},
+	{"Africa/Tripoli", 32.887, 13.191},
+	{"Africa/Tunis", 36.806, 10.181},
+	{"Africa/Algiers", 36.754, 3.059},
+	{"Africa/Algiers", 27.878, -0.285},
+	{"Africa/Casablanca", 33.573, -7.590},
+	{"Africa/El_Aaiun", 27.154, -13.200},
+	{"Africa/Nouakchott", 18.079, -15.965},
+	{"Africa/Dakar", 14.716, -17.467},
+	{"Africa/Bamako", 12.639, -8.003},
+	{"Africa/Abidjan", 5.360, -4.008},
+	{"Africa/Accra", 5.603, -0.187},
+	{"Africa/Lagos", 6.524, 3.379},
+	{"Africa/Lagos", 9.076, 7.399},
+	{"Africa/Niamey", 13.512, 2.113},
+	{"Africa/Ndjamena", 12.134, 15.056},
+	{"Africa/Khartoum", 15.501, 32.560},
+	{"Africa/Juba", 4.859, 31.571},
+	{"Africa/Addis_Ababa", 9.030, 38.740},
+	{"Africa/Nairobi", -1.292, 36.822},
+	{"Africa/Mogadishu", 2.047, 45.318},
+	{"Africa/Kampala", 0.348, 32.583},
+	{"Africa/Kigali", -1.944, 30.062},
+	{"Africa/Dar_es_Salaam", -6.792, 39.208},
+	{"Africa/Kinshasa", -4.441, 15.266},
+	{"Africa/Lubumbashi", -11.665, 27.479},
+	{"Africa/Luanda", -8.839, 13.289},
+	{"Africa/Lusaka", -15.387, 28.323},
+	{"Africa/Harare", -17.825, 31.034},
+	{"Africa/Maputo", -25.969, 32.573},
+	{"Africa/Windhoek", -22.560, 17.066},
+	{"Africa/Gaborone", -24.628, 25.923},
+	{"Africa/Johannesburg", -26.204, 28.047},
+	{"Africa/Johannesburg", -33.925, 18.424},
+	{"Africa/Johannesburg", -29.858, 31.022},
+	{"Indian/Antananarivo", -18.879, 47.508},
+	{"Indian/Mauritius", -20.161, 57.499},
+
+	// North America
+	{"America/New_York", 40.713, -74.006},
+	{"America/New_York", 42.360, -71.059},
+	{"America/New_York", 38.907, -77.037},
+	{"America/New_York", 33.749, -84.388},
+	{"America/New_York", 25.762, -80.192},
+	{"America/Detroit", 42.331, -83.046},
+	{"America/Indiana/Indianapolis", 39.768, -86.158},
+	{"America/Chicago", 41.878, -87.630},
+	{"America/Chicago", 29.760, -95.370},
+	{"America/Chicago", 32.777, -96.797},
+	{"America/Chicago", 44.978, -93.265},
+	{"America/Chicago", 29.951, -90.072},
+	{"America/Chicago", 39.100, -94.579},
+	{"America/Denver", 39.739, -104.990},
+	{"America/Denver", 40.761, -111.891},
+	{"America/Denver", 35.084, -106.650},
+	{"America/Denver", 46.587, -112.018},
+	{"America/Boise", 43.615, -116.202},
+	{"America/Phoenix", 33.448, -112.074},
+	{"America/Los_Angeles", 34.052, -118.244},
+	{"America/Los_Angeles", 37.775, -122.419},
+	{"America/Los_Angeles", 47.606, -122.332},
+	{"America/Los_Angeles", 45.505, -122.675},
+	{"America/Los_Angeles", 36.170, -115.140},
+	{"America/Anchorage", 61.218, -149.900},
+	{"America/Anchorage", 64.838, -147.716},
+	{"America/Juneau", 58.302, -134.420},
+	{"Pacific/Honolulu", 21.307, -157.858},
+	{"America/Toronto", 43.653, -79.383},
+	{"America/Toronto", 45.421, -75.697},
+	{"America/Toronto", 45.502, -73.567},
+	{"America/Toronto", 46.813, -71.208},
+	{"America/Halifax", 44.649, -63.576},
+	{"America/Moncton", 46.088, -64.778},
+	{"America/St_Johns", 47.562, -52.713},
+	{"America/Winnipeg", 49.895, -97.138},
+	{"America/Regina", 50.445, -104.619},
+	{"America/Edmonton", 53.546, -113.494},
+	{"America/Edmonton", 51.045, -114.072},
+	{"America/Vancouver", 49.283, -123.121},
+	{"America/Whitehorse", 60.721, -135.057},
+	{"America/Yellowknife", 62.454, -114.372},
+	{"America/Iqaluit", 63.747, -68.517},
+	{"America/Nuuk", 64.181, -51.694},
+	{"America/Mexico_City", 19.433, -99.133},
+	{"America/Mexico_City", 20.659, -103.350},
+	{"America/Monterrey", 25.687, -100.316},
+	{"America/Cancun", 21.162, -86.851},
+	{"America/Chihuahua", 28.632, -106.069},
+	{"America/Hermosillo", 29.073, -110.956},
+	{"America/Mazatlan", 23.249, -106.411},
+	{"America/Tijuana", 32.515, -117.038},
+	{"America/Guatemala", 14.634, -90.507},
+	{"America/Belize", 17.251, -88.759},
+	{"America/El_Salvador", 13.693, -89.218},
+	{"America/Tegucigalpa", 14.072, -87.192},
+	{"America/Managua", 12.114, -86.236},
+	{"America/Costa_Rica", 9.928, -84.091},
+	{"America/Panama", 8.983, -79.517},
+	{"America/Havana", 23.113, -82.366},
+	{"America/Jamaica", 18.018, -76.810},
+	{"America/Port-au-Prince", 18.594, -72.307},
+	{"America/Santo_Domingo", 18.486, -69.931},
+	{"America/Puerto_Rico", 18.466, -66.106},
+
+	// South America
+	{"America/Bogota", 4.711, -74.072},
+	{"America/Caracas", 10.481, -66.904},
+	{"America/Guyana", 6.801, -58.155},
+	{"America/Paramaribo", 5.852, -55.204},
+	{"America/Cayenne", 4.922, -52.313},
+	{"America/Guayaquil", -2.170, -79.922},
+	{"America/Guayaquil", -0.181, -78.468},
+	{"Pacific/Galapagos", -0.954, -90.966},
+	{"America/Lima", -12.046, -77.043},
+	{"America/La_Paz", -16.490, -68.119},
+	{"America/Sao_Paulo", -23.551, -46.633},
+	{"America/Sao_Paulo", -22.907, -43.173},
+	{"America/Sao_Paulo", -15.794, -47.882},
+	{"America/Sao_Paulo", -30.035, -51.218},
+	{"America/Bahia", -12.971, -38.511},
+	{"America/Fortaleza", -3.732, -38.527},
+	{"America/Recife", -8.048, -34.877},
+	{"America/Belem", -1.456, -48.490},
+	{"America/Manaus", -3.119, -60.022},
+	{"America/Cuiaba", -15.601, -56.097},
+	{"America/Porto_Velho", -8.762, -63.904},
+	{"America/Rio_Branco", -9.975, -67.825},
+	{"America/Asuncion", -25.264, -57.576},
+	{"America/Montevideo", -34.901, -56.164},
+	{"America/Argentina/Buenos_Aires", -34.604, -58.382},
+	{"America/Argentina/Cordoba", -31.420, -64.189},
+	{"America/Argentina/Mendoza", -32.890, -68.845},
+	{"America/Argentina/Ushuaia", -54.802, -68.303},
+	{"America/Santiago", -33.449, -70.669},
+	{"America/Santiago", -41.469, -72.942},
+	{"America/Punta_Arenas", -53.164, -70.917},
+	{"Atlantic/Stanley", -51.700, -57.850},
+
+	// Oceania
+	{"Australia/Perth", -31.950, 115.860},
+	{"Australia/Darwin", -12.463, 130.842},
+	{"Australia/Adelaide", -34.929, 138.601},
+	{"Australia/Brisbane", -27.470, 153.026},
+	{"Australia/Brisbane", -19.259, 146.816},
+	{"Australia/Brisbane", -16.920, 145.771},
+	{"Australia/Sydney", -33.869, 151.209},
+	{"Australia/Sydney", -35.281, 149.130},
+	{"Australia/Melbourne", -37.814, 144.963},
+	{"Australia/Hobart", -42.882, 147.327},
+	{"Pacific/Port_Moresby", -9.443, 147.180},
+	{"Pacific/Guadalcanal", -9.446, 159.973},
+	{"Pacific/Noumea", -22.275, 166.458},
+	{"Pacific/Efate", -17.734, 168.322},
+	{"Pacific/Fiji", -18.142, 178.442},
+	{"Pacific/Auckland", -36.849, 174.763},
+	{"Pacific/Auckland", -41.287, 174.776},
+	{"Pacific/Auckland", -43.532, 172.637},
+	{"Pacific/Chatham", -43.954, -176.560},
+	{"Pacific/Tongatapu", -21.139, -175.205},
+	{"Pacific/Apia", -13.834, -171.760},
+	{"Pacific/Tahiti", -17.535, -149.570},
+	{"Pacific/Guam", 13.444, 144.794},
+	{"Pacific/Tarawa", 1.451, 172.971},
+	{"Pacific/Kiritimati", 1.872, -157.430},
+	{"Pacific/Majuro", 7.090, 171.380},
+}
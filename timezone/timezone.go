@@ -0,0 +1,82 @@
+// Package timezone resolves the IANA time zone of a location on earth without any external
+// service, using a table of reference locations embedded in the binary.
+package timezone
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// maxReferenceDistanceKm is how far a location may lie from its closest reference location
+// for that reference's time zone to be used. Locations farther away, e.g. out at sea, fall
+// back to the nautical time zone of their longitude.
+const maxReferenceDistanceKm = 1000
+
+// earthRadiusKm is the mean radius of the earth
+const earthRadiusKm = 6371.0
+
+// reference describes a location whose time zone is known
+type reference struct {
+	zone string
+	lat  float64
+	lon  float64
+}
+
+// Lookup returns the IANA time zone of the given location. The zone is taken from the closest
+// reference location, so locations near a border may be attributed to the neighbouring zone;
+// such cities should be given their time zone explicitly.
+func Lookup(lat, lon float64) string {
+	var closest *reference
+	min := math.Inf(1)
+	for i := range references {
+		ref := &references[i]
+		if d := distanceKm(lat, lon, ref.lat, ref.lon); d < min {
+			min = d
+			closest = ref
+		}
+	}
+
+	if closest != nil && min <= maxReferenceDistanceKm {
+		return closest.zone
+	}
+
+	return nautical(lon)
+}
+
+// Validate checks that a time zone is a known IANA time zone. The empty string and "Local"
+// are rejected as they depend on the server the application runs on.
+func Validate(zone string) error {
+	if zone == "" || zone == "Local" {
+		return fmt.Errorf("%q is not an IANA time zone", zone)
+	}
+
+	if _, err := time.LoadLocation(zone); err != nil {
+		return fmt.Errorf("%q is not an IANA time zone: %v", zone, err)
+	}
+
+	return nil
+}
+
+// nautical returns the fixed-offset zone of the 15° wide band a longitude lies in. Note that
+// the sign of the Etc/GMT zones is inverted, Etc/GMT-1 being one hour ahead of UTC.
+func nautical(lon float64) string {
+	offset := int(math.Round(lon / 15))
+	if offset == 0 {
+		return "UTC"
+	}
+
+	return fmt.Sprintf("Etc/GMT%+d", -offset)
+}
+
+// distanceKm returns the great-circle distance between two locations using the haversine formula
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+
+	dlat := (lat2 - lat1) * rad
+	dlon := (lon2 - lon1) * rad
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,38 @@
+package timezone
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CanLookupTimeZoneOfReferencedLocation(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal("Europe/Berlin", Lookup(52.39, 13.06))
+	r.Equal("America/Chicago", Lookup(33.66, -95.55))
+	r.Equal("Australia/Sydney", Lookup(-33.80, 151.00))
+}
+
+func Test_LookupFallsBackToNauticalTimeZoneAtSea(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal("Etc/GMT+2", Lookup(0, -30))
+	r.Equal("UTC", Lookup(-30, -5))
+}
+
+func Test_ReferencesAreValidTimeZones(t *testing.T) {
+	r := require.New(t)
+
+	for _, ref := range references {
+		r.NoError(Validate(ref.zone))
+	}
+}
+
+func Test_CannotValidateServerDependentTimeZones(t *testing.T) {
+	r := require.New(t)
+
+	r.Error(Validate(""))
+	r.Error(Validate("Local"))
+	r.Error(Validate("Mars/Olympus_Mons"))
+}